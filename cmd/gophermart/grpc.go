@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/xbreathoflife/gophermart/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+)
+
+type grpcServer interface {
+	GRPCServer(opts ...grpc.ServerOption) *grpc.Server
+}
+
+// serveGRPC starts the gRPC API on GRPC_ADDRESS, with the TLS configuration of
+// the HTTP server if any. It returns nil if GRPC_ADDRESS is empty.
+func serveGRPC(conf config.Config, gs grpcServer, tlsConfig *tls.Config, logger *zap.Logger) (*grpc.Server, error) {
+	if conf.GRPCAddress == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", conf.GRPCAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	srv := gs.GRPCServer(opts...)
+	go func() {
+		logger.Info("listening for gRPC", zap.String("address", conf.GRPCAddress), zap.Bool("tls", tlsConfig != nil))
+		if err := srv.Serve(lis); err != nil {
+			logger.Fatal("error while serving gRPC", zap.Error(err))
+		}
+	}()
+	return srv, nil
+}
+
+// stopGRPC waits for the running calls until ctx is done, then cancels them.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
@@ -5,48 +5,198 @@ import (
 	"flag"
 	"fmt"
 	"github.com/xbreathoflife/gophermart/config"
+	"github.com/xbreathoflife/gophermart/internal/app/compress"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/cors"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
+	"github.com/xbreathoflife/gophermart/internal/app/outbox"
+	"github.com/xbreathoflife/gophermart/internal/app/ratelimit"
 	"github.com/xbreathoflife/gophermart/internal/app/server"
-	"github.com/xbreathoflife/gophermart/internal/app/storage"
-	"log"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/instrumented"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"go.uber.org/zap"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func parseFlags(conf *config.Config) {
-	address := flag.String("a", "", "Адрес запуска HTTP-сервера")
-	connString := flag.String("d", "", "Строка с адресом подключения к БД")
-	serviceAddress := flag.String("r", "", "Адрес системы расчёта начислений: переменная окружения ОС")
-	flag.Parse()
+func parseRateLimits(conf config.Config) (server.RateLimits, error) {
+	var limits server.RateLimits
+	var err error
+	if limits.Auth, err = ratelimit.ParseLimit(conf.RateLimitAuth); err != nil {
+		return limits, err
+	}
+	if limits.Orders, err = ratelimit.ParseLimit(conf.RateLimitOrders); err != nil {
+		return limits, err
+	}
+	limits.API, err = ratelimit.ParseLimit(conf.RateLimitAPI)
+	return limits, err
+}
 
-	if *address != "" {
-		conf.Address = *address
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	conf, cmd, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cmd.PrintConfig {
+		if err := conf.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	core.SetSessionKey(conf.SessionKey)
 
-	if *connString != "" {
-		conf.ConnString = *connString
+	logger, err := logging.New(conf.LogLevel)
+	if err != nil {
+		fmt.Printf("Error while configuring logging: %v\n", err)
+		return
+	}
+	defer func() { _ = logger.Sync() }()
+	if conf.SessionKey == core.DefaultSessionKey && conf.ConnString != "" {
+		// not an error, the default key keeps the service runnable with only the
+		// flags of the specification
+		logger.Warn("SESSION_KEY is not set, persistent sessions are encrypted with the public default key " +
+			"and can be forged, set a secret one")
 	}
 
-	if *serviceAddress != "" {
-		conf.ServiceAddress = *serviceAddress
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:     conf.TracingExporter,
+		OTLPEndpoint: conf.OTLPEndpoint,
+		OTLPInsecure: conf.OTLPInsecure,
+		SampleRatio:  conf.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Error("error while configuring tracing", zap.Error(err))
+		return
 	}
-}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("error while flushing traces", zap.Error(err))
+		}
+	}()
 
-func main() {
-	conf := config.Init()
-	parseFlags(&conf)
+	stores, err := openStorages(conf, logger)
+	if err != nil {
+		logger.Error("error while opening storages", zap.Error(err))
+		return
+	}
+	defer stores.Close()
+	stores.instrument(instrumented.Chain(tracing.ObserveStorage, metrics.ObserveDB, logging.ObserveStorage(logger)))
+	if err := metrics.RegisterOrders(stores.Order); err != nil {
+		logger.Error("error while registering metrics", zap.Error(err))
+		return
+	}
 
-	dbStorage := storage.NewDBStorage(conf.ConnString)
-	err := dbStorage.Init(context.Background())
+	sinks, err := outbox.ParseSinks(conf.OutboxSinks, logger)
 	if err != nil {
-		fmt.Printf("Error while initializing storage: %v\n", err)
+		logger.Error("error while configuring outbox", zap.Error(err))
 		return
 	}
+	relay := outbox.NewRelay(stores.Outbox, sinks, conf.OutboxInterval, conf.OutboxMaxAttempts,
+		conf.OutboxRetention, logger)
+	go relay.Run(context.Background())
 
-	balanceStorage := storage.NewBalanceStorage(conf.ConnString)
-	orderStorage := storage.NewOrderStorage(conf.ConnString)
-	userStorage := storage.NewUserStorage(conf.ConnString)
+	rateLimits, err := parseRateLimits(conf)
+	if err != nil {
+		logger.Error("error while configuring rate limits", zap.Error(err))
+		return
+	}
+
+	trustedProxies, err := ratelimit.ParseNetworks(conf.TrustedProxies)
+	if err != nil {
+		logger.Error("error while configuring trusted proxies", zap.Error(err))
+		return
+	}
+
+	csrfMode, err := csrf.ParseMode(conf.CSRFMode)
+	if err != nil {
+		logger.Error("error while configuring CSRF protection", zap.Error(err))
+		return
+	}
 
-	gophermartServer := server.NewGothServer(balanceStorage, orderStorage, userStorage, conf.ServiceAddress)
-	r := gophermartServer.ServerHandler()
+	gophermartServer := server.NewGothServer(stores.Storages, server.Options{
+		Accrual: core.AccrualConfig{
+			Address:          conf.ServiceAddress,
+			Workers:          conf.AccrualWorkers,
+			QueueSize:        conf.AccrualQueueSize,
+			Timeout:          conf.AccrualTimeout,
+			RateLimitDelay:   conf.AccrualRateLimitDelay,
+			CircuitThreshold: conf.AccrualCircuitThreshold,
+			CircuitCooldown:  conf.AccrualCircuitCooldown,
+		},
+		Webhooks: core.WebhookConfig{
+			QueueSize:            conf.WebhookQueueSize,
+			MaxAttempts:          conf.WebhookMaxAttempts,
+			Timeout:              conf.WebhookTimeout,
+			AllowPrivateNetworks: conf.WebhookAllowPrivate,
+		},
+		Compression: compress.Config{
+			Level:          conf.CompressLevel,
+			MinSize:        conf.CompressMinSize,
+			ContentTypes:   conf.CompressTypes,
+			MaxDecodedSize: conf.MaxDecodedBodySize,
+		},
+		RateLimits:     rateLimits,
+		TrustedProxies: trustedProxies,
+		CORS:           cors.Config{AllowedOrigins: conf.CORSAllowedOrigins, MaxAge: conf.CORSMaxAge},
+		CSRF:           csrf.Config{Mode: csrfMode, Secret: conf.CSRFSecret},
+		Partners:       server.Partners{Enabled: conf.TLSClientCAFile != "", Names: conf.TLSPartnerNames},
+	}, logger)
+	srv := &http.Server{Addr: conf.Address, Handler: gophermartServer.ServerHandler(),
+		ErrorLog: zap.NewStdLog(logger.With(zap.String("component", "http")))}
 
-	log.Fatal(http.ListenAndServe(conf.Address, r))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	redirectSrv, err := setupTLS(ctx, conf, srv, logger)
+	if err != nil {
+		logger.Error("error while configuring TLS", zap.Error(err))
+		return
+	}
+	grpcSrv, err := serveGRPC(conf, gophermartServer, srv.TLSConfig, logger)
+	if err != nil {
+		logger.Error("error while starting gRPC", zap.Error(err))
+		return
+	}
+	go func() {
+		logger.Info("listening", zap.String("address", conf.Address), zap.Bool("tls", srv.TLSConfig != nil))
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("error while serving", zap.Error(err))
+		}
+	}()
+	<-ctx.Done()
+
+	// keep serving while the orchestrator notices that readiness is gone
+	logger.Info("shutting down")
+	gophermartServer.Shutdown()
+	time.Sleep(conf.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if redirectSrv != nil {
+		_ = redirectSrv.Shutdown(shutdownCtx)
+	}
+	if grpcSrv != nil {
+		stopGRPC(shutdownCtx, grpcSrv)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error while shutting down", zap.Error(err))
+	}
 }
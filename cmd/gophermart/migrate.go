@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/config"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/migrations"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `Usage: gophermart migrate [-d connection string] <command>
+
+Commands:
+  up           apply all pending migrations
+  down [n]     roll back the n latest migrations, 1 by default
+  status       show applied and pending migrations
+`
+
+// runMigrate handles the migrate subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	connString := flags.String("d", "", "Строка с адресом подключения к БД")
+	flags.Usage = func() { fmt.Fprint(flags.Output(), migrateUsage) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	conf, _, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *connString != "" {
+		conf.ConnString = *connString
+	}
+
+	db, err := storage.OpenDB(conf.ConnString, storage.PoolConfig{MaxOpenConns: 1})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(db, migrations.FS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading migrations: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch flags.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while applying migrations: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			steps, err = strconv.Atoi(flags.Arg(1))
+			if err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid number of migrations: %s\n", flags.Arg(1))
+				return 2
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while rolling back migrations: %v\n", err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while reading migrations: %v\n", err)
+			return 1
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
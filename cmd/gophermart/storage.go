@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/config"
+	"github.com/xbreathoflife/gophermart/internal/app/server"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/instrumented"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/memory"
+	"go.uber.org/zap"
+)
+
+type storages struct {
+	server.Storages
+	DB     *sql.DB
+	Outbox storage.OutboxStorage
+}
+
+// openStorages connects to the database and applies migrations, or falls back
+// to the in-memory storage when no connection string is configured.
+func openStorages(conf config.Config, log *zap.Logger) (*storages, error) {
+	if conf.ConnString == "" {
+		log.Warn("DATABASE_URI is not set, data is kept in memory and lost on restart")
+		mem := memory.NewStorage()
+		return &storages{
+			Storages: server.Storages{
+				Balance:     mem,
+				Order:       mem,
+				User:        mem,
+				Idempotency: mem,
+				Webhook:     mem,
+				Tx:          mem,
+				Health:      mem,
+			},
+			Outbox: mem,
+		}, nil
+	}
+
+	db, err := storage.OpenDB(conf.ConnString, storage.PoolConfig{
+		MaxOpenConns:     conf.DBMaxOpenConns,
+		MaxIdleConns:     conf.DBMaxIdleConns,
+		ConnMaxLifetime:  conf.DBConnMaxLifetime,
+		ConnMaxIdleTime:  conf.DBConnMaxIdleTime,
+		StatementTimeout: conf.DBStatementTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+
+	dbStorage := storage.NewDBStorage(db, log)
+	if err := dbStorage.Init(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while initializing storage: %w", err)
+	}
+
+	return &storages{
+		Storages: server.Storages{
+			Balance:     storage.NewBalanceStorage(db),
+			Order:       storage.NewOrderStorage(db),
+			User:        storage.NewUserStorage(db),
+			Idempotency: storage.NewIdempotencyStorage(db),
+			Webhook:     storage.NewWebhookStorage(db),
+			Tx:          storage.NewDBTxManager(db),
+			Health:      dbStorage,
+		},
+		DB:     db,
+		Outbox: storage.NewOutboxStorage(db),
+	}, nil
+}
+
+func (s *storages) Close() {
+	if s.DB != nil {
+		s.DB.Close()
+	}
+}
+
+// instrument wraps the storages so that every call is observed.
+func (s *storages) instrument(observe instrumented.Observer) {
+	s.Balance = instrumented.BalanceStorage(s.Balance, observe)
+	s.Order = instrumented.OrderStorage(s.Order, observe)
+	s.User = instrumented.UserStorage(s.User, observe)
+	s.Idempotency = instrumented.IdempotencyStorage(s.Idempotency, observe)
+	s.Webhook = instrumented.WebhookStorage(s.Webhook, observe)
+	s.Tx = instrumented.TxManager(s.Tx, observe)
+	s.Outbox = instrumented.OutboxStorage(s.Outbox, observe)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/config"
+	"github.com/xbreathoflife/gophermart/internal/app/certs"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+)
+
+// setupTLS makes srv serve TLS when a certificate is configured, and starts
+// the plain HTTP server redirecting to it if an address is configured for it.
+// Without one plain HTTP is refused.
+func setupTLS(ctx context.Context, conf config.Config, srv *http.Server, logger *zap.Logger) (*http.Server, error) {
+	if conf.TLSCertFile == "" {
+		// the configuration is validated: no TLS option is set without a certificate
+		return nil, nil
+	}
+
+	reloader := certs.NewReloader(certs.Config{
+		CertFile:     conf.TLSCertFile,
+		KeyFile:      conf.TLSKeyFile,
+		ClientCAFile: conf.TLSClientCAFile,
+	}, logger)
+	if err := reloader.Load(); err != nil {
+		return nil, err
+	}
+	go reloader.Run(ctx, conf.TLSReloadInterval)
+	srv.TLSConfig = reloader.TLSConfig()
+
+	if conf.HTTPRedirectAddr == "" {
+		return nil, nil
+	}
+	_, port, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	redirectSrv := &http.Server{Addr: conf.HTTPRedirectAddr, Handler: certs.RedirectHandler(port)}
+	go func() {
+		logger.Info("redirecting plain HTTP", zap.String("address", conf.HTTPRedirectAddr))
+		if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("error while serving plain HTTP", zap.Error(err))
+		}
+	}()
+	return redirectSrv, nil
+}
@@ -1,26 +1,119 @@
+// Package config loads the configuration of the service from, in increasing
+// precedence, the defaults, a YAML or JSON file, the environment and the
+// command line flags.
+//
+// Every field is named after its environment variable: RUN_ADDRESS is the
+// run_address key of the config file and the -run-address flag.
 package config
 
 import (
-	"github.com/caarlos0/env/v6"
-	"log"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"time"
 )
 
 type Config struct {
-	Address        string `env:"RUN_ADDRESS"`
-	ConnString     string `env:"DATABASE_URI"`
-	ServiceAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	Address                 string        `env:"RUN_ADDRESS" flag:"a"`
+	ConnString              string        `env:"DATABASE_URI" flag:"d" mask:"dsn"`
+	ServiceAddress          string        `env:"ACCRUAL_SYSTEM_ADDRESS" flag:"r"`
+	SessionKey              string        `env:"SESSION_KEY" mask:"secret"`
+	AccrualWorkers          int           `env:"ACCRUAL_WORKERS"`
+	AccrualQueueSize        int           `env:"ACCRUAL_QUEUE_SIZE"`
+	AccrualTimeout          time.Duration `env:"ACCRUAL_TIMEOUT"`
+	AccrualRateLimitDelay   time.Duration `env:"ACCRUAL_RATE_LIMIT_DELAY"`
+	AccrualCircuitThreshold int           `env:"ACCRUAL_CIRCUIT_THRESHOLD"`
+	AccrualCircuitCooldown  time.Duration `env:"ACCRUAL_CIRCUIT_COOLDOWN"`
+	WebhookQueueSize        int           `env:"WEBHOOK_QUEUE_SIZE"`
+	WebhookMaxAttempts      int           `env:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookTimeout          time.Duration `env:"WEBHOOK_TIMEOUT"`
+	WebhookAllowPrivate     bool          `env:"WEBHOOK_ALLOW_PRIVATE_NETWORKS"`
+	OutboxSinks             string        `env:"OUTBOX_SINKS"`
+	OutboxInterval          time.Duration `env:"OUTBOX_INTERVAL"`
+	OutboxMaxAttempts       int           `env:"OUTBOX_MAX_ATTEMPTS"`
+	OutboxRetention         time.Duration `env:"OUTBOX_RETENTION"`
+	DBMaxOpenConns          int           `env:"DB_MAX_OPEN_CONNS"`
+	DBMaxIdleConns          int           `env:"DB_MAX_IDLE_CONNS"`
+	DBConnMaxLifetime       time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	DBConnMaxIdleTime       time.Duration `env:"DB_CONN_MAX_IDLE_TIME"`
+	DBStatementTimeout      time.Duration `env:"DB_STATEMENT_TIMEOUT"`
+	ShutdownDelay           time.Duration `env:"SHUTDOWN_DELAY"`
+	ShutdownTimeout         time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	TracingExporter         string        `env:"TRACING_EXPORTER"`
+	OTLPEndpoint            string        `env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure            bool          `env:"TRACING_OTLP_INSECURE"`
+	TracingSampleRatio      float64       `env:"TRACING_SAMPLE_RATIO"`
+	LogLevel                string        `env:"LOG_LEVEL"`
+	CompressLevel           int           `env:"COMPRESS_LEVEL"`
+	CompressMinSize         int           `env:"COMPRESS_MIN_SIZE"`
+	CompressTypes           []string      `env:"COMPRESS_CONTENT_TYPES" envSeparator:","`
+	MaxDecodedBodySize      int64         `env:"MAX_DECODED_BODY_SIZE"`
+	RateLimitAuth           string        `env:"RATE_LIMIT_AUTH"`
+	RateLimitOrders         string        `env:"RATE_LIMIT_ORDERS"`
+	RateLimitAPI            string        `env:"RATE_LIMIT_API"`
+	TrustedProxies          []string      `env:"TRUSTED_PROXIES" envSeparator:","`
+	CORSAllowedOrigins      []string      `env:"CORS_ALLOWED_ORIGINS" envSeparator:","`
+	CORSMaxAge              time.Duration `env:"CORS_MAX_AGE"`
+	CSRFMode                string        `env:"CSRF_MODE"`
+	CSRFSecret              string        `env:"CSRF_SECRET" mask:"secret"`
+	TLSCertFile             string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile              string        `env:"TLS_KEY_FILE"`
+	TLSClientCAFile         string        `env:"TLS_CLIENT_CA_FILE"`
+	TLSPartnerNames         []string      `env:"TLS_PARTNER_NAMES" envSeparator:","`
+	TLSReloadInterval       time.Duration `env:"TLS_RELOAD_INTERVAL"`
+	HTTPRedirectAddr        string        `env:"HTTP_REDIRECT_ADDRESS"`
+	GRPCAddress             string        `env:"GRPC_ADDRESS"`
 }
 
-func Init() Config {
-	cfg := Config{
-		Address:        "localhost:8080",
-		ConnString:     "",
-		ServiceAddress: "",
+// Default returns the configuration used for everything which is not set.
+func Default() Config {
+	return Config{
+		Address:                 "localhost:8080",
+		ConnString:              "",
+		ServiceAddress:          "",
+		SessionKey:              core.DefaultSessionKey,
+		AccrualWorkers:          1,
+		AccrualQueueSize:        10,
+		AccrualTimeout:          10 * time.Second,
+		AccrualRateLimitDelay:   3 * time.Second,
+		AccrualCircuitThreshold: 5,
+		AccrualCircuitCooldown:  10 * time.Second,
+		WebhookQueueSize:        100,
+		WebhookMaxAttempts:      5,
+		WebhookTimeout:          10 * time.Second,
+		WebhookAllowPrivate:     false,
+		OutboxSinks:             "log",
+		OutboxInterval:          5 * time.Second,
+		OutboxMaxAttempts:       20,
+		OutboxRetention:         7 * 24 * time.Hour,
+		DBMaxOpenConns:          20,
+		DBMaxIdleConns:          10,
+		DBConnMaxLifetime:       30 * time.Minute,
+		DBConnMaxIdleTime:       5 * time.Minute,
+		DBStatementTimeout:      30 * time.Second,
+		ShutdownDelay:           0,
+		ShutdownTimeout:         15 * time.Second,
+		TracingExporter:         "none",
+		OTLPEndpoint:            "localhost:4318",
+		OTLPInsecure:            true,
+		TracingSampleRatio:      1,
+		LogLevel:                "info",
+		CompressLevel:           0,
+		CompressMinSize:         1024,
+		CompressTypes:           nil,
+		MaxDecodedBodySize:      10 << 20,
+		RateLimitAuth:           "30/1m",
+		RateLimitOrders:         "60/1m",
+		RateLimitAPI:            "600/1m",
+		TrustedProxies:          nil,
+		CORSAllowedOrigins:      nil,
+		CORSMaxAge:              10 * time.Minute,
+		CSRFMode:                "browser",
+		CSRFSecret:              "",
+		TLSCertFile:             "",
+		TLSKeyFile:              "",
+		TLSClientCAFile:         "",
+		TLSPartnerNames:         nil,
+		TLSReloadInterval:       time.Minute,
+		HTTPRedirectAddr:        "",
+		GRPCAddress:             "",
 	}
-	err := env.Parse(&cfg)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return cfg
 }
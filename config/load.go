@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv names the config file when the -config flag is not given.
+const FileEnv = "CONFIG"
+
+// Command holds the flags which are not part of the configuration.
+type Command struct {
+	// File is the config file the configuration was read from, if any.
+	File string
+	// PrintConfig asks to print the effective configuration and exit.
+	PrintConfig bool
+}
+
+// field describes a field of Config and the names it is set by.
+type field struct {
+	index int
+	// env is the environment variable.
+	env string
+	// key is the key in the config file.
+	key string
+	// flag is the command line flag, short its alias if any.
+	flag  string
+	short string
+	// mask tells how the value is masked when printed: "secret" or "dsn".
+	mask string
+}
+
+func configFields() []field {
+	t := reflect.TypeOf(Config{})
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		name, ok := tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		fields = append(fields, field{
+			index: i,
+			env:   name,
+			key:   strings.ToLower(name),
+			flag:  strings.ReplaceAll(strings.ToLower(name), "_", "-"),
+			short: tag.Get("flag"),
+			mask:  tag.Get("mask"),
+		})
+	}
+	return fields
+}
+
+// Load merges the defaults, the config file, the environment and the flags
+// of args, in increasing precedence, and validates the result. The config
+// file is given by the -config flag or the CONFIG environment variable.
+func Load(args []string) (Config, Command, error) {
+	cfg := Default()
+	fields := configFields()
+
+	var cmd Command
+	flags := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+	flags.StringVar(&cmd.File, "config", "", "YAML or JSON config file, overrides "+FileEnv)
+	flags.BoolVar(&cmd.PrintConfig, "print-config", false, "print the effective configuration with secrets masked and exit")
+	values := make([]*flagValue, len(fields))
+	for i, f := range fields {
+		values[i] = &flagValue{typ: reflect.TypeOf(Config{}).Field(f.index).Type}
+		usage := "overrides " + f.env
+		flags.Var(values[i], f.flag, usage)
+		if f.short != "" {
+			flags.Var(values[i], f.short, usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, cmd, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, cmd, fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	if cmd.File == "" {
+		cmd.File = os.Getenv(FileEnv)
+	}
+	if cmd.File != "" {
+		if err := loadFile(&cfg, fields, cmd.File); err != nil {
+			return cfg, cmd, err
+		}
+	}
+
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, cmd, fmt.Errorf("environment: %w", err)
+	}
+
+	v := reflect.ValueOf(&cfg).Elem()
+	for i, f := range fields {
+		if values[i].set {
+			v.Field(f.index).Set(values[i].value)
+		}
+	}
+
+	return cfg, cmd, cfg.Validate()
+}
+
+// loadFile reads the config file at path over cfg. JSON is read as YAML, of
+// which it is a subset.
+func loadFile(cfg *Config, fields []field, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s: must be a mapping of keys to values", path)
+	}
+
+	byKey := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		f, ok := byKey[key.Value]
+		if !ok {
+			return fmt.Errorf("config file %s: line %d: unknown key %q", path, key.Line, key.Value)
+		}
+		if err := decodeNode(value, v.Field(f.index)); err != nil {
+			return fmt.Errorf("config file %s: line %d: %s: %w", path, value.Line, key.Value, err)
+		}
+	}
+	return nil
+}
+
+// decodeNode decodes a YAML value into dst, with durations written like "5s"
+// and lists also written as comma separated strings, as in the environment.
+func decodeNode(node *yaml.Node, dst reflect.Value) error {
+	if node.Kind == yaml.ScalarNode && (dst.Type() == durationType || dst.Kind() == reflect.Slice) {
+		return setValue(dst, node.Value)
+	}
+	return node.Decode(dst.Addr().Interface())
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue parses s into dst according to its type.
+func setValue(dst reflect.Value, s string) error {
+	switch {
+	case dst.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration like 1m30s")
+		}
+		dst.SetInt(int64(d))
+	case dst.Kind() == reflect.String:
+		dst.SetString(s)
+	case dst.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		dst.SetBool(b)
+	case dst.Kind() == reflect.Int || dst.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || dst.OverflowInt(n) {
+			return errors.New("must be an integer")
+		}
+		dst.SetInt(n)
+	case dst.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		dst.SetFloat(f)
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		dst.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", dst.Type())
+	}
+	return nil
+}
+
+// flagValue parses a flag into a value of the type of its field, which is set
+// once the environment is read.
+type flagValue struct {
+	typ   reflect.Type
+	value reflect.Value
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil || !v.set {
+		return ""
+	}
+	return fmt.Sprint(v.value.Interface())
+}
+
+func (v *flagValue) Set(s string) error {
+	value := reflect.New(v.typ).Elem()
+	if err := setValue(value, s); err != nil {
+		return err
+	}
+	v.value, v.set = value, true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.typ.Kind() == reflect.Bool
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+)
+
+// masked replaces secrets, as in url.URL.Redacted.
+const masked = "xxxxx"
+
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// Print writes the configuration as a config file, with the secrets masked.
+func (c Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	v := reflect.ValueOf(c)
+	for _, f := range configFields() {
+		var value yaml.Node
+		if err := value.Encode(maskValue(f.mask, v.Field(f.index).Interface())); err != nil {
+			return err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, &value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func maskValue(mask string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return value
+	}
+	switch mask {
+	case "secret":
+		return masked
+	case "dsn":
+		return maskDSN(s)
+	}
+	return value
+}
+
+// maskDSN masks the password of a connection string, either a URL or
+// key=value pairs.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+masked)
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
+	"github.com/xbreathoflife/gophermart/internal/app/ratelimit"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// ValidationError lists the invalid values of a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// validator collects the problems of a configuration, naming each value by
+// its config file key, environment variable and flag.
+type validator struct {
+	fields   map[string]field
+	problems []string
+}
+
+func (v *validator) check(env string, ok bool, format string, args ...interface{}) {
+	if ok {
+		return
+	}
+	f := v.fields[env]
+	names := f.env + ", -" + f.flag
+	if f.short != "" {
+		names += ", -" + f.short
+	}
+	v.problems = append(v.problems, fmt.Sprintf("%s (%s): %s", f.key, names, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) address(env string, address string, optional bool) {
+	if optional && address == "" {
+		return
+	}
+	_, _, err := net.SplitHostPort(address)
+	v.check(env, err == nil, "must be host:port, got %q", address)
+}
+
+func (v *validator) positive(env string, n int64) {
+	v.check(env, n > 0, "must be positive, got %d", n)
+}
+
+func (v *validator) positiveDuration(env string, d time.Duration) {
+	v.check(env, d > 0, "must be a positive duration, got %s", d)
+}
+
+func (v *validator) rateLimit(env string, limit string) {
+	_, err := ratelimit.ParseLimit(limit)
+	v.check(env, err == nil, "%v", err)
+}
+
+// Validate reports all the invalid values at once.
+func (c Config) Validate() error {
+	v := &validator{fields: map[string]field{}}
+	for _, f := range configFields() {
+		v.fields[f.env] = f
+	}
+
+	v.address("RUN_ADDRESS", c.Address, false)
+	v.address("GRPC_ADDRESS", c.GRPCAddress, true)
+	v.address("HTTP_REDIRECT_ADDRESS", c.HTTPRedirectAddr, true)
+	if c.ServiceAddress != "" {
+		u, err := url.Parse(c.ServiceAddress)
+		v.check("ACCRUAL_SYSTEM_ADDRESS", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"must be an http or https URL, got %q", c.ServiceAddress)
+	}
+	v.check("SESSION_KEY", c.SessionKey != "", "must not be empty")
+
+	v.positive("ACCRUAL_WORKERS", int64(c.AccrualWorkers))
+	v.positive("ACCRUAL_QUEUE_SIZE", int64(c.AccrualQueueSize))
+	v.check("ACCRUAL_TIMEOUT", c.AccrualTimeout >= 0, "must not be negative, got %s", c.AccrualTimeout)
+	v.positiveDuration("ACCRUAL_RATE_LIMIT_DELAY", c.AccrualRateLimitDelay)
+	v.positive("ACCRUAL_CIRCUIT_THRESHOLD", int64(c.AccrualCircuitThreshold))
+	v.positiveDuration("ACCRUAL_CIRCUIT_COOLDOWN", c.AccrualCircuitCooldown)
+	v.positive("WEBHOOK_QUEUE_SIZE", int64(c.WebhookQueueSize))
+	v.check("WEBHOOK_MAX_ATTEMPTS", c.WebhookMaxAttempts > 0 && c.WebhookMaxAttempts <= core.MaxWebhookAttempts,
+		"must be between 1 and %d, got %d", core.MaxWebhookAttempts, c.WebhookMaxAttempts)
+	v.positiveDuration("WEBHOOK_TIMEOUT", c.WebhookTimeout)
+
+	v.positiveDuration("OUTBOX_INTERVAL", c.OutboxInterval)
+	v.positive("OUTBOX_MAX_ATTEMPTS", int64(c.OutboxMaxAttempts))
+	v.positiveDuration("OUTBOX_RETENTION", c.OutboxRetention)
+	v.check("DB_MAX_OPEN_CONNS", c.DBMaxOpenConns >= 0, "must not be negative, 0 for no limit, got %d", c.DBMaxOpenConns)
+	v.check("DB_MAX_IDLE_CONNS", c.DBMaxIdleConns >= 0 && (c.DBMaxOpenConns == 0 || c.DBMaxIdleConns <= c.DBMaxOpenConns),
+		"must be between 0 and DB_MAX_OPEN_CONNS (%d), got %d", c.DBMaxOpenConns, c.DBMaxIdleConns)
+	v.check("DB_CONN_MAX_LIFETIME", c.DBConnMaxLifetime >= 0, "must not be negative, got %s", c.DBConnMaxLifetime)
+	v.check("DB_CONN_MAX_IDLE_TIME", c.DBConnMaxIdleTime >= 0, "must not be negative, got %s", c.DBConnMaxIdleTime)
+	v.check("DB_STATEMENT_TIMEOUT", c.DBStatementTimeout >= 0, "must not be negative, got %s", c.DBStatementTimeout)
+	v.check("SHUTDOWN_DELAY", c.ShutdownDelay >= 0, "must not be negative, got %s", c.ShutdownDelay)
+	v.positiveDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+
+	exporters := []string{tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP}
+	v.check("TRACING_EXPORTER", c.TracingExporter == "" || contains(exporters, c.TracingExporter), "must be one of %v, got %q", exporters, c.TracingExporter)
+	v.check("TRACING_SAMPLE_RATIO", c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1,
+		"must be between 0 and 1, got %v", c.TracingSampleRatio)
+	v.check("LOG_LEVEL", contains(logLevels, c.LogLevel), "must be one of %v, got %q", logLevels, c.LogLevel)
+
+	v.check("COMPRESS_LEVEL", c.CompressLevel >= -2 && c.CompressLevel <= 9,
+		"must be between -2 and 9, 0 for the default, got %d", c.CompressLevel)
+	v.check("COMPRESS_MIN_SIZE", c.CompressMinSize >= 0, "must not be negative, got %d", c.CompressMinSize)
+	v.check("MAX_DECODED_BODY_SIZE", c.MaxDecodedBodySize >= 0, "must not be negative, 0 for no limit, got %d", c.MaxDecodedBodySize)
+	v.rateLimit("RATE_LIMIT_AUTH", c.RateLimitAuth)
+	v.rateLimit("RATE_LIMIT_ORDERS", c.RateLimitOrders)
+	v.rateLimit("RATE_LIMIT_API", c.RateLimitAPI)
+	_, err := ratelimit.ParseNetworks(c.TrustedProxies)
+	v.check("TRUSTED_PROXIES", err == nil, "%v", err)
+	v.check("CORS_MAX_AGE", c.CORSMaxAge >= 0, "must not be negative, got %s", c.CORSMaxAge)
+	_, err = csrf.ParseMode(c.CSRFMode)
+	v.check("CSRF_MODE", err == nil, "%v", err)
+
+	v.check("TLS_CERT_FILE", (c.TLSCertFile == "") == (c.TLSKeyFile == ""), "must be set together with TLS_KEY_FILE")
+	v.check("TLS_CLIENT_CA_FILE", c.TLSClientCAFile == "" || c.TLSCertFile != "", "requires TLS_CERT_FILE and TLS_KEY_FILE")
+	v.check("HTTP_REDIRECT_ADDRESS", c.HTTPRedirectAddr == "" || c.TLSCertFile != "", "requires TLS_CERT_FILE and TLS_KEY_FILE")
+	v.positiveDuration("TLS_RELOAD_INTERVAL", c.TLSReloadInterval)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
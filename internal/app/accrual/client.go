@@ -0,0 +1,92 @@
+// Package accrual is the client of the accrual system, which calculates the
+// points of orders.
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrRateLimited is returned when the accrual system asks to slow down.
+	ErrRateLimited = errors.New("accrual system rate limit reached")
+	// ErrFailed is returned when the accrual system fails with 500 Internal
+	// Server Error.
+	ErrFailed = errors.New("accrual system failed")
+	// ErrUnavailable is returned for the other 5xx responses.
+	ErrUnavailable = errors.New("accrual system unavailable")
+	// ErrBadResponse is returned when the order status can not be read.
+	ErrBadResponse = errors.New("bad order status")
+)
+
+type Client struct {
+	Address string
+	HTTP    *http.Client
+}
+
+// NewClient returns a client of the accrual system at address, whose requests
+// time out after timeout unless it is 0.
+func NewClient(address string, timeout time.Duration) *Client {
+	return &Client{Address: address, HTTP: &http.Client{Timeout: timeout}}
+}
+
+// GetOrderStatus requests the order status, passing the trace context on to
+// the accrual system. It returns nil if the order has no status, e.g. when it
+// is not registered in the accrual system yet.
+func (c *Client) GetOrderStatus(ctx context.Context, orderNum string) (*entities.GetOrderStatusResponse, error) {
+	resp, err := c.fetch(ctx, orderNum)
+	if err != nil {
+		metrics.AccrualRequests.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	metrics.AccrualRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, ErrRateLimited
+	case resp.StatusCode == http.StatusInternalServerError:
+		return nil, ErrFailed
+	case resp.StatusCode > http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadResponse, err)
+	}
+	orderStatus := entities.GetOrderStatusResponse{}
+	if err := json.Unmarshal(b, &orderStatus); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadResponse, err)
+	}
+	return &orderStatus, nil
+}
+
+func (c *Client) fetch(ctx context.Context, orderNum string) (*http.Response, error) {
+	ctx, span := tracing.Start(ctx, "accrual", "GET /api/orders/{number}", trace.WithSpanKind(trace.SpanKindClient))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/orders/%s", c.Address, orderNum), nil)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	tracing.Inject(req)
+	resp, err := c.HTTP.Do(req)
+	if err == nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
@@ -0,0 +1,56 @@
+package accrual
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_GetOrderStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       string
+		err        error
+	}{
+		{name: "processed", statusCode: http.StatusOK, body: `{"order":"2377225624","status":"PROCESSED","accrual":500}`, want: "PROCESSED"},
+		{name: "not registered", statusCode: http.StatusNoContent},
+		{name: "rate limited", statusCode: http.StatusTooManyRequests, err: ErrRateLimited},
+		{name: "failed", statusCode: http.StatusInternalServerError, err: ErrFailed},
+		{name: "unavailable", statusCode: http.StatusServiceUnavailable, err: ErrUnavailable},
+		{name: "bad body", statusCode: http.StatusOK, body: `{`, err: ErrBadResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/orders/2377225624", r.URL.Path)
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			status, err := NewClient(srv.URL, time.Second).GetOrderStatus(context.Background(), "2377225624")
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				if tt.body != "" {
+					assert.NotContains(t, err.Error(), tt.body, "the body is not logged")
+				}
+				assert.Nil(t, status)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Nil(t, status)
+				return
+			}
+			require.NotNil(t, status)
+			assert.Equal(t, tt.want, status.Status)
+			assert.Equal(t, 500.0, *status.Accrual)
+		})
+	}
+}
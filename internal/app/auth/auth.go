@@ -3,12 +3,17 @@ package auth
 import (
 	"context"
 	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
 	"net/http"
 )
 
 const CookieName = "authorization"
 const CtxKey = ContextKey("session")
 
+// UserCtxKey holds the *entities.UserSessionModel of a valid session.
+const UserCtxKey = ContextKey("user")
+
 type ContextKey string
 
 
@@ -16,12 +21,12 @@ func CheckAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie(CookieName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			er.WriteHTTP(w, http.StatusUnauthorized, er.CodeUnauthorized, "Not logged in")
 			return
 		} else {
 			session, err := core.Decrypt(cookie.Value)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+				er.WriteHTTP(w, http.StatusUnauthorized, er.CodeUnauthorized, "Invalid session")
 				return
 			}
 			ctx := context.WithValue(r.Context(), CtxKey, session)
@@ -31,3 +36,21 @@ func CheckAuth(next http.Handler) http.Handler {
 	})
 }
 
+// SessionLookup returns the user of the session.
+type SessionLookup func(ctx context.Context, session string) (*entities.UserSessionModel, error)
+
+// ResolveUser looks the user of the session up once per request for the
+// middlewares and handlers after it, it must run after CheckAuth. Invalid
+// sessions are left to the handlers to reject.
+func ResolveUser(lookup SessionLookup) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if session, ok := r.Context().Value(CtxKey).(string); ok {
+				if user, err := lookup(r.Context(), session); err == nil {
+					r = r.WithContext(context.WithValue(r.Context(), UserCtxKey, user))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
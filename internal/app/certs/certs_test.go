@@ -0,0 +1,171 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type issued struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issue(t *testing.T, name string, parent *issued, isCA bool) *issued {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &issued{cert: cert, key: key, der: der}
+}
+
+func (i *issued) write(t *testing.T, dir string, name string) (string, string) {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(i.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func (i *issued) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{i.der}, PrivateKey: i.key}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Gophermart CA", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, "localhost", ca, false).write(t, dir, "server")
+	partner := issue(t, "partner", ca, false)
+	stranger := issue(t, "stranger", ca, false)
+	outsider := issue(t, "partner", issue(t, "Other CA", nil, true), false)
+
+	reloader := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, zap.NewNop())
+	require.NoError(t, reloader.Load())
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/partner/orders/1", RequireClientCert([]string{"partner"}, zap.NewNop())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	mux.HandleFunc("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = reloader.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(path string, client *issued) (*http.Response, error) {
+		cfg := &tls.Config{RootCAs: roots}
+		if client != nil {
+			cfg.Certificates = []tls.Certificate{client.tlsCertificate()}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := c.Get(srv.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	resp, err := get("/api/user/orders", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "other routes need no client certificate")
+	assert.Equal(t, "localhost", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	resp, err = get("/api/partner/orders/1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = get("/api/partner/orders/1", partner)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = get("/api/partner/orders/1", stranger)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// clients do not even offer certificates of other CAs
+	resp, err = get("/api/partner/orders/1", outsider)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the renewed certificate is served once the files change
+	renewed := issue(t, "renewed", ca, false)
+	renewed.write(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Run(ctx, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		resp, err := get("/api/user/orders", nil)
+		return err == nil && resp.TLS.PeerCertificates[0].Subject.CommonName == "renewed"
+	}, time.Second, 20*time.Millisecond)
+
+	// broken files do not replace the loaded certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0600))
+	later := future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	time.Sleep(50 * time.Millisecond)
+	resp, err = get("/api/user/orders", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port     string
+		host     string
+		location string
+	}{
+		{port: "8443", host: "gophermart.dev:8080", location: "https://gophermart.dev:8443/api/user/orders?limit=10"},
+		{port: "443", host: "gophermart.dev", location: "https://gophermart.dev/api/user/orders?limit=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/api/user/orders?limit=10", nil)
+			rec := httptest.NewRecorder()
+			RedirectHandler(tt.port).ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+			assert.Equal(t, tt.location, rec.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package certs
+
+import (
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+)
+
+// RequireClientCert only lets requests with a verified client certificate
+// through. If names are given, the certificate common name must be one of them.
+func RequireClientCert(names []string, log *zap.Logger) func(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				er.WriteHTTP(w, http.StatusUnauthorized, er.CodeUnauthorized, "Client certificate required")
+				return
+			}
+			name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			if len(allowed) > 0 && !allowed[name] {
+				logging.For(r.Context(), log).Warn("client certificate not allowed", zap.String("common_name", name))
+				er.WriteHTTP(w, http.StatusForbidden, er.CodeForbidden, "Client certificate not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RedirectHandler redirects plain HTTP requests to the same URL on the HTTPS
+// port. 308 keeps the method and body of API calls.
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,145 @@
+// Package certs serves TLS with certificates reloaded from disk when they
+// change, and checks client certificates of partner routes.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CAs client certificates are verified with. Without
+	// it client certificates are not requested.
+	ClientCAFile string
+}
+
+// Reloader keeps the certificate, key and client CAs loaded from the files and
+// reloads them when a file changes.
+type Reloader struct {
+	cfg Config
+	log *zap.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func NewReloader(cfg Config, log *zap.Logger) *Reloader {
+	return &Reloader{cfg: cfg, log: log}
+}
+
+// Load reads the files, keeping the previously loaded ones if they are invalid.
+func (r *Reloader) Load() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+func (r *Reloader) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *Reloader) changed() bool {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		// a file is being replaced, the next check sees the new one
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run reloads the files every interval if they changed, until ctx is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.Load(); err != nil {
+			r.log.Error("failed to reload certificates, keeping the previous ones", zap.Error(err))
+			continue
+		}
+		r.log.Info("reloaded certificates", zap.String("cert_file", r.cfg.CertFile))
+	}
+}
+
+// TLSConfig returns a server configuration which always uses the latest
+// loaded files. Client certificates are verified when presented, routes which
+// require one check it with RequireClientCert.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			if r.cert == nil {
+				return nil, errors.New("no certificate loaded")
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
@@ -0,0 +1,178 @@
+// Package compress decodes compressed request bodies and compresses responses
+// for clients which accept it.
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// DefaultContentTypes are the compressed content types when none are configured.
+var DefaultContentTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"text/plain",
+	"text/html",
+}
+
+type Config struct {
+	// Level is the compression level, 0 means the default level.
+	Level int
+	// MinSize is the size from which responses are compressed, smaller ones are
+	// sent as is as compression would not pay off.
+	MinSize int
+	// ContentTypes are the media types which are compressed, "text/*" matches
+	// any text type.
+	ContentTypes []string
+	// MaxDecodedSize limits the size of decoded request bodies, 0 means no limit.
+	MaxDecodedSize int64
+}
+
+type Compressor struct {
+	level          int
+	minSize        int
+	contentTypes   []string
+	maxDecodedSize int64
+	gzipPool       sync.Pool
+	zlibPool       sync.Pool
+}
+
+func New(cfg Config) *Compressor {
+	c := &Compressor{
+		level:          cfg.Level,
+		minSize:        cfg.MinSize,
+		contentTypes:   cfg.ContentTypes,
+		maxDecodedSize: cfg.MaxDecodedSize,
+	}
+	if c.level == 0 {
+		c.level = gzip.DefaultCompression
+	}
+	if len(c.contentTypes) == 0 {
+		c.contentTypes = DefaultContentTypes
+	}
+	return c
+}
+
+// Handler decodes the request body according to its Content-Encoding and
+// compresses the response with the encoding negotiated by Accept-Encoding.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			body, err := c.decode(r.Body, r.Header.Get("Content-Encoding"))
+			if err != nil {
+				if err == errUnsupportedEncoding {
+					er.WriteHTTP(w, http.StatusUnsupportedMediaType, er.CodeInvalidRequest, "Unsupported content encoding")
+					return
+				}
+				er.WriteHTTP(w, http.StatusBadRequest, er.CodeInvalidRequest, "Request body can not be decoded")
+				return
+			}
+			if c.maxDecodedSize > 0 {
+				body = http.MaxBytesReader(w, body, c.maxDecodedSize)
+			}
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &responseWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Negotiate picks the encoding of the response from the Accept-Encoding
+// header: gzip or deflate, whichever has the higher quality, or "" if none is
+// acceptable.
+func Negotiate(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if coding == "*" {
+			coding = Gzip
+		}
+		if coding != Gzip && coding != Deflate || q <= 0 {
+			continue
+		}
+		// gzip wins ties as it is the most widely supported
+		if q > bestQ || q == bestQ && coding == Gzip {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+func (c *Compressor) allowed(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range c.contentTypes {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compressor) encoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == Gzip {
+		if gw, ok := c.gzipPool.Get().(*gzip.Writer); ok {
+			gw.Reset(w)
+			return &pooledWriter{WriteCloser: gw, release: func() { c.gzipPool.Put(gw) }}
+		}
+		gw, _ := gzip.NewWriterLevel(w, c.level)
+		return &pooledWriter{WriteCloser: gw, release: func() { c.gzipPool.Put(gw) }}
+	}
+	if zw, ok := c.zlibPool.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return &pooledWriter{WriteCloser: zw, release: func() { c.zlibPool.Put(zw) }}
+	}
+	zw, _ := zlib.NewWriterLevel(w, c.level)
+	return &pooledWriter{WriteCloser: zw, release: func() { c.zlibPool.Put(zw) }}
+}
+
+// pooledWriter returns the encoder to its pool when closed.
+type pooledWriter struct {
+	io.WriteCloser
+	release func()
+}
+
+func (w *pooledWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.release()
+	return err
+}
+
+func (w *pooledWriter) Flush() error {
+	if f, ok := w.WriteCloser.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
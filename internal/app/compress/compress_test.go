@@ -0,0 +1,174 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: Gzip},
+		{acceptEncoding: "deflate, gzip", want: Gzip},
+		{acceptEncoding: "gzip;q=0.5, deflate", want: Deflate},
+		{acceptEncoding: "gzip;q=0, deflate;q=0", want: ""},
+		{acceptEncoding: "br, *", want: Gzip},
+		{acceptEncoding: "br, identity", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write(b)
+}
+
+func TestHandler_DecodeRequest(t *testing.T) {
+	body := strings.Repeat("12345678903\n", 100)
+	var gzipped, zlibbed, raw bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, _ = gw.Write([]byte(body))
+	require.NoError(t, gw.Close())
+	zw := zlib.NewWriter(&zlibbed)
+	_, _ = zw.Write([]byte(body))
+	require.NoError(t, zw.Close())
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	_, _ = fw.Write([]byte(body))
+	require.NoError(t, fw.Close())
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxSize  int64
+		status   int
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipped.Bytes(), status: http.StatusOK},
+		{name: "zlib deflate", encoding: "deflate", body: zlibbed.Bytes(), status: http.StatusOK},
+		{name: "raw deflate", encoding: "deflate", body: raw.Bytes(), status: http.StatusOK},
+		{name: "plain", encoding: "", body: []byte(body), status: http.StatusOK},
+		{name: "broken gzip", encoding: "gzip", body: []byte(body), status: http.StatusBadRequest},
+		{name: "unsupported", encoding: "br", body: []byte(body), status: http.StatusUnsupportedMediaType},
+		{name: "too large", encoding: "gzip", body: gzipped.Bytes(), maxSize: 100, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(Config{MaxDecodedSize: tt.maxSize}).Handler(http.HandlerFunc(echo))
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, body, rec.Body.String())
+			}
+			if tt.status == http.StatusBadRequest || tt.status == http.StatusUnsupportedMediaType {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandler_CompressResponse(t *testing.T) {
+	large := strings.Repeat(`{"number":"12345678903","status":"PROCESSED"},`, 50)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		encoding       string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", body: large, encoding: Gzip},
+		{name: "deflate", acceptEncoding: "deflate", contentType: "application/json; charset=utf-8", body: large, encoding: Deflate},
+		{name: "not accepted", acceptEncoding: "", contentType: "application/json", body: large},
+		{name: "below threshold", acceptEncoding: "gzip", contentType: "application/json", body: `{"current":1}`},
+		{name: "type not allowed", acceptEncoding: "gzip", contentType: "image/png", body: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(Config{MinSize: 256}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusOK)
+				for _, part := range strings.SplitAfter(tt.body, ",") {
+					_, _ = w.Write([]byte(part))
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.encoding, rec.Header().Get("Content-Encoding"))
+			var r io.Reader = rec.Body
+			switch tt.encoding {
+			case Gzip:
+				gr, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err)
+				r = gr
+			case Deflate:
+				zr, err := zlib.NewReader(rec.Body)
+				require.NoError(t, err)
+				r = zr
+			}
+			b, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(b))
+		})
+	}
+}
+
+func TestHandler_Stream(t *testing.T) {
+	h := New(Config{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("event: ping\n\n"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/user/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.True(t, rec.Flushed)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "event: ping\n\n", rec.Body.String())
+}
+
+func TestHandler_NoContent(t *testing.T) {
+	h := New(Config{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Zero(t, rec.Body.Len())
+}
@@ -0,0 +1,60 @@
+package compress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+)
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decode wraps the body in decoders for the Content-Encoding, which lists the
+// encodings in the order they were applied.
+func (c *Compressor) decode(body io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	var r io.Reader = body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "identity":
+			continue
+		case Gzip, "x-gzip":
+			r, err = gzip.NewReader(r)
+		case Deflate:
+			r, err = newDeflateReader(r)
+		default:
+			return nil, errUnsupportedEncoding
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &decodedBody{Reader: r, body: body}, nil
+}
+
+// newDeflateReader reads zlib streams as HTTP deflate is defined, but also
+// accepts raw deflate streams which some clients send instead.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,117 @@
+package compress
+
+import (
+	"io"
+	"net/http"
+)
+
+// responseWriter buffers the start of the response until it is known whether
+// it is worth compressing: the content type is allowed and the body reaches
+// the minimal size.
+type responseWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.passThrough()
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if !w.compressible() {
+		return len(b), w.passThrough()
+	}
+	if len(w.buf) >= w.c.minSize {
+		return len(b), w.startCompression()
+	}
+	return len(b), nil
+}
+
+// Flush sends what is buffered, so streamed responses are compressed only once
+// the minimal size was reached before the first flush.
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		if w.compressible() && len(w.buf) >= w.c.minSize {
+			_ = w.startCompression()
+		} else {
+			_ = w.passThrough()
+		}
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes the rest of the response.
+func (w *responseWriter) Close() error {
+	if !w.decided {
+		return w.passThrough()
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
+
+func (w *responseWriter) compressible() bool {
+	h := w.Header()
+	return h.Get("Content-Encoding") == "" && w.c.allowed(h.Get("Content-Type"))
+}
+
+func (w *responseWriter) writeHeader() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *responseWriter) passThrough() error {
+	w.decided = true
+	if w.c.allowed(w.Header().Get("Content-Type")) {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	w.writeHeader()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *responseWriter) startCompression() error {
+	w.decided = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	h.Add("Vary", "Accept-Encoding")
+	w.writeHeader()
+	w.encoder = w.c.encoder(w.encoding, w.ResponseWriter)
+	_, err := w.encoder.Write(w.buf)
+	w.buf = nil
+	return err
+}
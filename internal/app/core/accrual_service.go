@@ -2,116 +2,289 @@ package core
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"database/sql"
+	"errors"
+	"github.com/xbreathoflife/gophermart/internal/app/accrual"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
 	"github.com/xbreathoflife/gophermart/internal/app/storage"
-	"io"
-	"log"
-	"net/http"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	RegisteredStatus = "REGISTERED"
-	ProcessingStatus = "PROCESSING"
-	InvalidStatus    = "INVALID"
-	ProcessedStatus  = "PROCESSED"
+	defaultAccrualWorkers   = 1
+	defaultAccrualQueueSize = 10
+	defaultRateLimitDelay   = 3 * time.Second
+	defaultCircuitThreshold = 5
+	defaultCircuitCooldown  = 10 * time.Second
 )
 
+// AccrualConfig configures the polling of the accrual system, zero values
+// mean the defaults.
+type AccrualConfig struct {
+	// Address is the address of the accrual system, the orders are not polled
+	// if it is empty.
+	Address string
+	// Workers is the number of orders polled concurrently.
+	Workers int
+	// QueueSize is the number of orders waiting to be polled, orders uploaded
+	// when the queue is full wait for a free slot in the background.
+	QueueSize int
+	// Timeout limits a request to the accrual system, 0 means no limit.
+	Timeout time.Duration
+	// RateLimitDelay is how long a worker waits when the accrual system asks
+	// to slow down.
+	RateLimitDelay time.Duration
+	// CircuitThreshold is the number of consecutive failures which open the
+	// circuit breaker.
+	CircuitThreshold int
+	// CircuitCooldown is how long the circuit breaker stays open.
+	CircuitCooldown time.Duration
+}
+
+func (c AccrualConfig) withDefaults() AccrualConfig {
+	if c.Workers == 0 {
+		c.Workers = defaultAccrualWorkers
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = defaultAccrualQueueSize
+	}
+	if c.RateLimitDelay == 0 {
+		c.RateLimitDelay = defaultRateLimitDelay
+	}
+	if c.CircuitThreshold == 0 {
+		c.CircuitThreshold = defaultCircuitThreshold
+	}
+	if c.CircuitCooldown == 0 {
+		c.CircuitCooldown = defaultCircuitCooldown
+	}
+	return c
+}
+
+// AccrualClient requests order statuses from the accrual system.
+type AccrualClient interface {
+	GetOrderStatus(ctx context.Context, orderNum string) (*entities.GetOrderStatusResponse, error)
+}
+
 type AccrualService struct {
 	OrderStorage   storage.OrderStorage
 	BalanceStorage storage.BalanceStorage
+	Events         *events.Bus
 	ServiceAddress string
+	Client         AccrualClient
 	Channel        chan string
-	isNotFinished  bool
+	Breaker        *CircuitBreaker
+	Log            *zap.Logger
+	config         AccrualConfig
+	running        int32
 }
 
-func NewAccrualService(orderStorage storage.OrderStorage, balanceStorage storage.BalanceStorage, serviceAddress string, ctx context.Context) *AccrualService {
-	ch := make(chan string, 10)
-	service := AccrualService{OrderStorage: orderStorage, BalanceStorage: balanceStorage, ServiceAddress: serviceAddress, Channel: ch, isNotFinished: true}
-	if serviceAddress != "" {
-		go service.updateOrderStatuses(ctx)
+func NewAccrualService(orderStorage storage.OrderStorage, balanceStorage storage.BalanceStorage, bus *events.Bus, config AccrualConfig, log *zap.Logger, ctx context.Context) *AccrualService {
+	config = config.withDefaults()
+	ch := make(chan string, config.QueueSize)
+	service := AccrualService{OrderStorage: orderStorage, BalanceStorage: balanceStorage, Events: bus,
+		ServiceAddress: config.Address, Client: accrual.NewClient(config.Address, config.Timeout), Channel: ch,
+		Breaker: NewCircuitBreaker(config.CircuitThreshold, config.CircuitCooldown), Log: log, config: config}
+	if config.Address != "" {
+		atomic.StoreInt32(&service.running, 1)
+		for i := 0; i < config.Workers; i++ {
+			go service.updateOrderStatuses(ctx)
+		}
 	}
 	return &service
 }
 
+// Enabled reports whether an accrual system is configured at all.
+func (as *AccrualService) Enabled() bool {
+	return as.ServiceAddress != ""
+}
+
+// Running reports whether the workers polling the accrual system are alive.
+func (as *AccrualService) Running() bool {
+	return atomic.LoadInt32(&as.running) == 1
+}
+
 func (as *AccrualService) updateOrderStatuses(ctx context.Context) {
-	for as.isNotFinished {
-		orderNum := <-as.Channel
-		fmt.Printf("%s\n", orderNum)
-		resp, err := http.Get(fmt.Sprintf("%s/api/orders/%s", as.ServiceAddress, orderNum))
-		if err != nil {
-			log.Printf("Failed to get %s/api/orders/%s\n", as.ServiceAddress, orderNum)
+	for {
+		var orderNum string
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&as.running, 0)
+			return
+		case orderNum = <-as.Channel:
 		}
+		metrics.AccrualQueueDepth.Dec()
+		if !as.Breaker.Allow() {
+			as.requeueAfter(orderNum, as.config.CircuitCooldown)
+			continue
+		}
+		as.poll(ctx, orderNum)
+	}
+}
+
+// poll fetches the order status from the accrual system and applies it. Every
+// poll is traced on its own, as it is not part of any request.
+func (as *AccrualService) poll(ctx context.Context, orderNum string) {
+	ctx, span := tracing.Start(ctx, "core", "AccrualService.poll", trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("order.number", orderNum)))
+	defer span.End()
+	log := logging.For(ctx, as.Log).With(zap.String("order", orderNum))
 
-		defer resp.Body.Close()
-
-		switch resp.StatusCode {
-		case http.StatusTooManyRequests:
-			log.Println("Too many requests status")
-			time.Sleep(time.Second * 3)
-			as.Channel <- orderNum
-		case http.StatusInternalServerError:
-			log.Println("Something went wrong 500 status for order ", orderNum)
-			as.isNotFinished = false
-		case http.StatusOK:
-			b, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Println(err)
-			}
-
-			orderStatus := entities.GetOrderStatusResponse{}
-			if err := json.Unmarshal(b, &orderStatus); err != nil {
-				log.Println("Failed to parse json", err)
-				log.Println("body: ", b)
-				return
-			}
-			switch orderStatus.Status {
-			case InvalidStatus:
-				log.Println("Invalid status for order ", orderNum)
-				err := as.OrderStorage.UpdateOrderStatus(ctx, orderNum, InvalidStatus)
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-			case ProcessedStatus:
-				log.Println("Processed status for order ", orderNum)
-				accrual := *orderStatus.Accrual
-				err := as.OrderStorage.UpdateOrderStatusAndAccrual(ctx, orderNum, ProcessedStatus, accrual)
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-				// get user login
-				o, err := as.OrderStorage.GetOrderIfExists(ctx, orderNum)
-				login := o.Login
-
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-				balance, err := as.BalanceStorage.GetBalance(ctx, login)
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-
-				err = as.BalanceStorage.UpdateBalance(ctx, entities.BalanceModel{
-					Login:   login,
-					Balance: balance.Balance + accrual,
-					Spent:   balance.Spent,
-				})
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-			case ProcessingStatus:
-				log.Println("Processing status for order ", orderNum)
-				err := as.OrderStorage.UpdateOrderStatus(ctx, orderNum, ProcessingStatus)
-				if err != nil {
-					log.Println("Failed to update status in db: ", err)
-				}
-				as.Channel <- orderNum
-			case RegisteredStatus:
-				log.Println("New status for order ", orderNum)
-				as.Channel <- orderNum
-			}
+	log.Debug("polling order status")
+	orderStatus, err := as.Client.GetOrderStatus(ctx, orderNum)
+	switch {
+	case errors.Is(err, accrual.ErrRateLimited):
+		as.Breaker.Success()
+		log.Warn("accrual system rate limit reached")
+		time.Sleep(as.config.RateLimitDelay)
+		as.requeue(orderNum)
+	case errors.Is(err, accrual.ErrFailed), errors.Is(err, accrual.ErrUnavailable):
+		as.Breaker.Failure()
+		log.Warn("accrual system is unavailable", zap.Error(err))
+		as.requeueAfter(orderNum, as.config.CircuitCooldown)
+	case errors.Is(err, accrual.ErrBadResponse):
+		as.Breaker.Success()
+		log.Warn("failed to read order status", zap.Error(err))
+		as.requeueAfter(orderNum, as.config.CircuitCooldown)
+	case err != nil:
+		as.Breaker.Failure()
+		log.Warn("failed to get order status", zap.String("accrual_address", as.ServiceAddress), zap.Error(err))
+		as.requeue(orderNum)
+	case orderStatus != nil:
+		as.Breaker.Success()
+		if !as.applyStatus(ctx, log, orderNum, *orderStatus) {
+			as.requeue(orderNum)
 		}
+	default:
+		as.Breaker.Success()
+	}
+}
+
+// applyStatus moves the order along the status state machine and reports
+// whether the order reached a final status and must not be polled any more.
+func (as *AccrualService) applyStatus(ctx context.Context, log *zap.Logger, orderNum string, orderStatus entities.GetOrderStatusResponse) bool {
+	order, err := as.OrderStorage.GetOrderIfExists(ctx, orderNum)
+	if err != nil {
+		log.Error("failed to get order", zap.Error(err))
+		return false
+	}
+	if order == nil || IsFinalStatus(order.Status) {
+		return true
+	}
+	if order.Status == orderStatus.Status {
+		return false
+	}
+	if err := ValidateTransition(order.Status, orderStatus.Status); err != nil {
+		log.Warn("skipping order status", zap.String("status", orderStatus.Status), zap.Error(err))
+		return false
+	}
+
+	if orderStatus.Status == ProcessedStatus {
+		return as.processOrder(ctx, log, order, orderStatus)
+	}
+
+	updated, err := as.OrderStorage.UpdateOrderStatus(ctx, orderNum, order.Status, orderStatus.Status)
+	if err != nil {
+		log.Error("failed to update order status", zap.Error(err))
+		return false
+	}
+	if !updated {
+		// status was changed concurrently, the next poll sees the new one
+		return false
+	}
+	log.Info("order status changed", zap.String("status", orderStatus.Status))
+	as.recordTransition(ctx, log, orderNum, orderStatus)
+	as.Events.Publish(order.Login, events.OrderStatusChanged, entities.OrderStatusEvent{
+		OrderNum: orderNum,
+		Status:   PublicStatus(orderStatus.Status),
+		Accrual:  orderStatus.Accrual,
+	})
+
+	return IsFinalStatus(orderStatus.Status)
+}
+
+// processOrder completes the order and credits the accrual atomically together
+// with the outbox event.
+func (as *AccrualService) processOrder(ctx context.Context, log *zap.Logger, order *entities.OrderModel, orderStatus entities.GetOrderStatusResponse) bool {
+	processed := entities.OrderStatusEvent{
+		OrderNum: order.OrderNum,
+		Status:   ProcessedStatus,
+		Accrual:  orderStatus.Accrual,
+	}
+	outboxEvent, err := newOutboxEvent(order.Login, events.OrderProcessed, processed)
+	if err != nil {
+		log.Error("failed to build outbox event", zap.Error(err))
+		return false
+	}
+
+	var accrual sql.NullFloat64
+	if orderStatus.Accrual != nil {
+		accrual = sql.NullFloat64{Float64: *orderStatus.Accrual, Valid: true}
+	}
+	balance, err := as.OrderStorage.ProcessOrderAccrual(ctx, order.OrderNum, order.Status, accrual,
+		[]entities.OutboxEventModel{outboxEvent})
+	if err != nil {
+		log.Error("failed to process order", zap.Error(err))
+		return false
+	}
+	if balance == nil {
+		// status was changed concurrently, the next poll sees the new one
+		return false
+	}
+	log.Info("order processed", zap.Float64("accrual", accrual.Float64))
+	metrics.PointsAccrued.Add(accrual.Float64)
+	as.recordTransition(ctx, log, order.OrderNum, orderStatus)
+	as.Events.Publish(order.Login, events.OrderStatusChanged, processed)
+	as.Events.Publish(order.Login, events.BalanceChanged, *balance)
+	as.Events.Publish(order.Login, events.OrderProcessed, processed)
+
+	return true
+}
+
+// requeue returns the order to the queue without blocking the worker on a full channel.
+func (as *AccrualService) requeue(orderNum string) {
+	as.requeueAfter(orderNum, 0)
+}
+
+// Enqueue schedules the order to be polled, it never blocks the upload.
+func (as *AccrualService) Enqueue(orderNum string) {
+	if !as.Enabled() {
+		return
+	}
+	select {
+	case as.Channel <- orderNum:
+		metrics.AccrualQueueDepth.Inc()
+	default:
+		as.requeue(orderNum)
+	}
+}
+
+func (as *AccrualService) requeueAfter(orderNum string, delay time.Duration) {
+	metrics.AccrualQueueDepth.Inc()
+	go func() {
+		time.Sleep(delay)
+		as.Channel <- orderNum
+	}()
+}
+
+func (as *AccrualService) recordTransition(ctx context.Context, log *zap.Logger, orderNum string, orderStatus entities.GetOrderStatusResponse) {
+	entry := entities.OrderStatusHistoryModel{
+		OrderNum:  orderNum,
+		Status:    orderStatus.Status,
+		ChangedAt: time.Now(),
+	}
+	if orderStatus.Accrual != nil {
+		entry.Accrual = sql.NullFloat64{Float64: *orderStatus.Accrual, Valid: true}
+	}
+	err := as.OrderStorage.InsertOrderStatusHistory(ctx, entry)
+	if err != nil {
+		log.Error("failed to record order status history", zap.Error(err))
 	}
 }
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/accrual"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type failingAccrualClient struct {
+	err error
+}
+
+func (c *failingAccrualClient) GetOrderStatus(context.Context, string) (*entities.GetOrderStatusResponse, error) {
+	return nil, c.err
+}
+
+func newTestAccrualService(address string, client AccrualClient) *AccrualService {
+	config := AccrualConfig{Address: address, QueueSize: 1, CircuitCooldown: time.Millisecond}.withDefaults()
+	return &AccrualService{ServiceAddress: address, Client: client, Channel: make(chan string, config.QueueSize),
+		Breaker: NewCircuitBreaker(config.CircuitThreshold, config.CircuitCooldown), Log: zap.NewNop(), config: config,
+		running: 1}
+}
+
+func TestAccrualService_PollRequeuesOnErrors(t *testing.T) {
+	for _, err := range []error{
+		accrual.ErrFailed,
+		fmt.Errorf("%w: status 502", accrual.ErrUnavailable),
+		fmt.Errorf("%w: unexpected EOF", accrual.ErrBadResponse),
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			as := newTestAccrualService("http://accrual", &failingAccrualClient{err: err})
+			as.poll(context.Background(), "12345678903")
+			assert.True(t, as.Running(), "the workers must not stop")
+
+			select {
+			case orderNum := <-as.Channel:
+				assert.Equal(t, "12345678903", orderNum)
+			case <-time.After(time.Second):
+				require.Fail(t, "the order was not requeued")
+			}
+		})
+	}
+}
+
+func TestAccrualService_Enqueue(t *testing.T) {
+	as := newTestAccrualService("", nil)
+	as.Enqueue("12345678903")
+	assert.Empty(t, as.Channel, "orders are not queued without an accrual system")
+
+	as = newTestAccrualService("http://accrual", nil)
+	done := make(chan struct{})
+	go func() {
+		as.Enqueue("12345678903")
+		as.Enqueue("9278923470")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "enqueue blocks on a full queue")
+	}
+	assert.Equal(t, "12345678903", <-as.Channel)
+	assert.Equal(t, "9278923470", <-as.Channel)
+}
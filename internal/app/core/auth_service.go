@@ -5,11 +5,19 @@ import (
 	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"github.com/google/uuid"
 )
 
-const secretKey = "x35k9f"
+// DefaultSessionKey encrypts the session tokens unless SetSessionKey is called.
+const DefaultSessionKey = "x35k9f"
+
+var secretKey = DefaultSessionKey
+
+// SetSessionKey changes the key of the session tokens, which ends all the
+// sessions encrypted with the previous one. It must be called before serving.
+func SetSessionKey(key string) {
+	secretKey = key
+}
 
 func GenerateUUID() string {
 	return uuid.NewString()
@@ -47,13 +55,11 @@ func Encrypt(src string) (string, error) {
 
 	aesblock, err := aes.NewCipher(key[:])
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
 		return "", err
 	}
 
 	aesgcm, err := cipher.NewGCM(aesblock)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
 		return "", err
 	}
 
@@ -2,25 +2,32 @@ package core
 
 import (
 	"context"
-	errors2 "errors"
 	"github.com/joeljunstrom/go-luhn"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
 	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
 	"github.com/xbreathoflife/gophermart/internal/app/storage"
-	"net/http"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
 	"time"
 )
 
 type BalanceService struct {
-	BalanceStorage storage.BalanceStorage
+	BalanceStorage     storage.BalanceStorage
+	IdempotencyStorage storage.IdempotencyStorage
+	Tx                 storage.TxManager
+	Events             *events.Bus
 }
 
-func NewBalanceService(storage storage.BalanceStorage) *BalanceService {
-	service := BalanceService{BalanceStorage: storage}
+func NewBalanceService(balanceStorage storage.BalanceStorage, idempotencyStorage storage.IdempotencyStorage, tx storage.TxManager, bus *events.Bus) *BalanceService {
+	service := BalanceService{BalanceStorage: balanceStorage, IdempotencyStorage: idempotencyStorage, Tx: tx, Events: bus}
 	return &service
 }
 
 func (bs *BalanceService) GetUsersBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
+	ctx, span := tracing.Start(ctx, "core", "BalanceService.GetUsersBalance")
+	defer span.End()
+
 	balance, err := bs.BalanceStorage.GetBalance(ctx, login)
 	if err != nil {
 		return nil, err
@@ -28,47 +35,82 @@ func (bs *BalanceService) GetUsersBalance(ctx context.Context, login string) (*e
 	return balance, nil
 }
 
-func (bs *BalanceService) ProcessBalanceWithdraw(ctx context.Context, login string, bw entities.BalanceWithdrawRequest) (int, error) {
-	if !luhn.Valid(bw.Order) {
-		return http.StatusUnprocessableEntity, errors.NewWrongDataError(bw.Order)
-	}
+func (bs *BalanceService) ProcessBalanceWithdraw(ctx context.Context, login string, bw entities.BalanceWithdrawRequest) (*entities.BalanceWithdrawalsResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "BalanceService.ProcessBalanceWithdraw")
+	defer span.End()
 
-	balance, err := bs.BalanceStorage.GetBalance(ctx, login)
-	if err != nil {
-		return http.StatusInternalServerError, err
+	// written this way NaN is rejected as well
+	if !(bw.Sum > 0) {
+		return nil, errors.NewFieldError("sum", "must be positive")
 	}
-
-	if balance.Balance < bw.Sum {
-		return http.StatusPaymentRequired, errors2.New("not enough money")
+	if !luhn.Valid(bw.Order) {
+		return nil, errors.NewInvalidOrderNumberError(bw.Order)
 	}
 
-	err = bs.BalanceStorage.UpdateBalance(ctx, entities.BalanceModel{
-		Login:   login,
-		Balance: balance.Balance - bw.Sum,
-		Spent:   balance.Spent + bw.Sum,
-	})
+	processedAt := time.Now()
+	withdrawn := entities.BalanceWithdrawalsResponse{
+		OrderNum:    bw.Order,
+		Sum:         bw.Sum,
+		ProcessedAt: processedAt.Format(time.RFC3339),
+	}
+	outboxEvent, err := newOutboxEvent(login, events.BalanceWithdrawn, withdrawn)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return nil, err
 	}
 
-	err = bs.BalanceStorage.InsertNewBalanceWithdrawals(ctx, entities.BalanceWithdrawalsModel{
-		Login:       login,
-		OrderNum:    bw.Order,
-		Sum:         bw.Sum,
-		ProcessedAt: time.Now(),
+	var balance *entities.BalanceModel
+	err = bs.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		balance, err = bs.BalanceStorage.WithdrawBalance(ctx, entities.BalanceWithdrawalsModel{
+			Login:       login,
+			OrderNum:    bw.Order,
+			Sum:         bw.Sum,
+			ProcessedAt: processedAt,
+		}, []entities.OutboxEventModel{outboxEvent})
+		if err != nil || balance == nil {
+			return err
+		}
+		// a retry with the same idempotency key must not withdraw again
+		return commitIdempotencyKey(ctx, bs.IdempotencyStorage)
 	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return nil, err
 	}
+	if balance == nil {
+		return nil, errors.NewInsufficientFundsError(bw.Sum)
+	}
+	metrics.PointsWithdrawn.Add(bw.Sum)
+	bs.Events.Publish(login, events.BalanceChanged, *balance)
+	bs.Events.Publish(login, events.BalanceWithdrawn, withdrawn)
 
-	return http.StatusOK, nil
+	return &withdrawn, nil
 }
 
-func (bs *BalanceService) GetWithdrawalsForUser(ctx context.Context, login string) ([]entities.BalanceWithdrawalsResponse, error) {
-	withdrawalHistory, err := bs.BalanceStorage.GetBalanceWithdrawalsForUser(ctx, login)
+func (bs *BalanceService) GetWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsResponse, *entities.PageInfo, error) {
+	ctx, span := tracing.Start(ctx, "core", "BalanceService.GetWithdrawalsForUser")
+	defer span.End()
+
+	limit := pageLimit(query)
+	query.Limit = 0
+	if limit > 0 {
+		query.Limit = limit + 1
+	}
+	withdrawalHistory, err := bs.BalanceStorage.GetBalanceWithdrawalsForUser(ctx, login, query)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	total, err := bs.BalanceStorage.CountBalanceWithdrawalsForUser(ctx, login, query)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	page := entities.PageInfo{Total: total, Limit: limit}
+	if limit > 0 && len(withdrawalHistory) > limit {
+		withdrawalHistory = withdrawalHistory[:limit]
+		last := withdrawalHistory[limit-1]
+		page.NextCursor = EncodeCursor(entities.Cursor{At: last.ProcessedAt, ID: last.ID})
+	}
+
 	var bwResponse []entities.BalanceWithdrawalsResponse
 	for _, bw := range withdrawalHistory {
 		bwResponse = append(bwResponse, entities.BalanceWithdrawalsResponse{
@@ -78,5 +120,5 @@ func (bs *BalanceService) GetWithdrawalsForUser(ctx context.Context, login strin
 		})
 	}
 
-	return bwResponse, nil
+	return bwResponse, &page, nil
 }
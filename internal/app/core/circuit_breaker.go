@@ -0,0 +1,80 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing dependency: after Threshold failures
+// in a row it opens for Cooldown, then lets one trial call through and closes
+// again if it succeeds.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	breaker := CircuitBreaker{Threshold: threshold, Cooldown: cooldown, state: CircuitClosed, now: time.Now}
+	return &breaker
+}
+
+// Allow reports whether a call may be made now.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.Cooldown {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// the trial call is in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = CircuitClosed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.Threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.Cooldown {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2022, 4, 16, 0, 0, 0, 0, time.UTC)
+	cb := NewCircuitBreaker(2, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	assert.True(t, cb.Allow())
+	cb.Failure()
+	assert.Equal(t, CircuitClosed, cb.State())
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.False(t, cb.Allow())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	assert.True(t, cb.Allow())
+	assert.False(t, cb.Allow(), "only one trial call is let through")
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	now = now.Add(time.Minute)
+	assert.True(t, cb.Allow())
+	cb.Success()
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.True(t, cb.Allow())
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HealthOK       = "ok"
+	HealthFailing  = "failing"
+	HealthDegraded = "degraded"
+	HealthDisabled = "disabled"
+
+	healthCheckTimeout = 2 * time.Second
+)
+
+type HealthService struct {
+	Storage      storage.HealthStorage
+	Accrual      *AccrualService
+	shuttingDown int32
+}
+
+func NewHealthService(healthStorage storage.HealthStorage, accrual *AccrualService) *HealthService {
+	service := HealthService{Storage: healthStorage, Accrual: accrual}
+	return &service
+}
+
+// SetShuttingDown makes the service report not ready, so that no new traffic
+// is routed to it while in-flight requests are drained.
+func (hs *HealthService) SetShuttingDown() {
+	atomic.StoreInt32(&hs.shuttingDown, 1)
+}
+
+// Readiness runs the checks and reports whether the service can take traffic.
+// An open accrual circuit only degrades the service: orders are still accepted
+// and polled once the accrual system recovers.
+func (hs *HealthService) Readiness(ctx context.Context) (*entities.ReadinessResponse, bool) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	response := entities.ReadinessResponse{Status: HealthOK, Checks: map[string]entities.HealthCheckResponse{}}
+	ready := true
+	fail := func(name string, check entities.HealthCheckResponse) {
+		response.Checks[name] = check
+		ready = false
+	}
+
+	if atomic.LoadInt32(&hs.shuttingDown) == 1 {
+		fail("shutdown", entities.HealthCheckResponse{Status: HealthFailing, Error: "shutting down"})
+	}
+
+	if err := hs.Storage.Ping(ctx); err != nil {
+		fail("database", entities.HealthCheckResponse{Status: HealthFailing, Error: err.Error()})
+	} else {
+		response.Checks["database"] = entities.HealthCheckResponse{Status: HealthOK}
+	}
+
+	pending, err := hs.Storage.PendingMigrations(ctx)
+	switch {
+	case err != nil:
+		fail("migrations", entities.HealthCheckResponse{Status: HealthFailing, Error: err.Error()})
+	case pending > 0:
+		fail("migrations", entities.HealthCheckResponse{Status: HealthFailing, Pending: &pending})
+	default:
+		response.Checks["migrations"] = entities.HealthCheckResponse{Status: HealthOK, Pending: &pending}
+	}
+
+	switch {
+	case !hs.Accrual.Enabled():
+		response.Checks["accrual_worker"] = entities.HealthCheckResponse{Status: HealthDisabled}
+	case !hs.Accrual.Running():
+		fail("accrual_worker", entities.HealthCheckResponse{Status: HealthFailing, Error: "worker stopped"})
+	default:
+		response.Checks["accrual_worker"] = entities.HealthCheckResponse{Status: HealthOK}
+	}
+
+	state := hs.Accrual.Breaker.State()
+	circuit := entities.HealthCheckResponse{Status: HealthOK, State: state}
+	if state == CircuitOpen {
+		circuit.Status = HealthDegraded
+	}
+	response.Checks["accrual_circuit"] = circuit
+
+	if !ready {
+		response.Status = HealthFailing
+	}
+	return &response, ready
+}
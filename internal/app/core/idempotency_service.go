@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// IdempotencyKeyTTL is how long the response of a key is replayed.
+	IdempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is the default LockTimeout.
+	idempotencyLockTimeout     = time.Minute
+	idempotencyCleanupInterval = time.Hour
+)
+
+type IdempotencyService struct {
+	IdempotencyStorage storage.IdempotencyStorage
+	// LockTimeout is how long a key stays reserved by a request which neither
+	// completed, released nor committed it, e.g. as the process died.
+	LockTimeout time.Duration
+}
+
+func NewIdempotencyService(storage storage.IdempotencyStorage) *IdempotencyService {
+	service := IdempotencyService{IdempotencyStorage: storage, LockTimeout: idempotencyLockTimeout}
+	return &service
+}
+
+type idempotencyKeyCtx struct{}
+
+// withIdempotencyKey returns ctx carrying the key reserved for the request.
+func withIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, record)
+}
+
+func idempotencyKeyFrom(ctx context.Context) (entities.IdempotencyModel, bool) {
+	record, ok := ctx.Value(idempotencyKeyCtx{}).(entities.IdempotencyModel)
+	return record, ok
+}
+
+// commitIdempotencyKey marks the key reserved for the request in ctx, if any,
+// as committed. Called in the transaction of the guarded operation, it fails
+// the operation if a retry took the key over meanwhile, and keeps a retry
+// from taking the key over once the operation is committed.
+func commitIdempotencyKey(ctx context.Context, s storage.IdempotencyStorage) error {
+	record, ok := idempotencyKeyFrom(ctx)
+	if !ok {
+		return nil
+	}
+	committed, err := s.CommitIdempotencyKey(ctx, record)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		return errors.NewConflictError("request with this idempotency key was taken over by a retry")
+	}
+	return nil
+}
+
+func HashRequest(method string, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin reserves the key for the request. It returns the stored response if
+// the same request was already completed. Otherwise it returns ctx carrying
+// the reservation, which the request is processed, completed and released
+// with.
+func (is *IdempotencyService) Begin(ctx context.Context, login string, key string, requestHash string) (context.Context, *entities.IdempotencyModel, error) {
+	requestCtx := ctx
+	ctx, span := tracing.Start(ctx, "core", "IdempotencyService.Begin")
+	defer span.End()
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	reservation := entities.IdempotencyModel{
+		Login:       login,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		Token:       token,
+	}
+	inserted, err := is.IdempotencyStorage.InsertIdempotencyKey(ctx, reservation,
+		now.Add(-IdempotencyKeyTTL), now.Add(-is.LockTimeout))
+	if err != nil {
+		return nil, nil, err
+	}
+	if inserted {
+		return withIdempotencyKey(requestCtx, reservation), nil, nil
+	}
+
+	record, err := is.IdempotencyStorage.GetIdempotencyKey(ctx, login, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		// released concurrently, the client may retry
+		return nil, nil, errors.NewConflictError("request with this idempotency key was released, try again")
+	}
+	if record.RequestHash != requestHash {
+		return nil, nil, errors.NewUnprocessableError("idempotency key reused with a different request")
+	}
+	if record.StatusCode == 0 {
+		return nil, nil, errors.NewConflictError("request with this idempotency key is in progress")
+	}
+	return nil, record, nil
+}
+
+// Complete stores the response of the request holding the key in ctx. It
+// does nothing if a retry took the key over.
+func (is *IdempotencyService) Complete(ctx context.Context, response entities.IdempotencyModel) error {
+	ctx, span := tracing.Start(ctx, "core", "IdempotencyService.Complete")
+	defer span.End()
+
+	record, ok := idempotencyKeyFrom(ctx)
+	if !ok {
+		return nil
+	}
+	record.StatusCode = response.StatusCode
+	record.ContentType = response.ContentType
+	record.Body = response.Body
+	return is.IdempotencyStorage.UpdateIdempotencyKey(ctx, record)
+}
+
+// Release frees the key in ctx so that a failed request can be retried with
+// it. A key whose operation is committed is kept, a retry must not repeat it.
+func (is *IdempotencyService) Release(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "core", "IdempotencyService.Release")
+	defer span.End()
+
+	record, ok := idempotencyKeyFrom(ctx)
+	if !ok {
+		return nil
+	}
+	return is.IdempotencyStorage.DeleteIdempotencyKey(ctx, record)
+}
+
+// DeleteExpired deletes the keys past their TTL and the ones left in progress.
+func (is *IdempotencyService) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "core", "IdempotencyService.DeleteExpired")
+	defer span.End()
+
+	now := time.Now()
+	return is.IdempotencyStorage.DeleteExpiredIdempotencyKeys(ctx, now.Add(-IdempotencyKeyTTL), now.Add(-is.LockTimeout))
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RunCleanup deletes the expired keys periodically until ctx is done.
+func (is *IdempotencyService) RunCleanup(ctx context.Context, log *zap.Logger) {
+	ticker := time.NewTicker(idempotencyCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := is.DeleteExpired(ctx)
+		if err != nil {
+			log.Error("failed to delete expired idempotency keys", zap.Error(err))
+			continue
+		}
+		log.Debug("deleted expired idempotency keys", zap.Int64("count", deleted))
+	}
+}
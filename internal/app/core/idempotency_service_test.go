@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/memory"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyService_SlowFirstRequest(t *testing.T) {
+	const lockTimeout = 10 * time.Millisecond
+	withdrawal := entities.BalanceWithdrawRequest{Order: "2377225624", Sum: 10}
+	requestHash := HashRequest("POST", "/api/user/balance/withdraw", []byte(`{"order":"2377225624","sum":10}`))
+
+	setup := func(t *testing.T) (*memory.Storage, *IdempotencyService, *BalanceService) {
+		mem := memory.NewStorage()
+		ctx := context.Background()
+		require.NoError(t, mem.InsertNewUser(ctx, entities.UserModel{Login: "hello"}))
+		require.NoError(t, mem.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"}))
+		require.NoError(t, mem.UpdateBalance(ctx, entities.BalanceModel{Login: "hello", Balance: 100}))
+		is := NewIdempotencyService(mem)
+		is.LockTimeout = lockTimeout
+		return mem, is, NewBalanceService(mem, mem, mem, events.NewBus(0))
+	}
+	assertBalance := func(t *testing.T, mem *memory.Storage, expected float64) {
+		balance, err := mem.GetBalance(context.Background(), "hello")
+		require.NoError(t, err)
+		assert.Equal(t, expected, balance.Balance)
+	}
+
+	t.Run("retry takes the key over before the withdrawal", func(t *testing.T) {
+		mem, is, bs := setup(t)
+		first, stored, err := is.Begin(context.Background(), "hello", "key-1", requestHash)
+		require.NoError(t, err)
+		require.Nil(t, stored)
+
+		time.Sleep(2 * lockTimeout)
+		retry, stored, err := is.Begin(context.Background(), "hello", "key-1", requestHash)
+		require.NoError(t, err)
+		require.Nil(t, stored)
+		_, err = bs.ProcessBalanceWithdraw(retry, "hello", withdrawal)
+		require.NoError(t, err)
+		require.NoError(t, is.Complete(retry, entities.IdempotencyModel{StatusCode: 200}))
+
+		// the first request lost the key, its withdrawal is rolled back
+		_, err = bs.ProcessBalanceWithdraw(first, "hello", withdrawal)
+		assert.IsType(t, &errors.ConflictError{}, err)
+		require.NoError(t, is.Complete(first, entities.IdempotencyModel{StatusCode: 409}))
+		assertBalance(t, mem, 90)
+
+		_, stored, err = is.Begin(context.Background(), "hello", "key-1", requestHash)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, 200, stored.StatusCode)
+	})
+
+	t.Run("retry after the withdrawal is committed", func(t *testing.T) {
+		mem, is, bs := setup(t)
+		first, _, err := is.Begin(context.Background(), "hello", "key-1", requestHash)
+		require.NoError(t, err)
+		_, err = bs.ProcessBalanceWithdraw(first, "hello", withdrawal)
+		require.NoError(t, err)
+
+		// the first request is slow to complete, the key is not taken over
+		time.Sleep(2 * lockTimeout)
+		_, _, err = is.Begin(context.Background(), "hello", "key-1", requestHash)
+		assert.IsType(t, &errors.ConflictError{}, err)
+		deleted, err := is.DeleteExpired(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, deleted)
+		require.NoError(t, is.Release(first))
+		_, _, err = is.Begin(context.Background(), "hello", "key-1", requestHash)
+		assert.IsType(t, &errors.ConflictError{}, err)
+		assertBalance(t, mem, 90)
+	})
+}
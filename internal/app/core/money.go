@@ -0,0 +1,45 @@
+package core
+
+import (
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"math"
+)
+
+const (
+	// PointsCurrency is the currency of loyalty points.
+	PointsCurrency = "POINTS"
+	// PointsScale is the number of decimal places points are kept with.
+	PointsScale = 2
+)
+
+// NewMoney converts points to Money, rounded to PointsScale.
+func NewMoney(points float64) entities.Money {
+	return entities.Money{
+		Value:    int64(math.Round(points * math.Pow10(PointsScale))),
+		Currency: PointsCurrency,
+		Scale:    PointsScale,
+	}
+}
+
+// NewMoneyIfSet converts optional points, such as the accrual of an order.
+func NewMoneyIfSet(points *float64) *entities.Money {
+	if points == nil {
+		return nil
+	}
+	m := NewMoney(*points)
+	return &m
+}
+
+// Points converts Money of PointsCurrency to points. The scale may be lower
+// than PointsScale, but not higher, as points have no smaller units.
+func Points(field string, m entities.Money) (float64, error) {
+	if m.Currency != PointsCurrency {
+		return 0, errors.NewFieldError(field+".currency", "must be "+PointsCurrency)
+	}
+	if m.Scale < 0 || m.Scale > PointsScale {
+		return 0, errors.NewFieldError(field+".scale", fmt.Sprintf("must be between 0 and %d", PointsScale))
+	}
+	return float64(m.Value) / math.Pow10(m.Scale), nil
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"testing"
+)
+
+func TestMoney(t *testing.T) {
+	assert.Equal(t, entities.Money{Value: 72998, Currency: PointsCurrency, Scale: PointsScale}, NewMoney(729.98))
+	assert.Equal(t, int64(10), NewMoney(0.1).Value)
+	assert.Nil(t, NewMoneyIfSet(nil))
+
+	points, err := Points("sum", entities.Money{Value: 1050, Currency: PointsCurrency, Scale: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 10.5, points)
+	points, err = Points("sum", entities.Money{Value: 7, Currency: PointsCurrency})
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, points)
+
+	_, err = Points("sum", entities.Money{Value: 1, Currency: "USD"})
+	assert.EqualError(t, err, "Request is invalid: sum.currency must be "+PointsCurrency)
+	_, err = Points("sum", entities.Money{Value: 1, Currency: PointsCurrency, Scale: 3})
+	assert.Error(t, err)
+}
@@ -2,26 +2,27 @@ package core
 
 import (
 	"context"
-	errors2 "errors"
+	"database/sql"
 	"github.com/joeljunstrom/go-luhn"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
 	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
 	"github.com/xbreathoflife/gophermart/internal/app/storage"
-	"net/http"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"go.uber.org/zap"
 	"time"
 	"unicode"
 )
 
-const NewStatus = "NEW"
-
 type OrderService struct {
 	OrderStorage storage.OrderStorage
+	Tx           storage.TxManager
 	Accrual      *AccrualService
 }
 
-func NewOrderService(orderStorage storage.OrderStorage, balanceStorage storage.BalanceStorage, serviceAddress string, ctx context.Context) *OrderService {
-	accrual := NewAccrualService(orderStorage, balanceStorage, serviceAddress, ctx)
-	service := OrderService{OrderStorage: orderStorage, Accrual: accrual}
+func NewOrderService(orderStorage storage.OrderStorage, balanceStorage storage.BalanceStorage, tx storage.TxManager, bus *events.Bus, accrualConfig AccrualConfig, log *zap.Logger, ctx context.Context) *OrderService {
+	accrual := NewAccrualService(orderStorage, balanceStorage, bus, accrualConfig, log, ctx)
+	service := OrderService{OrderStorage: orderStorage, Tx: tx, Accrual: accrual}
 	return &service
 }
 
@@ -34,59 +35,181 @@ func IsNumber(s string) bool {
 	return true
 }
 
-func (os *OrderService) CreateNewOrder(ctx context.Context, login string, orderNum string) (int, error) {
+// CreateNewOrder uploads the order and reports whether it was created, it is
+// not if the user uploaded it before.
+func (os *OrderService) CreateNewOrder(ctx context.Context, login string, orderNum string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "core", "OrderService.CreateNewOrder")
+	defer span.End()
+
 	if !IsNumber(orderNum) {
-		return http.StatusBadRequest, errors2.New("not a number")
+		return false, errors.NewFieldError("number", "must be a number")
 	}
 
 	if !luhn.Valid(orderNum) {
-		return http.StatusUnprocessableEntity, errors.NewWrongDataError(orderNum)
+		return false, errors.NewInvalidOrderNumberError(orderNum)
 	}
 
 	order, err := os.OrderStorage.GetOrderIfExists(ctx, orderNum)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return false, err
 	}
 	if order != nil {
 		if order.Login == login {
-			return http.StatusOK, nil
+			return false, nil
 		}
-		return http.StatusConflict, errors.NewDuplicateError(orderNum)
+		return false, errors.NewDuplicateError(orderNum)
 	}
 
-	err = os.OrderStorage.InsertNewOrder(ctx, entities.OrderModel{
-		OrderNum:   orderNum,
-		Login:      login,
-		UploadedAt: time.Now(),
-		Status:     NewStatus,
+	uploadedAt := time.Now()
+	err = os.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		err := os.OrderStorage.InsertNewOrder(ctx, entities.OrderModel{
+			OrderNum:   orderNum,
+			Login:      login,
+			UploadedAt: uploadedAt,
+			Status:     NewStatus,
+		})
+		if err != nil {
+			return err
+		}
+
+		return os.OrderStorage.InsertOrderStatusHistory(ctx, entities.OrderStatusHistoryModel{
+			OrderNum:  orderNum,
+			Status:    NewStatus,
+			ChangedAt: uploadedAt,
+		})
 	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return false, err
 	}
 
-	os.Accrual.Channel <- orderNum // отправляем результат в канал
+	os.Accrual.Enqueue(orderNum) // отправляем результат в канал
 
-	return http.StatusAccepted, nil
+	return true, nil
 }
 
-func (os *OrderService) GetOrdersForUser(ctx context.Context, login string) ([]entities.OrderResponse, error) {
-	orders, err := os.OrderStorage.GetOrdersForUser(ctx, login)
+func (os *OrderService) CancelOrder(ctx context.Context, login string, orderNum string) error {
+	ctx, span := tracing.Start(ctx, "core", "OrderService.CancelOrder")
+	defer span.End()
+
+	order, err := os.OrderStorage.GetOrderIfExists(ctx, orderNum)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if order == nil || order.Login != login {
+		return errors.NewNotFoundError("order", orderNum)
+	}
+	if !CanCancel(order.Status) {
+		return errors.NewIllegalTransitionError(order.Status, "CANCELLED")
+	}
+
+	deleted, err := os.OrderStorage.DeleteOrder(ctx, orderNum, order.Status)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.NewConflictError("order status changed, try again")
 	}
+
+	// the order may still be queued, the worker drops it once it finds the
+	// order deleted, even if the number is uploaded again meanwhile
+	return nil
+}
+
+func (os *OrderService) GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderResponse, *entities.PageInfo, error) {
+	ctx, span := tracing.Start(ctx, "core", "OrderService.GetOrdersForUser")
+	defer span.End()
+
+	limit := pageLimit(query)
+	query.Limit = 0
+	if limit > 0 {
+		query.Limit = limit + 1
+	}
+	query.Statuses = InternalStatuses(query.Statuses)
+	orders, err := os.OrderStorage.GetOrdersForUser(ctx, login, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	total, err := os.OrderStorage.CountOrdersForUser(ctx, login, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	page := entities.PageInfo{Total: total, Limit: limit}
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		page.NextCursor = EncodeCursor(entities.Cursor{At: last.UploadedAt, ID: last.ID})
+	}
+
 	var ordersResponse []entities.OrderResponse
 	for _, o := range orders {
-		var accrual *float64 = nil
-		if o.Accrual.Valid {
-			accrual = &(o.Accrual.Float64)
-		}
-		ordersResponse = append(ordersResponse, entities.OrderResponse{
-			OrderNum:   o.OrderNum,
-			UploadedAt: o.UploadedAt.Format(time.RFC3339),
-			Status:     o.Status,
-			Accrual:    accrual,
+		ordersResponse = append(ordersResponse, orderResponse(o))
+	}
+
+	return ordersResponse, &page, nil
+}
+
+// GetOrder returns the order with its status history.
+func (os *OrderService) GetOrder(ctx context.Context, login string, orderNum string) (*entities.OrderDetailResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "OrderService.GetOrder")
+	defer span.End()
+
+	order, err := os.OrderStorage.GetOrderIfExists(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil || order.Login != login {
+		return nil, errors.NewNotFoundError("order", orderNum)
+	}
+
+	history, err := os.OrderStorage.GetOrderStatusHistory(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := entities.OrderDetailResponse{
+		OrderResponse: orderResponse(*order),
+		History:       []entities.OrderStatusHistoryResponse{},
+	}
+	for _, h := range history {
+		detail.History = append(detail.History, entities.OrderStatusHistoryResponse{
+			Status:    h.Status,
+			Accrual:   nullFloat(h.Accrual),
+			ChangedAt: h.ChangedAt.Format(time.RFC3339),
 		})
 	}
 
-	return ordersResponse, nil
+	return &detail, nil
+}
+
+// GetOrderStatus returns the status of any order for partners.
+func (os *OrderService) GetOrderStatus(ctx context.Context, orderNum string) (*entities.OrderResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "OrderService.GetOrderStatus")
+	defer span.End()
+
+	order, err := os.OrderStorage.GetOrderIfExists(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, errors.NewNotFoundError("order", orderNum)
+	}
+	response := orderResponse(*order)
+	return &response, nil
+}
+
+func orderResponse(o entities.OrderModel) entities.OrderResponse {
+	return entities.OrderResponse{
+		OrderNum:   o.OrderNum,
+		UploadedAt: o.UploadedAt.Format(time.RFC3339),
+		Status:     PublicStatus(o.Status),
+		Accrual:    nullFloat(o.Accrual),
+	}
+}
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
 }
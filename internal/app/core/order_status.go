@@ -0,0 +1,71 @@
+package core
+
+import (
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+)
+
+// Internal order statuses. NEW is set on upload, the rest come from the accrual system.
+const (
+	NewStatus        = "NEW"
+	RegisteredStatus = "REGISTERED"
+	ProcessingStatus = "PROCESSING"
+	InvalidStatus    = "INVALID"
+	ProcessedStatus  = "PROCESSED"
+)
+
+// OrderStatuses are the statuses visible through the public API.
+var OrderStatuses = []string{NewStatus, ProcessingStatus, InvalidStatus, ProcessedStatus}
+
+var orderTransitions = map[string][]string{
+	NewStatus:        {RegisteredStatus, ProcessingStatus, InvalidStatus, ProcessedStatus},
+	RegisteredStatus: {ProcessingStatus, InvalidStatus, ProcessedStatus},
+	ProcessingStatus: {InvalidStatus, ProcessedStatus},
+	InvalidStatus:    {},
+	ProcessedStatus:  {},
+}
+
+// publicStatuses maps internal statuses which are not part of the public API.
+var publicStatuses = map[string]string{
+	RegisteredStatus: NewStatus,
+}
+
+func IsFinalStatus(status string) bool {
+	next, ok := orderTransitions[status]
+	return ok && len(next) == 0
+}
+
+// CanCancel reports whether the order may still be withdrawn by the user.
+func CanCancel(status string) bool {
+	_, known := orderTransitions[status]
+	return known && !IsFinalStatus(status)
+}
+
+func ValidateTransition(from string, to string) error {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return errors.NewIllegalTransitionError(from, to)
+}
+
+func PublicStatus(status string) string {
+	if public, ok := publicStatuses[status]; ok {
+		return public
+	}
+	return status
+}
+
+// InternalStatuses expands public statuses into the internal ones they cover.
+func InternalStatuses(statuses []string) []string {
+	var internal []string
+	for _, s := range statuses {
+		internal = append(internal, s)
+		for from, to := range publicStatuses {
+			if to == s {
+				internal = append(internal, from)
+			}
+		}
+	}
+	return internal
+}
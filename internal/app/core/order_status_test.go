@@ -0,0 +1,41 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from  string
+		to    string
+		legal bool
+	}{
+		{from: NewStatus, to: RegisteredStatus, legal: true},
+		{from: NewStatus, to: ProcessedStatus, legal: true},
+		{from: RegisteredStatus, to: ProcessingStatus, legal: true},
+		{from: ProcessingStatus, to: InvalidStatus, legal: true},
+		{from: ProcessingStatus, to: RegisteredStatus, legal: false},
+		{from: ProcessingStatus, to: NewStatus, legal: false},
+		{from: ProcessedStatus, to: ProcessingStatus, legal: false},
+		{from: ProcessedStatus, to: InvalidStatus, legal: false},
+		{from: InvalidStatus, to: ProcessedStatus, legal: false},
+		{from: "UNKNOWN", to: ProcessedStatus, legal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			err := ValidateTransition(tt.from, tt.to)
+			assert.Equal(t, tt.legal, err == nil)
+		})
+	}
+}
+
+func TestPublicStatus(t *testing.T) {
+	assert.Equal(t, NewStatus, PublicStatus(RegisteredStatus))
+	assert.Equal(t, ProcessingStatus, PublicStatus(ProcessingStatus))
+	assert.ElementsMatch(t, []string{NewStatus, RegisteredStatus, InvalidStatus}, InternalStatuses([]string{NewStatus, InvalidStatus}))
+	assert.True(t, IsFinalStatus(ProcessedStatus))
+	assert.True(t, IsFinalStatus(InvalidStatus))
+	assert.False(t, IsFinalStatus(RegisteredStatus))
+}
@@ -0,0 +1,20 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"time"
+)
+
+func newOutboxEvent(login string, eventType string, data interface{}) (entities.OutboxEventModel, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return entities.OutboxEventModel{}, err
+	}
+	return entities.OutboxEventModel{
+		EventType: eventType,
+		Login:     login,
+		Payload:   string(payload),
+		CreatedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"encoding/base64"
+	errors2 "errors"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var ErrBadCursor = errors2.New("bad cursor")
+
+// EncodeCursor packs the position of the last row of a page into an opaque token.
+func EncodeCursor(c entities.Cursor) string {
+	raw := fmt.Sprintf("%d:%d", c.At.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (*entities.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrBadCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	return &entities.Cursor{At: time.Unix(0, nanos), ID: id}, nil
+}
+
+// pageLimit returns the page size for query, 0 when the query is unbounded.
+func pageLimit(query entities.ListQuery) int {
+	if query.Unbounded {
+		return 0
+	}
+	return normalizeLimit(query.Limit)
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return limit
+}
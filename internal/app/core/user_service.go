@@ -5,19 +5,24 @@ import (
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
 	"github.com/xbreathoflife/gophermart/internal/app/errors"
 	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
 )
 
 type UserService struct {
 	UserStorage    storage.UserStorage
 	BalanceStorage storage.BalanceStorage
+	Tx             storage.TxManager
 }
 
-func NewUserService(userStorage storage.UserStorage, balanceStorage storage.BalanceStorage) *UserService {
-	service := UserService{UserStorage: userStorage, BalanceStorage: balanceStorage}
+func NewUserService(userStorage storage.UserStorage, balanceStorage storage.BalanceStorage, tx storage.TxManager) *UserService {
+	service := UserService{UserStorage: userStorage, BalanceStorage: balanceStorage, Tx: tx}
 	return &service
 }
 
 func (us *UserService) CheckUserExists(ctx context.Context, user entities.LoginRequest) error {
+	ctx, span := tracing.Start(ctx, "core", "UserService.CheckUserExists")
+	defer span.End()
+
 	prevUser, err := us.UserStorage.GetUserIfExists(ctx, user.Login)
 	if err != nil {
 		return err
@@ -28,37 +33,52 @@ func (us *UserService) CheckUserExists(ctx context.Context, user entities.LoginR
 	return nil
 }
 
+// InsertNewUser creates the user together with its balance, so that no user is left without one.
 func (us *UserService) InsertNewUser(ctx context.Context, user entities.UserModel) error {
-	err := us.UserStorage.InsertNewUser(ctx, user)
-	if err != nil {
-		return err
-	}
-	return us.BalanceStorage.InsertNewBalance(ctx, entities.BalanceModel{Login: user.Login})
+	ctx, span := tracing.Start(ctx, "core", "UserService.InsertNewUser")
+	defer span.End()
+
+	return us.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		err := us.UserStorage.InsertNewUser(ctx, user)
+		if err != nil {
+			return err
+		}
+		return us.BalanceStorage.InsertNewBalance(ctx, entities.BalanceModel{Login: user.Login})
+	})
 }
 
 func (us *UserService) CheckUserCredentials(ctx context.Context, user entities.LoginRequest) error {
+	ctx, span := tracing.Start(ctx, "core", "UserService.CheckUserCredentials")
+	defer span.End()
+
 	prevUser, err := us.UserStorage.GetUserIfExists(ctx, user.Login)
 	if err != nil {
 		return err
 	}
 	if prevUser == nil || (prevUser.PasswordHash != user.Password || prevUser.Login != user.Login) {
-		return errors.NewWrongDataError(prevUser.Login)
+		return errors.NewAuthenticationError()
 	}
 
 	return nil
 }
 
 func (us *UserService) UpdateUserSession(ctx context.Context, userSession entities.UserSessionModel) error {
+	ctx, span := tracing.Start(ctx, "core", "UserService.UpdateUserSession")
+	defer span.End()
+
 	return us.UserStorage.UpdateUserSession(ctx, userSession)
 }
 
 func (us *UserService) GetUserBySession(ctx context.Context, session string) (*entities.UserSessionModel, error) {
+	ctx, span := tracing.Start(ctx, "core", "UserService.GetUserBySession")
+	defer span.End()
+
 	sessionModel, err := us.UserStorage.GetUserBySessionIfExists(ctx, session)
 	if err != nil {
 		return nil, err
 	}
 	if sessionModel == nil {
-		return nil, errors.NewWrongDataError(session)
+		return nil, errors.NewAuthenticationError()
 	}
 	return sessionModel, nil
 }
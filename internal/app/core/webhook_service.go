@@ -0,0 +1,415 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"github.com/xbreathoflife/gophermart/internal/app/webhook"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WebhookTestEvent = "webhook.test"
+
+	// MaxWebhookAttempts bounds WebhookConfig.MaxAttempts.
+	MaxWebhookAttempts = 20
+
+	webhookBaseBackoff   = time.Second
+	webhookMaxBackoff    = time.Hour
+	webhookDeliveryLimit = 50
+
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookQueueSize   = 100
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// WebhookConfig configures the deliveries of webhooks, zero values mean the
+// defaults.
+type WebhookConfig struct {
+	// QueueSize is the number of deliveries waiting to be made.
+	QueueSize int
+	// MaxAttempts is the number of attempts of a delivery before it is given up.
+	MaxAttempts int
+	// Timeout limits a single attempt.
+	Timeout time.Duration
+	// AllowPrivateNetworks allows webhooks on loopback, private and link-local
+	// addresses, e.g. for local development.
+	AllowPrivateNetworks bool
+}
+
+func (c WebhookConfig) withDefaults() WebhookConfig {
+	if c.QueueSize == 0 {
+		c.QueueSize = defaultWebhookQueueSize
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultWebhookTimeout
+	}
+	return c
+}
+
+// WebhookEventTypes are the events which can be subscribed to.
+var WebhookEventTypes = []string{events.OrderProcessed, events.BalanceWithdrawn}
+
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt string      `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookJob struct {
+	webhook entities.WebhookModel
+	event   events.Event
+	attempt int
+}
+
+// WebhookSender makes a single delivery, returning the response status code.
+type WebhookSender interface {
+	Send(ctx context.Context, url string, secret string, eventType string, body []byte) (int, error)
+}
+
+type WebhookService struct {
+	WebhookStorage storage.WebhookStorage
+	Events         *events.Bus
+	Sender         WebhookSender
+	Log            *zap.Logger
+	maxAttempts    int
+	allowPrivate   bool
+	queue          chan webhookJob
+}
+
+func NewWebhookService(webhookStorage storage.WebhookStorage, bus *events.Bus, config WebhookConfig, log *zap.Logger, ctx context.Context) *WebhookService {
+	config = config.withDefaults()
+	service := WebhookService{
+		WebhookStorage: webhookStorage,
+		Events:         bus,
+		Sender:         webhook.NewSender(config.Timeout, config.AllowPrivateNetworks),
+		Log:            log,
+		maxAttempts:    config.MaxAttempts,
+		allowPrivate:   config.AllowPrivateNetworks,
+		queue:          make(chan webhookJob, config.QueueSize),
+	}
+	go service.listen(ctx)
+	go service.deliverQueued(ctx)
+	return &service
+}
+
+func (ws *WebhookService) CreateWebhook(ctx context.Context, login string, req entities.WebhookRequest) (*entities.WebhookResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "WebhookService.CreateWebhook")
+	defer span.End()
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.NewFieldError("url", "must be an http or https URL")
+	}
+	if !ws.allowPrivate && isPrivateHost(u.Hostname()) {
+		return nil, errors.NewFieldError("url", "must not point to a loopback or private network address")
+	}
+	eventTypes := req.EventTypes
+	if len(eventTypes) == 0 {
+		eventTypes = WebhookEventTypes
+	}
+	for _, t := range eventTypes {
+		if !containsString(WebhookEventTypes, t) {
+			return nil, errors.NewFieldError("event_types", "unknown event type "+t)
+		}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook := entities.WebhookModel{
+		Login:      login,
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		CreatedAt:  time.Now(),
+	}
+	webhook.ID, err = ws.WebhookStorage.InsertWebhook(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	response := webhookResponse(webhook)
+	response.Secret = secret
+	return &response, nil
+}
+
+func (ws *WebhookService) GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "WebhookService.GetWebhooksForUser")
+	defer span.End()
+
+	webhooks, err := ws.WebhookStorage.GetWebhooksForUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	var response []entities.WebhookResponse
+	for _, w := range webhooks {
+		response = append(response, webhookResponse(w))
+	}
+	return response, nil
+}
+
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, login string, id int64) error {
+	ctx, span := tracing.Start(ctx, "core", "WebhookService.DeleteWebhook")
+	defer span.End()
+
+	deleted, err := ws.WebhookStorage.DeleteWebhook(ctx, id, login)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.NewNotFoundError("webhook", strconv.FormatInt(id, 10))
+	}
+	return nil
+}
+
+func (ws *WebhookService) GetDeliveries(ctx context.Context, login string, id int64) ([]entities.WebhookDeliveryResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "WebhookService.GetDeliveries")
+	defer span.End()
+
+	if _, err := ws.getUsersWebhook(ctx, login, id); err != nil {
+		return nil, err
+	}
+	deliveries, err := ws.WebhookStorage.GetWebhookDeliveries(ctx, id, webhookDeliveryLimit)
+	if err != nil {
+		return nil, err
+	}
+	var response []entities.WebhookDeliveryResponse
+	for _, d := range deliveries {
+		response = append(response, deliveryResponse(d))
+	}
+	return response, nil
+}
+
+// SendTestEvent delivers a test event synchronously, once, and returns the logged delivery.
+func (ws *WebhookService) SendTestEvent(ctx context.Context, login string, id int64) (*entities.WebhookDeliveryResponse, error) {
+	ctx, span := tracing.Start(ctx, "core", "WebhookService.SendTestEvent")
+	defer span.End()
+
+	hook, err := ws.getUsersWebhook(ctx, login, id)
+	if err != nil {
+		return nil, err
+	}
+	event := events.Event{Type: WebhookTestEvent, Login: login, At: time.Now(), Data: map[string]int64{"webhook_id": id}}
+	delivery, err := ws.deliver(ctx, webhookJob{webhook: *hook, event: event, attempt: 1})
+	if err != nil {
+		return nil, err
+	}
+	response := deliveryResponse(*delivery)
+	return &response, nil
+}
+
+func (ws *WebhookService) getUsersWebhook(ctx context.Context, login string, id int64) (*entities.WebhookModel, error) {
+	hook, err := ws.WebhookStorage.GetWebhookIfExists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil || hook.Login != login {
+		return nil, errors.NewNotFoundError("webhook", strconv.FormatInt(id, 10))
+	}
+	return hook, nil
+}
+
+// listen fans out bus events to the subscribed webhooks. When the bus drops
+// the subscription because the listener fell behind, it resumes from the last
+// seen event.
+func (ws *WebhookService) listen(ctx context.Context) {
+	var lastID string
+	for {
+		backlog, ch, cancel := ws.Events.Subscribe("", lastID)
+		for _, e := range backlog {
+			if e.Type == events.StreamReset {
+				logging.For(ctx, ws.Log).Warn("webhook events were dropped from the history before they were dispatched",
+					zap.String("last_event_id", lastID))
+			}
+			ws.dispatch(ctx, e)
+			lastID = e.ID
+		}
+		for e := range ch {
+			ws.dispatch(ctx, e)
+			lastID = e.ID
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (ws *WebhookService) dispatch(ctx context.Context, e events.Event) {
+	if !containsString(WebhookEventTypes, e.Type) {
+		return
+	}
+	webhooks, err := ws.WebhookStorage.GetWebhooksForUser(ctx, e.Login)
+	if err != nil {
+		logging.For(ctx, ws.Log).Error("failed to get webhooks", zap.String("login", e.Login), zap.Error(err))
+		return
+	}
+	for _, w := range webhooks {
+		if containsString(w.EventTypes, e.Type) {
+			ws.enqueue(ctx, webhookJob{webhook: w, event: e, attempt: 1}, 0)
+		}
+	}
+}
+
+func (ws *WebhookService) enqueue(ctx context.Context, job webhookJob, delay time.Duration) {
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case ws.queue <- job:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (ws *WebhookService) deliverQueued(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-ws.queue:
+			if job.attempt > 1 && !ws.webhookExists(ctx, job.webhook.ID) {
+				continue
+			}
+			delivery, err := ws.deliver(ctx, job)
+			if err != nil {
+				ws.Log.Error("failed to record webhook delivery", zap.Int64("webhook_id", job.webhook.ID),
+					zap.Int("attempt", job.attempt), zap.Error(err))
+			}
+			if delivery != nil && !delivery.Success && job.attempt < ws.maxAttempts {
+				backoff := webhookBackoff(job.attempt)
+				job.attempt++
+				ws.enqueue(ctx, job, backoff)
+			}
+		}
+	}
+}
+
+// webhookBackoff returns the delay after the given failed attempt, it doubles
+// with every attempt up to webhookMaxBackoff.
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
+
+// webhookExists reports whether the webhook was not deleted since the delivery
+// was queued, it is assumed to exist if that can not be told.
+func (ws *WebhookService) webhookExists(ctx context.Context, id int64) bool {
+	hook, err := ws.WebhookStorage.GetWebhookIfExists(ctx, id)
+	if err != nil {
+		ws.Log.Error("failed to get webhook", zap.Int64("webhook_id", id), zap.Error(err))
+		return true
+	}
+	return hook != nil
+}
+
+// isPrivateHost tells the hosts which obviously are not public, names
+// resolving to such addresses are rejected when delivering.
+func isPrivateHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return webhook.IsForbiddenIP(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// deliver makes a single signed POST to the webhook and records the attempt in the delivery log.
+func (ws *WebhookService) deliver(ctx context.Context, job webhookJob) (*entities.WebhookDeliveryModel, error) {
+	body, err := json.Marshal(webhookPayload{
+		ID:        job.event.ID,
+		Type:      job.event.Type,
+		CreatedAt: job.event.At.Format(time.RFC3339),
+		Data:      job.event.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := entities.WebhookDeliveryModel{
+		WebhookID: job.webhook.ID,
+		EventID:   job.event.ID,
+		EventType: job.event.Type,
+		Payload:   string(body),
+		Attempt:   job.attempt,
+	}
+	statusCode, err := ws.Sender.Send(ctx, job.webhook.URL, job.webhook.Secret, job.event.Type, body)
+	delivery.StatusCode = statusCode
+	delivery.DeliveredAt = time.Now()
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.Success = true
+	}
+
+	delivery.ID, err = ws.WebhookStorage.InsertWebhookDelivery(ctx, delivery)
+	return &delivery, err
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func webhookResponse(w entities.WebhookModel) entities.WebhookResponse {
+	return entities.WebhookResponse{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: w.EventTypes,
+		CreatedAt:  w.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func deliveryResponse(d entities.WebhookDeliveryModel) entities.WebhookDeliveryResponse {
+	return entities.WebhookDeliveryResponse{
+		ID:          d.ID,
+		EventID:     d.EventID,
+		EventType:   d.EventType,
+		Attempt:     d.Attempt,
+		StatusCode:  d.StatusCode,
+		Error:       d.Error,
+		Success:     d.Success,
+		DeliveredAt: d.DeliveredAt.Format(time.RFC3339),
+	}
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
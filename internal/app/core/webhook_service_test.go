@@ -0,0 +1,13 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, webhookBackoff(1))
+	assert.Equal(t, 8*time.Second, webhookBackoff(4))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(64), "the backoff does not overflow")
+}
@@ -0,0 +1,107 @@
+// Package cors lets web frontends on other origins call the API with the
+// session cookie.
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	allowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	allowedHeaders = []string{"Content-Type", "Content-Encoding", "Idempotency-Key", "Last-Event-ID", "X-CSRF-Token"}
+	exposedHeaders = []string{"Idempotent-Replayed", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+		"RateLimit-Policy", "Retry-After", "X-CSRF-Token"}
+)
+
+type Config struct {
+	// AllowedOrigins are origins like "https://app.example.com", which may
+	// start with a wildcard subdomain like "https://*.example.com". "*" allows
+	// any origin, but without the session cookie.
+	AllowedOrigins []string
+	// MaxAge is how long browsers may cache the preflight response.
+	MaxAge time.Duration
+}
+
+type Policy struct {
+	origins   []string
+	anyOrigin bool
+	maxAge    string
+}
+
+func New(cfg Config) *Policy {
+	p := &Policy{maxAge: strconv.Itoa(int(cfg.MaxAge.Seconds()))}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch origin {
+		case "":
+		case "*":
+			p.anyOrigin = true
+		default:
+			p.origins = append(p.origins, origin)
+		}
+	}
+	return p
+}
+
+func (p *Policy) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.origins {
+		if allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, domain := allowed[:i+3], allowed[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handler adds the CORS headers for allowed origins and answers preflight
+// requests before they are routed.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		credentials := p.allowed(origin)
+		if !credentials && !p.anyOrigin {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if credentials {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+		h.Set("Access-Control-Max-Age", p.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
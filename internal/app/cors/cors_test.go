@@ -0,0 +1,71 @@
+package cors
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Handler(t *testing.T) {
+	policy := New(Config{AllowedOrigins: []string{"https://app.example.com", "https://*.gophermart.dev"}, MaxAge: time.Minute})
+	h := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		status      int
+		allowOrigin string
+	}{
+		{name: "same origin", method: http.MethodGet, status: http.StatusOK},
+		{name: "allowed", method: http.MethodPost, origin: "https://app.example.com", status: http.StatusOK, allowOrigin: "https://app.example.com"},
+		{name: "wildcard subdomain", method: http.MethodGet, origin: "https://web.gophermart.dev", status: http.StatusOK, allowOrigin: "https://web.gophermart.dev"},
+		{name: "wildcard needs subdomain", method: http.MethodGet, origin: "https://gophermart.dev", status: http.StatusOK},
+		{name: "other scheme", method: http.MethodGet, origin: "http://app.example.com", status: http.StatusOK},
+		{name: "not allowed", method: http.MethodGet, origin: "https://evil.example", status: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, origin: "https://app.example.com", preflight: true, status: http.StatusNoContent, allowOrigin: "https://app.example.com"},
+		{name: "preflight not allowed", method: http.MethodOptions, origin: "https://evil.example", preflight: true, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/user/balance/withdraw", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.allowOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			if tt.allowOrigin == "" {
+				return
+			}
+			assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+			if tt.preflight {
+				assert.Equal(t, "60", rec.Header().Get("Access-Control-Max-Age"))
+				assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "X-CSRF-Token")
+			} else {
+				assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), "Retry-After")
+			}
+		})
+	}
+}
+
+func TestPolicy_Handler_AnyOrigin(t *testing.T) {
+	h := New(Config{AllowedOrigins: []string{"*"}}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"), "any origin must not get the cookie")
+}
@@ -0,0 +1,75 @@
+package csrf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProtector_Handler(t *testing.T) {
+	const session = "encrypted-session"
+	browser := New(Config{Secret: "secret", CookieName: "authorization"})
+	always := New(Config{Mode: ModeAlways, Secret: "secret", CookieName: "authorization"})
+	token := browser.Token(session)
+
+	tests := []struct {
+		name      string
+		protector *Protector
+		method    string
+		cookie    bool
+		origin    string
+		token     string
+		status    int
+	}{
+		{name: "safe method", protector: browser, method: http.MethodGet, cookie: true, origin: "https://evil.example", status: http.StatusOK},
+		{name: "no cookie", protector: browser, method: http.MethodPost, origin: "https://evil.example", status: http.StatusOK},
+		{name: "browser without token", protector: browser, method: http.MethodPost, cookie: true, origin: "https://evil.example", status: http.StatusForbidden},
+		{name: "browser with wrong token", protector: browser, method: http.MethodDelete, cookie: true, origin: "https://app.example.com", token: "123", status: http.StatusForbidden},
+		{name: "browser with token", protector: browser, method: http.MethodPost, cookie: true, origin: "https://app.example.com", token: token, status: http.StatusOK},
+		{name: "api client", protector: browser, method: http.MethodPost, cookie: true, status: http.StatusOK},
+		{name: "api client always", protector: always, method: http.MethodPost, cookie: true, status: http.StatusForbidden},
+		{name: "api client always with token", protector: always, method: http.MethodPost, cookie: true, token: token, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.protector.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/api/user/balance/withdraw", nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: "authorization", Value: session})
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.token != "" {
+				req.Header.Set(HeaderName, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusForbidden {
+				assert.JSONEq(t, `{"error":{"code":"csrf","message":"CSRF token missing or invalid"}}`, rec.Body.String())
+			}
+			if tt.method == http.MethodGet {
+				assert.Equal(t, token, rec.Header().Get(HeaderName))
+			}
+		})
+	}
+}
+
+func TestProtector_Token(t *testing.T) {
+	p := New(Config{Secret: "secret"})
+	assert.Equal(t, p.Token("a"), p.Token("a"))
+	assert.NotEqual(t, p.Token("a"), p.Token("b"), "tokens are bound to the session")
+	assert.NotEqual(t, p.Token("a"), New(Config{Secret: "other"}).Token("a"))
+	assert.NotEqual(t, New(Config{}).Token("a"), New(Config{}).Token("a"), "secrets are random by default")
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, ModeBrowser, mode)
+	_, err = ParseMode("strict")
+	assert.Error(t, err)
+}
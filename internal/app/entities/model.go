@@ -22,12 +22,47 @@ type OrderResponse struct {
 	Accrual    *float64 `json:"accrual,omitempty"`
 }
 
+type OrderStatusHistoryResponse struct {
+	Status    string   `json:"status"`
+	Accrual   *float64 `json:"accrual,omitempty"`
+	ChangedAt string   `json:"changed_at"`
+}
+
+type OrderDetailResponse struct {
+	OrderResponse
+	History []OrderStatusHistoryResponse `json:"history"`
+}
+
 type BalanceWithdrawalsResponse struct {
 	OrderNum    string  `json:"order"`
 	Sum         float64 `json:"sum"`
 	ProcessedAt string  `json:"processed_at"`
 }
 
+type WebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type WebhookResponse struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID          int64  `json:"id"`
+	EventID     string `json:"event_id"`
+	EventType   string `json:"event_type"`
+	Attempt     int    `json:"attempt"`
+	StatusCode  int    `json:"status_code"`
+	Error       string `json:"error,omitempty"`
+	Success     bool   `json:"success"`
+	DeliveredAt string `json:"delivered_at"`
+}
+
 type UserModel struct {
 	Login        string
 	PasswordHash string
@@ -40,6 +75,7 @@ type UserSessionModel struct {
 }
 
 type OrderModel struct {
+	ID         int64
 	OrderNum   string
 	Login      string
 	UploadedAt time.Time
@@ -47,6 +83,14 @@ type OrderModel struct {
 	Accrual    sql.NullFloat64
 }
 
+type OrderStatusHistoryModel struct {
+	ID        int64
+	OrderNum  string
+	Status    string
+	Accrual   sql.NullFloat64
+	ChangedAt time.Time
+}
+
 type BalanceModel struct {
 	Login   string  `json:"-"`
 	Balance float64 `json:"current"`
@@ -54,14 +98,178 @@ type BalanceModel struct {
 }
 
 type BalanceWithdrawalsModel struct {
+	ID          int64
 	Login       string
 	OrderNum    string
 	Sum         float64
 	ProcessedAt time.Time
 }
 
+type WebhookModel struct {
+	ID         int64
+	Login      string
+	URL        string
+	Secret     string
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+type WebhookDeliveryModel struct {
+	ID          int64
+	WebhookID   int64
+	EventID     string
+	EventType   string
+	Payload     string
+	Attempt     int
+	StatusCode  int
+	Error       string
+	Success     bool
+	DeliveredAt time.Time
+}
+
+type OutboxEventModel struct {
+	ID        int64
+	EventType string
+	Login     string
+	Payload   string
+	CreatedAt time.Time
+	Attempts  int
+	LastError string
+}
+
+type IdempotencyModel struct {
+	Login       string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	// Token identifies the request holding the key, a retry taking the key
+	// over gets a new one
+	Token string
+	// Committed is set once the operation guarded by the key is committed
+	Committed bool
+}
+
+type OrderStatusEvent struct {
+	OrderNum string   `json:"number"`
+	Status   string   `json:"status"`
+	Accrual  *float64 `json:"accrual,omitempty"`
+}
+
 type GetOrderStatusResponse struct {
 	OrderNum string   `json:"order"`
 	Status   string   `json:"status"`
 	Accrual  *float64 `json:"accrual,omitempty"`
 }
+
+type Cursor struct {
+	At time.Time
+	ID int64
+}
+
+type ListQuery struct {
+	After    *Cursor
+	Limit    int
+	Statuses []string
+	From     *time.Time
+	To       *time.Time
+	Desc     bool
+	// Unbounded lists every matching row instead of a default-sized page,
+	// the v1 endpoints use it when the client asks for neither limit nor cursor
+	Unbounded bool
+}
+
+type PageInfo struct {
+	Total      int
+	Limit      int
+	NextCursor string
+}
+
+type HealthCheckResponse struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Pending *int   `json:"pending,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status string                         `json:"status"`
+	Checks map[string]HealthCheckResponse `json:"checks"`
+}
+
+type CSRFTokenResponse struct {
+	Token string `json:"token"`
+}
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Money is an amount in minor units, Value / 10^Scale of Currency.
+type Money struct {
+	Value    int64  `json:"value"`
+	Currency string `json:"currency"`
+	Scale    int    `json:"scale"`
+}
+
+type OrderRequestV2 struct {
+	Number string `json:"number"`
+}
+
+type OrderV2 struct {
+	Number     string `json:"number"`
+	Status     string `json:"status"`
+	Accrual    *Money `json:"accrual"`
+	UploadedAt string `json:"uploaded_at"`
+}
+
+type OrderStatusHistoryV2 struct {
+	Status    string `json:"status"`
+	Accrual   *Money `json:"accrual"`
+	ChangedAt string `json:"changed_at"`
+}
+
+type OrderDetailV2 struct {
+	OrderV2
+	History []OrderStatusHistoryV2 `json:"history"`
+}
+
+type BalanceV2 struct {
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
+}
+
+type WithdrawRequestV2 struct {
+	Order string `json:"order"`
+	Sum   Money  `json:"sum"`
+}
+
+type WithdrawalV2 struct {
+	Order       string `json:"order"`
+	Sum         Money  `json:"sum"`
+	ProcessedAt string `json:"processed_at"`
+}
+
+type PageV2 struct {
+	Data       interface{}  `json:"data"`
+	Pagination PaginationV2 `json:"pagination"`
+}
+
+type PaginationV2 struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
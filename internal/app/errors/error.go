@@ -1,6 +1,33 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"strings"
+)
+
+// Codes identify the errors in API responses, clients may rely on them.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeNotFound           = "not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodeConflict           = "conflict"
+	CodeIllegalTransition  = "illegal_transition"
+	CodeInvalidOrderNumber = "invalid_order_number"
+	CodeUnprocessable      = "unprocessable"
+	CodeForbidden          = "forbidden"
+	CodeCSRF               = "csrf"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+)
+
+// Coded is implemented by the errors of this package.
+type Coded interface {
+	error
+	Code() string
+}
 
 type DuplicateError struct{
 	Duplicate string
@@ -10,22 +37,177 @@ func (e *DuplicateError) Error() string {
 	return fmt.Sprintf("Value %s already exists", e.Duplicate)
 }
 
+func (e *DuplicateError) Code() string {
+	return CodeAlreadyExists
+}
+
 func NewDuplicateError(duplicate string) *DuplicateError {
 	return &DuplicateError{
 		Duplicate: duplicate,
 	}
 }
 
-type WrongDataError struct{
-	Data string
+type IllegalTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("Illegal order status transition %s -> %s", e.From, e.To)
+}
+
+func (e *IllegalTransitionError) Code() string {
+	return CodeIllegalTransition
+}
+
+func NewIllegalTransitionError(from string, to string) *IllegalTransitionError {
+	return &IllegalTransitionError{
+		From: from,
+		To:   to,
+	}
+}
+
+// ValidationError reports a malformed request, with the invalid fields if known.
+type ValidationError struct {
+	Message string
+	Fields  []entities.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+" "+f.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(fields, ", "))
+}
+
+func (e *ValidationError) Code() string {
+	return CodeInvalidRequest
+}
+
+func NewValidationError(message string, fields ...entities.FieldError) *ValidationError {
+	return &ValidationError{
+		Message: message,
+		Fields:  fields,
+	}
+}
+
+// NewFieldError reports a single invalid field.
+func NewFieldError(field string, message string) *ValidationError {
+	return NewValidationError("Request is invalid", entities.FieldError{Field: field, Message: message})
+}
+
+// AuthenticationError is returned for wrong credentials and unknown sessions.
+type AuthenticationError struct{}
+
+func (e *AuthenticationError) Error() string {
+	return "Wrong username or password"
+}
+
+func (e *AuthenticationError) Code() string {
+	return CodeUnauthorized
+}
+
+func NewAuthenticationError() *AuthenticationError {
+	return &AuthenticationError{}
+}
+
+type InsufficientFundsError struct {
+	Sum float64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("Not enough points to withdraw %v", e.Sum)
+}
+
+func (e *InsufficientFundsError) Code() string {
+	return CodeInsufficientFunds
+}
+
+func NewInsufficientFundsError(sum float64) *InsufficientFundsError {
+	return &InsufficientFundsError{
+		Sum: sum,
+	}
+}
+
+// NotFoundError is also returned for resources of other users, which must not
+// be told apart from missing ones.
+type NotFoundError struct {
+	Resource string
+	ID       string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Resource, e.ID)
+}
+
+func (e *NotFoundError) Code() string {
+	return CodeNotFound
+}
+
+func NewNotFoundError(resource string, id string) *NotFoundError {
+	return &NotFoundError{
+		Resource: resource,
+		ID:       id,
+	}
+}
+
+// ConflictError reports a request which conflicts with the current state,
+// retrying it later may succeed.
+type ConflictError struct {
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+func (e *ConflictError) Code() string {
+	return CodeConflict
+}
+
+func NewConflictError(reason string) *ConflictError {
+	return &ConflictError{
+		Reason: reason,
+	}
+}
+
+type InvalidOrderNumberError struct {
+	Number string
+}
+
+func (e *InvalidOrderNumberError) Error() string {
+	return fmt.Sprintf("Order number %s fails the Luhn check", e.Number)
+}
+
+func (e *InvalidOrderNumberError) Code() string {
+	return CodeInvalidOrderNumber
+}
+
+func NewInvalidOrderNumberError(number string) *InvalidOrderNumberError {
+	return &InvalidOrderNumberError{
+		Number: number,
+	}
+}
+
+// UnprocessableError reports a well-formed request which can not be served.
+type UnprocessableError struct {
+	Reason string
+}
+
+func (e *UnprocessableError) Error() string {
+	return e.Reason
 }
 
-func (e *WrongDataError) Error() string {
-	return fmt.Sprintf("Couldn't find or accept data %s", e.Data)
+func (e *UnprocessableError) Code() string {
+	return CodeUnprocessable
 }
 
-func NewWrongDataError(login string) *WrongDataError {
-	return &WrongDataError{
-		Data: login,
+func NewUnprocessableError(reason string) *UnprocessableError {
+	return &UnprocessableError{
+		Reason: reason,
 	}
 }
@@ -0,0 +1,16 @@
+package errors
+
+import (
+	"encoding/json"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"net/http"
+)
+
+// WriteHTTP responds with the JSON error body the handlers use, for the
+// middlewares which reject requests before they reach a handler.
+func WriteHTTP(w http.ResponseWriter, statusCode int, code string, message string) {
+	js, _ := json.Marshal(entities.ErrorResponse{Error: entities.ErrorBody{Code: code, Message: message}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OrderStatusChanged = "order.status_changed"
+	OrderProcessed     = "order.processed"
+	BalanceChanged     = "balance.changed"
+	BalanceWithdrawn   = "balance.withdrawn"
+	// StreamReset is sent instead of the missed events when a subscriber
+	// resumes from an event which is not kept any more: it must reload the
+	// state it follows and resume from the id of the reset event.
+	StreamReset = "stream.reset"
+)
+
+const subscriberBuffer = 16
+
+// Event is identified by "<epoch>-<sequence>", the epoch is unique to the
+// process so that ids seen before a restart are not mistaken for new ones.
+type Event struct {
+	ID    string      `json:"id"`
+	Type  string      `json:"type"`
+	Login string      `json:"-"`
+	At    time.Time   `json:"at"`
+	Data  interface{} `json:"data"`
+}
+
+// Reset is the data of a StreamReset event.
+type Reset struct {
+	// LastEventID is the event the subscriber asked to resume from.
+	LastEventID string `json:"last_event_id"`
+}
+
+type subscription struct {
+	login string
+	ch    chan Event
+}
+
+// Bus is an in-process publish/subscribe hub. It keeps the last events in a
+// ring buffer so that subscribers can resume from the last seen event id.
+type Bus struct {
+	mu          sync.Mutex
+	epoch       string
+	lastSeq     int64
+	history     []Event
+	historySize int
+	subscribers map[*subscription]struct{}
+}
+
+func NewBus(historySize int) *Bus {
+	epoch := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return &Bus{epoch: epoch, historySize: historySize, subscribers: map[*subscription]struct{}{}}
+}
+
+func (b *Bus) id(seq int64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, seq)
+}
+
+// seq returns the sequence of an event id of this process.
+func (b *Bus) seq(id string) (int64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != b.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(id[i+1:], 10, 64)
+	return seq, err == nil
+}
+
+func (b *Bus) Publish(login string, eventType string, data interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastSeq++
+	event := Event{ID: b.id(b.lastSeq), Type: eventType, Login: login, At: time.Now(), Data: data}
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if sub.login != "" && sub.login != login {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// slow subscriber: close the stream, it can resume from the history
+			b.remove(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe returns events of the user published after lastID which are still
+// in the history and a channel with the new ones. If some events after lastID
+// are not in the history any more, e.g. lastID is from before a restart, the
+// backlog is a single StreamReset event instead. An empty login subscribes to
+// events of all users. The channel is closed by cancel or when the subscriber
+// falls behind.
+func (b *Bus) Subscribe(login string, lastID string) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []Event
+	if lastID != "" {
+		seq, ok := b.seq(lastID)
+		if ok && seq <= b.lastSeq && seq >= b.lastSeq-int64(len(b.history)) {
+			for _, e := range b.history[len(b.history)-int(b.lastSeq-seq):] {
+				if login == "" || e.Login == login {
+					backlog = append(backlog, e)
+				}
+			}
+		} else {
+			backlog = []Event{{ID: b.id(b.lastSeq), Type: StreamReset, Login: login, At: time.Now(),
+				Data: Reset{LastEventID: lastID}}}
+		}
+	}
+
+	sub := &subscription{login: login, ch: make(chan Event, subscriberBuffer)}
+	b.subscribers[sub] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+	return backlog, sub.ch, cancel
+}
+
+func (b *Bus) remove(sub *subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBus_Subscribe(t *testing.T) {
+	bus := NewBus(3)
+	first := bus.Publish("hello", OrderStatusChanged, "1")
+	bus.Publish("goodbye", OrderStatusChanged, "2")
+	bus.Publish("hello", BalanceChanged, "3")
+
+	backlog, ch, cancel := bus.Subscribe("hello", first.ID)
+	require.Len(t, backlog, 1)
+	assert.Equal(t, "3", backlog[0].Data)
+
+	bus.Publish("goodbye", BalanceChanged, "4")
+	bus.Publish("hello", BalanceChanged, "5")
+	event := <-ch
+	assert.Equal(t, "5", event.Data)
+	assert.Equal(t, "hello", event.Login)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	bus := NewBus(100)
+	_, ch, cancel := bus.Subscribe("", "")
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish("hello", BalanceChanged, i)
+	}
+	received := 0
+	for range ch {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+}
+
+func TestBus_Reset(t *testing.T) {
+	bus := NewBus(2)
+	first := bus.Publish("hello", OrderStatusChanged, "1")
+	second := bus.Publish("hello", OrderStatusChanged, "2")
+	bus.Publish("hello", OrderStatusChanged, "3")
+	last := bus.Publish("hello", OrderStatusChanged, "4")
+
+	backlog, _, cancel := bus.Subscribe("hello", second.ID)
+	cancel()
+	require.Len(t, backlog, 2)
+	assert.Equal(t, "3", backlog[0].Data)
+
+	restarted := NewBus(2)
+	restarted.epoch = "restarted"
+	for _, lastID := range []string{first.ID, "heh", "1", bus.epoch + "-5"} {
+		backlog, _, cancel = bus.Subscribe("hello", lastID)
+		cancel()
+		require.Len(t, backlog, 1, lastID)
+		assert.Equal(t, StreamReset, backlog[0].Type)
+		assert.Equal(t, last.ID, backlog[0].ID, "the stream resumes from the last event")
+		assert.Equal(t, Reset{LastEventID: lastID}, backlog[0].Data)
+	}
+
+	backlog, _, cancel = restarted.Subscribe("hello", last.ID)
+	cancel()
+	require.Len(t, backlog, 1)
+	assert.Equal(t, StreamReset, backlog[0].Type)
+	assert.Equal(t, "restarted-0", backlog[0].ID)
+}
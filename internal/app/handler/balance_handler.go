@@ -1,10 +1,8 @@
 package handler
 
 import (
-	"encoding/json"
 	"github.com/xbreathoflife/gophermart/internal/app/core"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
-	"io"
 	"net/http"
 )
 
@@ -22,22 +20,10 @@ func (h *BalanceHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	}
 	balance, err := h.Service.GetUsersBalance(ctx, sessionModel.Login)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	js, err := json.Marshal(balance)
-	if err != nil {
-		http.Error(w, "Error during building response json", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(js)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, balance)
 }
 
 func (h *BalanceHandler) PostBalanceWithdraw(w http.ResponseWriter, r *http.Request) {
@@ -47,24 +33,19 @@ func (h *BalanceHandler) PostBalanceWithdraw(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 	bw := entities.BalanceWithdrawRequest{}
-	if err := json.Unmarshal(b, &bw); err != nil {
-		http.Error(w, "Error during parsing request json", http.StatusBadRequest)
+	if err := readJSON(r, &bw); err != nil {
+		writeError(w, ctx, err)
 		return
 	}
 
-	statusCode, err := h.Service.ProcessBalanceWithdraw(ctx, sessionModel.Login, bw)
+	_, err := h.Service.ProcessBalanceWithdraw(ctx, sessionModel.Login, bw)
 	if err != nil {
-		http.Error(w, err.Error(), statusCode)
+		writeError(w, ctx, err)
 		return
 	}
 
-	w.WriteHeader(statusCode)
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h *BalanceHandler) GetBalanceWithdrawals(w http.ResponseWriter, r *http.Request) {
@@ -74,27 +55,25 @@ func (h *BalanceHandler) GetBalanceWithdrawals(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	orders, err := h.Service.GetWithdrawalsForUser(ctx, sessionModel.Login)
+	query, err := parseListQuery(r, nil)
 	if err != nil {
+		writeError(w, ctx, err)
 		return
 	}
+	// v1 clients predate paging, they get every row unless they ask for a page
+	query.Unbounded = query.Limit == 0 && query.After == nil
 
-	w.Header().Set("Content-Type", "application/json")
-	if len(orders) == 0 {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-
-	js, err := json.Marshal(orders)
+	orders, page, err := h.Service.GetWithdrawalsForUser(ctx, sessionModel.Login, query)
 	if err != nil {
-		http.Error(w, "Error during building response json", http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(js)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	writePageHeaders(w, page)
+	if len(orders) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	writeJSON(w, http.StatusOK, orders)
 }
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"github.com/xbreathoflife/gophermart/internal/app/auth"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"net/http"
+)
+
+type CSRFHandler struct {
+	Protector   *csrf.Protector
+	UserService *core.UserService
+}
+
+// GetToken returns the CSRF token of the session, for frontends which can not
+// read the response headers of other requests.
+func (h *CSRFHandler) GetToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+	cookie, err := r.Cookie(auth.CookieName)
+	if err != nil {
+		writeError(w, ctx, er.NewAuthenticationError())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, entities.CSRFTokenResponse{Token: h.Protector.Token(cookie.Value)})
+}
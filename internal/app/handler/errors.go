@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"net/http"
+)
+
+// statusCodes maps the codes of the domain errors to HTTP.
+var statusCodes = map[string]int{
+	er.CodeInvalidRequest:     http.StatusBadRequest,
+	er.CodeUnauthorized:       http.StatusUnauthorized,
+	er.CodeInsufficientFunds:  http.StatusPaymentRequired,
+	er.CodeNotFound:           http.StatusNotFound,
+	er.CodeAlreadyExists:      http.StatusConflict,
+	er.CodeConflict:           http.StatusConflict,
+	er.CodeIllegalTransition:  http.StatusConflict,
+	er.CodeInvalidOrderNumber: http.StatusUnprocessableEntity,
+	er.CodeUnprocessable:      http.StatusUnprocessableEntity,
+	er.CodeForbidden:          http.StatusForbidden,
+	er.CodeCSRF:               http.StatusForbidden,
+	er.CodeRateLimited:        http.StatusTooManyRequests,
+}
+
+// ErrorResponse returns the status code and JSON body of err. Errors which are
+// not domain errors are internal and their message is not shown to clients.
+func ErrorResponse(err error) (int, entities.ErrorResponse) {
+	var coded er.Coded
+	if !errors.As(err, &coded) {
+		return http.StatusInternalServerError, entities.ErrorResponse{Error: entities.ErrorBody{
+			Code:    er.CodeInternal,
+			Message: "Internal server error",
+		}}
+	}
+
+	statusCode, ok := statusCodes[coded.Code()]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+	body := entities.ErrorBody{Code: coded.Code(), Message: coded.Error()}
+	var validationErr *er.ValidationError
+	if errors.As(err, &validationErr) {
+		body.Details = validationErr.Fields
+	}
+	return statusCode, entities.ErrorResponse{Error: body}
+}
+
+// writeError responds with the JSON error of err, internal errors are logged
+// with the request instead.
+func writeError(w http.ResponseWriter, ctx context.Context, err error) {
+	statusCode, response := ErrorResponse(err)
+	if statusCode == http.StatusInternalServerError {
+		logging.SetError(ctx, err)
+	}
+	writeJSON(w, statusCode, response)
+}
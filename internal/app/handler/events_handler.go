@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"net/http"
+	"time"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+type EventsHandler struct {
+	Bus         *events.Bus
+	UserService *core.UserService
+	// Done is closed on shutdown to end the open streams.
+	Done <-chan struct{}
+}
+
+// StreamEvents sends order status and balance changes of the user as Server-Sent Events,
+// resuming after the Last-Event-ID header if given.
+func (h *EventsHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, ctx, errors.New("streaming is not supported"))
+		return
+	}
+
+	backlog, ch, cancel := h.Bus.Subscribe(sessionModel.Login, r.Header.Get("Last-Event-ID"))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.Done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
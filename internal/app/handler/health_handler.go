@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"net/http"
+)
+
+type HealthHandler struct {
+	Service *core.HealthService
+}
+
+// Liveness only tells that the process is up and serving HTTP.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, entities.HealthCheckResponse{Status: core.HealthOK})
+}
+
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	response, ready := h.Service.Readiness(r.Context())
+	statusCode := http.StatusOK
+	if !ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeJSON(w, statusCode, response)
+}
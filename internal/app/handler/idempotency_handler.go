@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+type IdempotencyHandler struct {
+	Service     *core.IdempotencyService
+	UserService *core.UserService
+	Log         *zap.Logger
+}
+
+// responseRecorder passes the response through and keeps a copy to store it for replays.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Middleware de-duplicates requests carrying the Idempotency-Key header: the
+// first response is stored per user and key and replayed for repeats.
+func (h *IdempotencyHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, ctx, er.NewFieldError("header."+IdempotencyKeyHeader, "is too long"))
+			return
+		}
+
+		sessionModel := checkAuth(h.UserService, w, ctx)
+		if sessionModel == nil {
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, ctx, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+
+		requestHash := core.HashRequest(r.Method, r.URL.Path, b)
+		reserved, stored, err := h.Service.Begin(ctx, sessionModel.Login, key, requestHash)
+		if err != nil {
+			writeError(w, ctx, err)
+			return
+		}
+		if stored != nil {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(stored.StatusCode)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		ctx = reserved
+		r = r.WithContext(ctx)
+		log := logging.For(ctx, h.Log)
+		rec := &responseRecorder{ResponseWriter: w}
+		// the key is released if the handler panics, so that the request can be
+		// retried, and the panic goes on to the recoverer
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			p := recover()
+			if err := h.Service.Release(ctx); err != nil {
+				log.Error("failed to release idempotency key", zap.Error(err))
+			}
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+		if rec.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		err = h.Service.Complete(ctx, entities.IdempotencyModel{
+			StatusCode:  rec.statusCode,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			// the deferred call releases the key, if that fails too the key
+			// expires once its request is stale
+			log.Error("failed to save idempotency key", zap.Error(err))
+			return
+		}
+		completed = true
+	})
+}
@@ -1,7 +1,7 @@
 package handler
 
 import (
-	"encoding/json"
+	"github.com/go-chi/chi/v5"
 	"github.com/xbreathoflife/gophermart/internal/app/core"
 	"io"
 	"net/http"
@@ -22,17 +22,37 @@ func (h *OrderHandler) PostNewOrderHandler(w http.ResponseWriter, r *http.Reques
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 	orderNum := string(b)
-	statusCode, err := h.Service.CreateNewOrder(ctx, sessionModel.Login, orderNum)
+	created, err := h.Service.CreateNewOrder(ctx, sessionModel.Login, orderNum)
 	if err != nil {
-		http.Error(w, err.Error(), statusCode)
+		writeError(w, ctx, err)
 		return
 	}
 
-	w.WriteHeader(statusCode)
+	if created {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *OrderHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	err := h.Service.CancelOrder(ctx, sessionModel.Login, chi.URLParam(r, "number"))
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 
@@ -43,27 +63,40 @@ func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders, err := h.Service.GetOrdersForUser(ctx, sessionModel.Login)
+	query, err := parseListQuery(r, core.OrderStatuses)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	// v1 clients predate paging, they get every row unless they ask for a page
+	query.Unbounded = query.Limit == 0 && query.After == nil
+
+	orders, page, err := h.Service.GetOrdersForUser(ctx, sessionModel.Login, query)
 	if err != nil {
+		writeError(w, ctx, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	writePageHeaders(w, page)
 	if len(orders) == 0 {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	writeJSON(w, http.StatusOK, orders)
+}
 
-	js, err := json.Marshal(orders)
-	if err != nil {
-		http.Error(w, "Error during building response json", http.StatusInternalServerError)
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(js)
+	order, err := h.Service.GetOrder(ctx, sessionModel.Login, chi.URLParam(r, "number"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, order)
 }
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TotalCountHeader = "X-Total-Count"
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// parseListQuery reads cursor, limit, sort, from, to and (when allowedStatuses
+// is not nil) status query parameters of list endpoints.
+func parseListQuery(r *http.Request, allowedStatuses []string) (entities.ListQuery, error) {
+	values := r.URL.Query()
+	query := entities.ListQuery{}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := core.DecodeCursor(cursor)
+		if err != nil {
+			return query, er.NewFieldError("query.cursor", "is malformed")
+		}
+		query.After = after
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, er.NewFieldError("query.limit", "must be a positive integer")
+		}
+		query.Limit = n
+	}
+
+	switch sort := values.Get("sort"); sort {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, er.NewFieldError("query.sort", "must be one of [asc desc]")
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		v := values.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, er.NewFieldError("query."+bound.name, "must be an RFC 3339 date-time")
+		}
+		*bound.dst = &t
+	}
+
+	if allowedStatuses != nil {
+		for _, v := range values["status"] {
+			for _, status := range strings.Split(v, ",") {
+				status = strings.ToUpper(strings.TrimSpace(status))
+				if !contains(allowedStatuses, status) {
+					return query, er.NewFieldError("query.status", fmt.Sprintf("must be one of %v", allowedStatuses))
+				}
+				query.Statuses = append(query.Statuses, status)
+			}
+		}
+	}
+
+	return query, nil
+}
+
+func writePageHeaders(w http.ResponseWriter, page *entities.PageInfo) {
+	w.Header().Set(TotalCountHeader, strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set(NextCursorHeader, page.NextCursor)
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"net/http"
+)
+
+// PartnerHandler serves partners authenticated by client certificate.
+type PartnerHandler struct {
+	Service *core.OrderService
+}
+
+func (h *PartnerHandler) GetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	order, err := h.Service.GetOrderStatus(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		writeError(w, r.Context(), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
@@ -2,13 +2,11 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"github.com/xbreathoflife/gophermart/internal/app/auth"
 	"github.com/xbreathoflife/gophermart/internal/app/core"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
 	er "github.com/xbreathoflife/gophermart/internal/app/errors"
-	"io"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
 	"net/http"
 )
 
@@ -18,17 +16,17 @@ type UserHandler struct {
 
 
 func checkAuth(service *core.UserService, w http.ResponseWriter, ctx context.Context) *entities.UserSessionModel {
+	if sessionModel, ok := ctx.Value(auth.UserCtxKey).(*entities.UserSessionModel); ok {
+		logging.SetLogin(ctx, sessionModel.Login)
+		return sessionModel
+	}
 	session := ctx.Value(auth.CtxKey).(string)
 	sessionModel, err := service.GetUserBySession(ctx, session)
 	if err != nil {
-		var ce *er.WrongDataError
-		if errors.As(err, &ce) {
-			http.Error(w, "Wrong username or password", http.StatusUnauthorized)
-			return nil
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return nil
 	}
+	logging.SetLogin(ctx, sessionModel.Login)
 	return sessionModel
 }
 
@@ -39,36 +37,26 @@ func (h *UserHandler) processCookie(w http.ResponseWriter, r *http.Request) (*ht
 	uuid := core.GenerateUUID()
 	encryptedUUID, err := core.Encrypt(uuid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r.Context(), err)
 		return nil, nil
 	}
 	return &http.Cookie{Name: auth.CookieName, Value: encryptedUUID}, &uuid
 }
 
 func (h *UserHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	ctx := r.Context()
 	user := entities.LoginRequest{}
-	if err := json.Unmarshal(b, &user); err != nil {
-		http.Error(w, "Error during parsing request json", http.StatusBadRequest)
+	if err := readJSON(r, &user); err != nil {
+		writeError(w, ctx, err)
 		return
 	}
 	if user.Login == "" || user.Password == "" {
-		http.Error(w, "Password or login empty", http.StatusBadRequest)
+		writeError(w, ctx, er.NewValidationError("Password or login empty"))
 		return
 	}
-	ctx := r.Context()
-	err = h.Service.CheckUserExists(ctx, user)
+	err := h.Service.CheckUserExists(ctx, user)
 	if err != nil {
-		var de *er.DuplicateError
-		if errors.As(err, &de) {
-			http.Error(w, "Username is taken", http.StatusConflict)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 
@@ -79,7 +67,7 @@ func (h *UserHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	err = h.Service.InsertNewUser(ctx, entities.UserModel{Login: user.Login, PasswordHash: user.Password, Session: *uuid})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 	http.SetCookie(w, newCookie)
@@ -87,29 +75,19 @@ func (h *UserHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 
 func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	ctx := r.Context()
 	user := entities.LoginRequest{}
-	if err := json.Unmarshal(b, &user); err != nil {
-		http.Error(w, "Error during parsing request json", http.StatusBadRequest)
+	if err := readJSON(r, &user); err != nil {
+		writeError(w, ctx, err)
 		return
 	}
 	if user.Login == "" || user.Password == "" {
-		http.Error(w, "Password or login empty", http.StatusBadRequest)
+		writeError(w, ctx, er.NewValidationError("Password or login empty"))
 		return
 	}
-	ctx := r.Context()
-	err = h.Service.CheckUserCredentials(ctx, user)
+	err := h.Service.CheckUserCredentials(ctx, user)
 	if err != nil {
-		var ce *er.WrongDataError
-		if errors.As(err, &ce) {
-			http.Error(w, "Wrong username or password", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 
@@ -120,7 +98,7 @@ func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	err = h.Service.UpdateUserSession(ctx, entities.UserSessionModel{Login: user.Login, Session: *uuid})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, ctx, err)
 		return
 	}
 	http.SetCookie(w, newCookie)
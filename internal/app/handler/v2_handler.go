@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"net/http"
+)
+
+// V2Handler serves /api/v2. Unlike v1 it always responds with a JSON body,
+// embeds the pagination of lists and exposes amounts as Money.
+type V2Handler struct {
+	OrderService   *core.OrderService
+	BalanceService *core.BalanceService
+	UserService    *core.UserService
+}
+
+func (h *V2Handler) PostOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	req := entities.OrderRequestV2{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	if req.Number == "" {
+		writeError(w, ctx, er.NewFieldError("body.number", "is required"))
+		return
+	}
+
+	created, err := h.OrderService.CreateNewOrder(ctx, sessionModel.Login, req.Number)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	order, err := h.OrderService.GetOrder(ctx, sessionModel.Login, req.Number)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusAccepted
+	}
+	writeJSON(w, statusCode, orderV2(order.OrderResponse))
+}
+
+func (h *V2Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	query, err := parseListQuery(r, core.OrderStatuses)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	orders, page, err := h.OrderService.GetOrdersForUser(ctx, sessionModel.Login, query)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	data := make([]entities.OrderV2, 0, len(orders))
+	for _, o := range orders {
+		data = append(data, orderV2(o))
+	}
+	writeJSON(w, http.StatusOK, pageV2(data, page))
+}
+
+func (h *V2Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	order, err := h.OrderService.GetOrder(ctx, sessionModel.Login, chi.URLParam(r, "number"))
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	detail := entities.OrderDetailV2{
+		OrderV2: orderV2(order.OrderResponse),
+		History: make([]entities.OrderStatusHistoryV2, 0, len(order.History)),
+	}
+	for _, entry := range order.History {
+		detail.History = append(detail.History, entities.OrderStatusHistoryV2{
+			Status:    entry.Status,
+			Accrual:   core.NewMoneyIfSet(entry.Accrual),
+			ChangedAt: entry.ChangedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func (h *V2Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	err := h.OrderService.CancelOrder(ctx, sessionModel.Login, chi.URLParam(r, "number"))
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *V2Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	balance, err := h.BalanceService.GetUsersBalance(ctx, sessionModel.Login)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entities.BalanceV2{
+		Current:   core.NewMoney(balance.Balance),
+		Withdrawn: core.NewMoney(balance.Spent),
+	})
+}
+
+func (h *V2Handler) PostWithdrawal(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	req := entities.WithdrawRequestV2{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	sum, err := core.Points("body.sum", req.Sum)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	withdrawal, err := h.BalanceService.ProcessBalanceWithdraw(ctx, sessionModel.Login,
+		entities.BalanceWithdrawRequest{Order: req.Order, Sum: sum})
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, withdrawalV2(*withdrawal))
+}
+
+func (h *V2Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	query, err := parseListQuery(r, nil)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	withdrawals, page, err := h.BalanceService.GetWithdrawalsForUser(ctx, sessionModel.Login, query)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	data := make([]entities.WithdrawalV2, 0, len(withdrawals))
+	for _, bw := range withdrawals {
+		data = append(data, withdrawalV2(bw))
+	}
+	writeJSON(w, http.StatusOK, pageV2(data, page))
+}
+
+func orderV2(o entities.OrderResponse) entities.OrderV2 {
+	return entities.OrderV2{
+		Number:     o.OrderNum,
+		Status:     o.Status,
+		Accrual:    core.NewMoneyIfSet(o.Accrual),
+		UploadedAt: o.UploadedAt,
+	}
+}
+
+func withdrawalV2(bw entities.BalanceWithdrawalsResponse) entities.WithdrawalV2 {
+	return entities.WithdrawalV2{
+		Order:       bw.OrderNum,
+		Sum:         core.NewMoney(bw.Sum),
+		ProcessedAt: bw.ProcessedAt,
+	}
+}
+
+func pageV2(data interface{}, page *entities.PageInfo) entities.PageV2 {
+	return entities.PageV2{
+		Data: data,
+		Pagination: entities.PaginationV2{
+			Total:      page.Total,
+			Limit:      page.Limit,
+			NextCursor: page.NextCursor,
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+type WebhookHandler struct {
+	Service     *core.WebhookService
+	UserService *core.UserService
+}
+
+func (h *WebhookHandler) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	req := entities.WebhookRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+
+	webhook, err := h.Service.CreateWebhook(ctx, sessionModel.Login, req)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+
+	webhooks, err := h.Service.GetWebhooksForUser(ctx, sessionModel.Login)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteWebhook(ctx, sessionModel.Login, id)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.Service.GetDeliveries(ctx, sessionModel.Login, id)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) PostTestDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionModel := checkAuth(h.UserService, w, ctx)
+	if sessionModel == nil {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.Service.SendTestEvent(ctx, sessionModel.Login, id)
+	if err != nil {
+		writeError(w, ctx, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r.Context(), er.NewFieldError("path.id", "must be an integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+// readJSON decodes the request body into v.
+func readJSON(r *http.Request, v interface{}) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return er.NewValidationError("Error during parsing request json")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error during building response json", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(js)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
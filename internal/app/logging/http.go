@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"time"
+)
+
+// Middleware logs every request when it is served and makes the request id
+// available to the log entries written while serving it. It must run after
+// middleware.RequestID.
+func Middleware(log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx := WithRequest(r.Context(), middleware.GetReqID(r.Context()))
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := zapcore.InfoLevel
+			if status >= http.StatusInternalServerError {
+				level = zapcore.ErrorLevel
+			}
+			entry := For(ctx, log).Check(level, "request served")
+			if entry == nil {
+				return
+			}
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Int("bytes", ww.BytesWritten()),
+				zap.Duration("duration", time.Since(start)),
+				zap.String("remote_addr", r.RemoteAddr),
+			}
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				fields = append(fields, zap.String("route", rctx.RoutePattern()))
+			}
+			if err := requestError(ctx); err != nil {
+				fields = append(fields, zap.Error(err))
+			}
+			if log.Core().Enabled(zapcore.DebugLevel) {
+				fields = append(fields, Headers("headers", r.Header))
+			}
+			entry.Write(fields...)
+		})
+	}
+}
+
+// Recoverer logs panics of the handlers with their stack and responds with
+// 500 Internal Server Error.
+func Recoverer(log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					if rvr == http.ErrAbortHandler {
+						panic(rvr)
+					}
+					For(r.Context(), log).Error("handler panicked", zap.Any("panic", rvr), zap.Stack("stack"))
+					er.WriteHTTP(w, http.StatusInternalServerError, er.CodeInternal, "Internal server error")
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
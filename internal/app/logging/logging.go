@@ -0,0 +1,92 @@
+// Package logging builds the JSON logger of the service and attaches the
+// request id, login and trace id of the current request to log entries.
+package logging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sync"
+)
+
+// New builds a JSON logger writing entries of the level ("debug", "info",
+// "warn" or "error") and above to stderr.
+func New(level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.EncoderConfig.TimeKey = "time"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.DisableStacktrace = lvl > zapcore.DebugLevel
+	return cfg.Build()
+}
+
+type requestKey struct{}
+
+// request holds the fields of the request being served. The login is only
+// known once the session is checked, so it is set after the context is built.
+type request struct {
+	mu        sync.Mutex
+	requestID string
+	login     string
+	err       error
+}
+
+// WithRequest returns a context correlating log entries with the request id.
+func WithRequest(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{requestID: requestID})
+}
+
+// SetLogin attaches the login of the authenticated user to the request of ctx.
+func SetLogin(ctx context.Context, login string) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		req.login = login
+		req.mu.Unlock()
+	}
+}
+
+// SetError attaches the error which failed the request of ctx, for the access
+// log to show what clients are not told.
+func SetError(ctx context.Context, err error) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		req.err = err
+		req.mu.Unlock()
+	}
+}
+
+// Fields returns the correlation fields of ctx: request id, login and trace id.
+func Fields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		fields = append(fields, zap.String("request_id", req.requestID))
+		if req.login != "" {
+			fields = append(fields, zap.String("login", req.login))
+		}
+		req.mu.Unlock()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+	return fields
+}
+
+func requestError(ctx context.Context) error {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		return req.err
+	}
+	return nil
+}
+
+// For returns log with the correlation fields of ctx.
+func For(ctx context.Context, log *zap.Logger) *zap.Logger {
+	return log.With(Fields(ctx)...)
+}
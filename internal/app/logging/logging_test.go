@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(core)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware(log))
+	r.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
+		SetLogin(r.Context(), "user")
+		For(r.Context(), log).Info("balance read")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+	req.Header.Set("Cookie", "authorization=secret-session")
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		fields := e.ContextMap()
+		assert.Equal(t, "req-1", fields["request_id"], e.Message)
+		assert.Equal(t, "user", fields["login"], e.Message)
+	}
+	access := entries[1].ContextMap()
+	assert.Equal(t, "/api/user/balance", access["route"])
+	assert.EqualValues(t, http.StatusOK, access["status"])
+	assert.Equal(t, redacted, access["headers"].(map[string]string)["Cookie"])
+}
+
+func TestRecoverer(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	h := Recoverer(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"internal_error","message":"Internal server error"}}`, rec.Body.String())
+	require.Equal(t, 1, logs.FilterMessage("handler panicked").Len())
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "password redacted",
+			body: `{"login":"user","password":"qwerty"}`,
+			want: `{"login":"user","password":"[REDACTED]"}`,
+		},
+		{
+			name: "nested",
+			body: `[{"order":"1","user":{"Password":"qwerty"}}]`,
+			want: `[{"order":"1","user":{"Password":"[REDACTED]"}}]`,
+		},
+		{
+			name: "not json",
+			body: "<html>bad gateway</html>",
+			want: "<html>bad gateway</html>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Body("body", []byte(tt.body)).String)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("verbose")
+	assert.Error(t, err)
+	log, err := New("warn")
+	require.NoError(t, err)
+	assert.False(t, log.Core().Enabled(zapcore.InfoLevel))
+}
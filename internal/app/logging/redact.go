@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// maxBodyLength bounds the size of bodies written to the log.
+	maxBodyLength = 1024
+)
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// Headers is a field with the headers, with credentials and cookies redacted.
+func Headers(key string, h http.Header) zap.Field {
+	safe := make(map[string]string, len(h))
+	for name, values := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			safe[name] = redacted
+		} else {
+			safe[name] = strings.Join(values, ", ")
+		}
+	}
+	return zap.Any(key, safe)
+}
+
+// Body is a field with the body as text. Passwords in JSON bodies are
+// redacted and long bodies are truncated.
+func Body(key string, b []byte) zap.Field {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err == nil {
+		if redacted, err := json.Marshal(redact(v)); err == nil {
+			b = redacted
+		}
+	}
+	s := string(b)
+	if len(s) > maxBodyLength {
+		s = s[:maxBodyLength] + "..."
+	}
+	return zap.String(key, s)
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if isSensitive(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return v
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "secret") || key == "cookie" || key == "session"
+}
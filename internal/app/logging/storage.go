@@ -0,0 +1,23 @@
+package logging
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ObserveStorage returns a storage observer logging failed calls, and every
+// call at debug level.
+func ObserveStorage(log *zap.Logger) func(ctx context.Context, method string) (context.Context, func(err error)) {
+	return func(ctx context.Context, method string) (context.Context, func(err error)) {
+		start := time.Now()
+		return ctx, func(err error) {
+			if err != nil {
+				For(ctx, log).Error("storage call failed", zap.String("method", method),
+					zap.Duration("duration", time.Since(start)), zap.Error(err))
+			} else if ce := log.Check(zap.DebugLevel, "storage call"); ce != nil {
+				ce.Write(append(Fields(ctx), zap.String("method", method), zap.Duration("duration", time.Since(start)))...)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package metrics exposes Prometheus metrics of the HTTP server, the accrual
+// worker, the storages and business events.
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "gophermart"
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and response status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	AccrualQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_queue_depth",
+		Help:      "Orders waiting to be polled from the accrual system.",
+	})
+
+	AccrualRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_requests_total",
+		Help:      "Calls to the accrual system by response status code, \"error\" if there was no response.",
+	}, []string{"code"})
+
+	PointsAccrued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_accrued_total",
+		Help:      "Points credited to users for processed orders.",
+	})
+
+	PointsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Points withdrawn by users.",
+	})
+
+	DBCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_call_duration_seconds",
+		Help:      "Latency of storage calls by method.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method", "result"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the rate limiter by route group.",
+	}, []string{"group"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		AccrualQueueDepth,
+		AccrualRequests,
+		PointsAccrued,
+		PointsWithdrawn,
+		DBCallDuration,
+		RateLimited,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveDB is a storage observer recording the latency of every call.
+func ObserveDB(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		DBCallDuration.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+	}
+}
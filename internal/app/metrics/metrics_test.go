@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, num := range []string{"2377225624", "12345678903"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/user/orders/"+num, nil))
+	}
+
+	count := testutil.CollectAndCount(HTTPRequestDuration, "gophermart_http_request_duration_seconds")
+	assert.Equal(t, 1, count, "order numbers must not be labels")
+	observer, err := HTTPRequestDuration.GetMetricWithLabelValues(http.MethodGet, "/api/user/orders/{number}", "404")
+	require.NoError(t, err)
+	var m dto.Metric
+	require.NoError(t, observer.(prometheus.Histogram).Write(&m))
+	assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+}
+
+type orderCounter map[string]int
+
+func (c orderCounter) CountOrdersByStatus(_ context.Context) (map[string]int, error) {
+	return c, nil
+}
+
+func TestOrdersCollector(t *testing.T) {
+	collector := ordersCollector{counter: orderCounter{"NEW": 2, "PROCESSED": 1}}
+
+	expected := `
+# HELP gophermart_orders Orders by status.
+# TYPE gophermart_orders gauge
+gophermart_orders{status="NEW"} 2
+gophermart_orders{status="PROCESSED"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const ordersScrapeTimeout = 5 * time.Second
+
+// OrderCounter counts orders in every status.
+type OrderCounter interface {
+	CountOrdersByStatus(ctx context.Context) (map[string]int, error)
+}
+
+var ordersByStatusDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "orders"),
+	"Orders by status.", []string{"status"}, nil)
+
+// ordersCollector reads the order counts from the storage on scrape, so that
+// they are right across restarts and instances.
+type ordersCollector struct {
+	counter OrderCounter
+}
+
+// RegisterOrders exposes the number of orders by status.
+func RegisterOrders(counter OrderCounter) error {
+	return Registry.Register(ordersCollector{counter: counter})
+}
+
+func (c ordersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ordersByStatusDesc
+}
+
+func (c ordersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), ordersScrapeTimeout)
+	defer cancel()
+	counts, err := c.counter.CountOrdersByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(ordersByStatusDesc, err)
+		return
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(ordersByStatusDesc, prometheus.GaugeValue, float64(n), status)
+	}
+}
@@ -0,0 +1,114 @@
+// Package openapi describes the API as an OpenAPI 3.0 document and validates
+// requests against it.
+package openapi
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem maps lower case HTTP methods to their operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Schema struct {
+	Ref              string             `json:"$ref,omitempty"`
+	Type             string             `json:"type,omitempty"`
+	Format           string             `json:"format,omitempty"`
+	Description      string             `json:"description,omitempty"`
+	Enum             []string           `json:"enum,omitempty"`
+	Pattern          string             `json:"pattern,omitempty"`
+	MinLength        *int               `json:"minLength,omitempty"`
+	MaxLength        *int               `json:"maxLength,omitempty"`
+	Minimum          *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum bool               `json:"exclusiveMinimum,omitempty"`
+	Required         []string           `json:"required,omitempty"`
+	Properties       map[string]*Schema `json:"properties,omitempty"`
+	Items            *Schema            `json:"items,omitempty"`
+	AllOf            []*Schema          `json:"allOf,omitempty"`
+	Nullable         bool               `json:"nullable,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Ref refers to a schema of the components.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Int and Float return pointers for the optional schema constraints.
+func Int(v int) *int {
+	return &v
+}
+
+func Float(v float64) *float64 {
+	return &v
+}
+
+// Operation returns the operation of the path and method, nil if there is none.
+func (d *Document) Operation(path string, method string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+	return (*item)[method]
+}
+
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[s.Ref[len("#/components/schemas/"):]]
+	}
+	return s
+}
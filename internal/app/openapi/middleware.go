@@ -0,0 +1,148 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type Validator struct {
+	doc *Document
+}
+
+func NewValidator(doc *Document) *Validator {
+	return &Validator{doc: doc}
+}
+
+// Handler rejects requests which do not match the operation of their route
+// before they reach the handlers. It must be used on routes, not on the mux,
+// as it needs the matched route pattern.
+func (v *Validator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op := v.doc.Operation(rctx.RoutePattern(), strings.ToLower(r.Method))
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		errs := v.validateParams(r, op)
+		if op.RequestBody != nil {
+			body, status, bodyErrs := v.validateBody(r, op.RequestBody)
+			if status != http.StatusOK {
+				writeError(w, status, bodyErrs)
+				return
+			}
+			errs = append(errs, bodyErrs...)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if len(errs) > 0 {
+			writeError(w, http.StatusBadRequest, errs)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *Validator) validateParams(r *http.Request, op *Operation) []entities.FieldError {
+	var errs []entities.FieldError
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			values = []string{chi.URLParam(r, p.Name)}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		}
+		field := p.In + "." + p.Name
+		if len(values) == 0 || len(values) == 1 && values[0] == "" {
+			if p.Required {
+				errs = append(errs, entities.FieldError{Field: field, Message: "is required"})
+			}
+			continue
+		}
+		for _, value := range values {
+			errs = append(errs, v.doc.validateParam(p.Schema, value, field)...)
+		}
+	}
+	return errs
+}
+
+// errBodyTooLarge is the message of the error of http.MaxBytesReader.
+const errBodyTooLarge = "http: request body too large"
+
+// validateBody reads the body and validates it, returning it to be read again
+// by the handler.
+func (v *Validator) validateBody(r *http.Request, rb *RequestBody) ([]byte, int, []entities.FieldError) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		// http.MaxBytesReader has no error type before Go 1.19, only the message
+		if err.Error() == errBodyTooLarge {
+			return nil, http.StatusRequestEntityTooLarge, []entities.FieldError{{Field: "body", Message: "is too large"}}
+		}
+		return nil, http.StatusBadRequest, []entities.FieldError{{Field: "body", Message: err.Error()}}
+	}
+
+	mediaType := ""
+	for t := range rb.Content {
+		mediaType = t
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		// clients which do not send a content type get the documented one
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if _, ok := rb.Content[mediaType]; err != nil || !ok {
+			return nil, http.StatusUnsupportedMediaType, []entities.FieldError{{Field: "header.Content-Type", Message: "must be one of " + strings.Join(mediaTypes(rb), ", ")}}
+		}
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return body, http.StatusOK, []entities.FieldError{{Field: "body", Message: "is required"}}
+		}
+		return body, http.StatusOK, nil
+	}
+	schema := rb.Content[mediaType].Schema
+	if mediaType != "application/json" {
+		return body, http.StatusOK, v.doc.validateParam(schema, strings.TrimSpace(string(body)), "body")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return body, http.StatusOK, []entities.FieldError{{Field: "body", Message: "must be valid JSON"}}
+	}
+	return body, http.StatusOK, v.doc.validate(schema, value, "body")
+}
+
+func mediaTypes(rb *RequestBody) []string {
+	var types []string
+	for t := range rb.Content {
+		types = append(types, t)
+	}
+	return types
+}
+
+func writeError(w http.ResponseWriter, status int, errs []entities.FieldError) {
+	message := "Request is invalid"
+	if status == http.StatusUnsupportedMediaType {
+		message = "Unsupported content type"
+	}
+	js, _ := json.Marshal(entities.ErrorResponse{Error: entities.ErrorBody{Code: er.CodeInvalidRequest, Message: message, Details: errs}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,161 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testDocument() *Document {
+	return &Document{
+		OpenAPI: Version,
+		Paths: map[string]*PathItem{
+			"/items/{id}": {
+				"post": {
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}},
+						{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: Float(1)}},
+						{Name: "sort", In: "query", Schema: &Schema{Type: "string", Enum: []string{"asc", "desc"}}},
+						{Name: "X-Key", In: "header", Schema: &Schema{Type: "string", MaxLength: Int(3)}},
+					},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"application/json": {Schema: Ref("Item")}},
+					},
+				},
+			},
+			"/numbers": {
+				"post": {
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string", Pattern: "^[0-9]+$"}}},
+					},
+				},
+			},
+		},
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"Item": {
+					Type:     "object",
+					Required: []string{"name", "price"},
+					Properties: map[string]*Schema{
+						"name":  {Type: "string", MinLength: Int(1)},
+						"price": {Type: "number", Minimum: Float(0), ExclusiveMinimum: true},
+						"at":    {Type: "string", Format: "date-time"},
+						"tags":  {Type: "array", Items: &Schema{Type: "string", Enum: []string{"a", "b"}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestValidator_Handler(t *testing.T) {
+	validator := NewValidator(testDocument())
+	var received []byte
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(validator.Handler)
+		r.Post("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			received, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		})
+		r.Post("/numbers", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		r.Post("/undocumented", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	})
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		header      string
+		body        string
+		maxSize     int64
+		status      int
+		details     []entities.FieldError
+	}{
+		{name: "valid", target: "/items/1?limit=5&sort=desc", contentType: "application/json", header: "abc",
+			body: `{"name":"pen","price":1.5,"at":"2022-04-30T20:00:00+03:00","tags":["a"]}`, status: http.StatusOK},
+		{name: "no content type", target: "/items/1", body: `{"name":"pen","price":1}`, status: http.StatusOK},
+		{name: "bad path param", target: "/items/one", body: `{"name":"pen","price":1}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{{Field: "path.id", Message: "must be an integer"}}},
+		{name: "bad query params", target: "/items/1?limit=0&sort=up", body: `{"name":"pen","price":1}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{
+				{Field: "query.limit", Message: "must be at least 1"},
+				{Field: "query.sort", Message: "must be one of [asc desc]"},
+			}},
+		{name: "bad header", target: "/items/1", header: "abcd", body: `{"name":"pen","price":1}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{{Field: "header.X-Key", Message: "must be at most 3 characters long"}}},
+		{name: "bad body", target: "/items/1", body: `{"name":"","price":0,"at":"today","tags":["c"]}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{
+				{Field: "body.at", Message: "must be an RFC 3339 date-time"},
+				{Field: "body.name", Message: "must not be empty"},
+				{Field: "body.price", Message: "must be greater than 0"},
+				{Field: "body.tags[0]", Message: "must be one of [a b]"},
+			}},
+		{name: "missing fields", target: "/items/1", body: `{}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{
+				{Field: "body.name", Message: "is required"},
+				{Field: "body.price", Message: "is required"},
+			}},
+		{name: "wrong types", target: "/items/1", body: `{"name":1,"price":"1"}`, status: http.StatusBadRequest,
+			details: []entities.FieldError{
+				{Field: "body.name", Message: "must be a string"},
+				{Field: "body.price", Message: "must be a number"},
+			}},
+		{name: "not json", target: "/items/1", body: `{`, status: http.StatusBadRequest,
+			details: []entities.FieldError{{Field: "body", Message: "must be valid JSON"}}},
+		{name: "empty body", target: "/items/1", status: http.StatusBadRequest,
+			details: []entities.FieldError{{Field: "body", Message: "is required"}}},
+		{name: "unsupported content type", target: "/items/1", contentType: "text/plain", body: `{"name":"pen","price":1}`,
+			status:  http.StatusUnsupportedMediaType,
+			details: []entities.FieldError{{Field: "header.Content-Type", Message: "must be one of application/json"}}},
+		{name: "text body", target: "/numbers", contentType: "text/plain", body: "12345", status: http.StatusOK},
+		{name: "bad text body", target: "/numbers", body: "12a", status: http.StatusBadRequest,
+			details: []entities.FieldError{{Field: "body", Message: "must match ^[0-9]+$"}}},
+		{name: "undocumented", target: "/undocumented", body: "anything", status: http.StatusOK},
+		{name: "too large", target: "/numbers", body: "123456", maxSize: 3, status: http.StatusRequestEntityTooLarge,
+			details: []entities.FieldError{{Field: "body", Message: "is too large"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest(http.MethodPost, tt.target, bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			if tt.maxSize > 0 {
+				req.Body = http.MaxBytesReader(w, req.Body, tt.maxSize)
+			}
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.status != http.StatusOK {
+				var response entities.ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, er.CodeInvalidRequest, response.Error.Code)
+				assert.Equal(t, tt.details, response.Error.Details)
+				return
+			}
+			if tt.target != "/numbers" && tt.target != "/undocumented" {
+				assert.Equal(t, tt.body, string(received), "the handler must read the body again")
+			}
+		})
+	}
+}
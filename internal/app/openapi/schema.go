@@ -0,0 +1,186 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var patterns sync.Map
+
+func compiled(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
+
+// validate checks a value decoded from JSON with json.Number numbers.
+func (d *Document) validate(s *Schema, v interface{}, field string) []entities.FieldError {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+	if v == nil {
+		if s.Nullable {
+			return nil
+		}
+		return []entities.FieldError{{Field: field, Message: "must not be null"}}
+	}
+	if len(s.AllOf) > 0 {
+		var errs []entities.FieldError
+		for _, sub := range s.AllOf {
+			errs = append(errs, d.validate(sub, v, field)...)
+		}
+		return errs
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []entities.FieldError{{Field: field, Message: "must be an object"}}
+		}
+		var errs []entities.FieldError
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, entities.FieldError{Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if value, ok := obj[name]; ok {
+				errs = append(errs, d.validate(s.Properties[name], value, join(field, name))...)
+			}
+		}
+		return errs
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []entities.FieldError{{Field: field, Message: "must be an array"}}
+		}
+		var errs []entities.FieldError
+		for i, item := range items {
+			errs = append(errs, d.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return errs
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []entities.FieldError{{Field: field, Message: "must be a string"}}
+		}
+		return validateString(s, str, field)
+	case "number", "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return []entities.FieldError{{Field: field, Message: "must be a " + s.Type}}
+		}
+		return validateNumber(s, string(n), field)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []entities.FieldError{{Field: field, Message: "must be a boolean"}}
+		}
+	}
+	return nil
+}
+
+// validateParam checks a parameter, which is always a string on the wire.
+func (d *Document) validateParam(s *Schema, v string, field string) []entities.FieldError {
+	s = d.resolve(s)
+	switch s.Type {
+	case "number", "integer":
+		return validateNumber(s, v, field)
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return []entities.FieldError{{Field: field, Message: "must be a boolean"}}
+		}
+		return nil
+	default:
+		return validateString(s, v, field)
+	}
+}
+
+func validateString(s *Schema, v string, field string) []entities.FieldError {
+	length := utf8.RuneCountInString(v)
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		if *s.MinLength == 1 {
+			return []entities.FieldError{{Field: field, Message: "must not be empty"}}
+		}
+		return []entities.FieldError{{Field: field, Message: fmt.Sprintf("must be at least %d characters long", *s.MinLength)}}
+	case s.MaxLength != nil && length > *s.MaxLength:
+		return []entities.FieldError{{Field: field, Message: fmt.Sprintf("must be at most %d characters long", *s.MaxLength)}}
+	case s.Pattern != "" && !compiled(s.Pattern).MatchString(v):
+		return []entities.FieldError{{Field: field, Message: "must match " + s.Pattern}}
+	case len(s.Enum) > 0 && !contains(s.Enum, v):
+		return []entities.FieldError{{Field: field, Message: fmt.Sprintf("must be one of %v", s.Enum)}}
+	}
+
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return []entities.FieldError{{Field: field, Message: "must be an RFC 3339 date-time"}}
+		}
+	case "uri":
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() {
+			return []entities.FieldError{{Field: field, Message: "must be an absolute URI"}}
+		}
+	}
+	return nil
+}
+
+func validateNumber(s *Schema, v string, field string) []entities.FieldError {
+	var n float64
+	if s.Type == "integer" {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return []entities.FieldError{{Field: field, Message: "must be an integer"}}
+		}
+		n = float64(i)
+	} else {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return []entities.FieldError{{Field: field, Message: "must be a number"}}
+		}
+		n = f
+	}
+
+	if s.Minimum != nil {
+		if s.ExclusiveMinimum && n <= *s.Minimum {
+			return []entities.FieldError{{Field: field, Message: fmt.Sprintf("must be greater than %v", *s.Minimum)}}
+		}
+		if n < *s.Minimum {
+			return []entities.FieldError{{Field: field, Message: fmt.Sprintf("must be at least %v", *s.Minimum)}}
+		}
+	}
+	return nil
+}
+
+func join(field string, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
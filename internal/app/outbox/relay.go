@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const (
+	batchSize = 100
+	// claimTimeout hides claimed events from other relays, an event is
+	// published again if its relay died before recording the outcome.
+	claimTimeout       = 5 * time.Minute
+	defaultMaxAttempts = 20
+	baseBackoff        = time.Second
+	maxBackoff         = time.Hour
+	defaultRetention   = 7 * 24 * time.Hour
+	// pruneInterval is how often delivered events are pruned, the relay
+	// polls far more often than that.
+	pruneInterval = time.Hour
+)
+
+// Relay polls the outbox table and publishes pending events to all sinks. An
+// event is marked delivered only when every sink accepted it, so delivery is
+// at-least-once: a sink may see an event again after another sink failed.
+// Failed events are retried with exponential backoff, after MaxAttempts they
+// stay in the table as dead letters. Delivered events are deleted once they
+// are older than Retention.
+type Relay struct {
+	Storage     storage.OutboxStorage
+	Sinks       []Sink
+	Interval    time.Duration
+	MaxAttempts int
+	Retention   time.Duration
+	Log         *zap.Logger
+}
+
+// NewRelay returns a relay polling every interval, maxAttempts and retention
+// 0 mean the defaults.
+func NewRelay(outboxStorage storage.OutboxStorage, sinks []Sink, interval time.Duration, maxAttempts int, retention time.Duration, log *zap.Logger) *Relay {
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if retention == 0 {
+		retention = defaultRetention
+	}
+	return &Relay{Storage: outboxStorage, Sinks: sinks, Interval: interval, MaxAttempts: maxAttempts,
+		Retention: retention, Log: log}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		if _, err := r.RelayOnce(ctx); err != nil {
+			r.Log.Error("failed to relay outbox events", zap.Error(err))
+		}
+		if time.Since(pruned) >= pruneInterval {
+			deleted, err := r.Prune(ctx)
+			if err != nil {
+				r.Log.Error("failed to prune outbox events", zap.Error(err))
+			} else {
+				r.Log.Debug("pruned outbox events", zap.Int64("count", deleted))
+			}
+			pruned = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes one batch of due events and returns how many were delivered.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	pending, err := r.Storage.ClaimOutboxEvents(ctx, now, now.Add(claimTimeout), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, event := range pending {
+		var failures []string
+		for _, sink := range r.Sinks {
+			if err := sink.Send(ctx, event); err != nil {
+				failures = append(failures, sink.Name()+": "+err.Error())
+			}
+		}
+
+		attempts := event.Attempts + 1
+		switch {
+		case len(failures) > 0 && attempts >= r.MaxAttempts:
+			r.Log.Error("giving up on outbox event", zap.Int64("id", event.ID), zap.String("type", event.EventType),
+				zap.Int("attempts", attempts), zap.Strings("failures", failures))
+			err = r.Storage.MarkOutboxEventDead(ctx, event.ID, strings.Join(failures, "; "), time.Now())
+		case len(failures) > 0:
+			err = r.Storage.MarkOutboxEventFailed(ctx, event.ID, strings.Join(failures, "; "),
+				time.Now().Add(retryBackoff(attempts)))
+		default:
+			err = r.Storage.MarkOutboxEventDelivered(ctx, event.ID, time.Now())
+			delivered++
+		}
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// Prune deletes the events delivered longer than Retention ago and returns
+// how many were deleted.
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	return r.Storage.DeleteDeliveredOutboxEvents(ctx, time.Now().Add(-r.Retention))
+}
+
+// retryBackoff returns the delay after the given number of failed attempts.
+func retryBackoff(attempts int) time.Duration {
+	backoff := baseBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/mocks"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type failingSink struct{}
+
+func (s *failingSink) Name() string {
+	return "failing"
+}
+
+func (s *failingSink) Send(_ context.Context, event entities.OutboxEventModel) error {
+	if event.ID == 2 {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	outboxRepo := mocks.NewMockOutboxStorage(mockCtrl)
+
+	createdAt := time.Date(2022, 4, 30, 20, 0, 0, 0, time.UTC)
+	outboxRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any(), batchSize).Return([]entities.OutboxEventModel{
+		{ID: 1, EventType: "balance.withdrawn", Login: "hello", Payload: `{"order":"2377225624","sum":500.5}`, CreatedAt: createdAt},
+		{ID: 2, EventType: "order.processed", Login: "hello", Payload: `{"number":"9278923470"}`, CreatedAt: createdAt},
+	}, nil)
+	outboxRepo.EXPECT().MarkOutboxEventDelivered(gomock.Any(), int64(1), gomock.Any())
+	outboxRepo.EXPECT().MarkOutboxEventFailed(gomock.Any(), int64(2), "failing: unavailable", gomock.Any())
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	relay := NewRelay(outboxRepo, []Sink{&FileSink{Path: path}, &failingSink{}}, time.Second, 0, 0, zap.NewNop())
+	delivered, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"id":1,"type":"balance.withdrawn","login":"hello","created_at":"2022-04-30T20:00:00Z","payload":{"order":"2377225624","sum":500.5}}`+"\n"+
+			`{"id":2,"type":"order.processed","login":"hello","created_at":"2022-04-30T20:00:00Z","payload":{"number":"9278923470"}}`+"\n",
+		string(b))
+}
+
+func TestRelay_DeadLetter(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	outboxRepo := mocks.NewMockOutboxStorage(mockCtrl)
+
+	outboxRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any(), batchSize).Return([]entities.OutboxEventModel{
+		{ID: 2, EventType: "order.processed", Login: "hello", Payload: `{}`, Attempts: 1},
+		{ID: 2, EventType: "order.processed", Login: "hello", Payload: `{}`, Attempts: 2},
+	}, nil)
+	before := time.Now()
+	outboxRepo.EXPECT().MarkOutboxEventFailed(gomock.Any(), int64(2), "failing: unavailable", gomock.Any()).
+		Do(func(_ context.Context, _ int64, _ string, nextAttemptAt time.Time) {
+			assert.True(t, nextAttemptAt.After(before.Add(baseBackoff)), "the retry is not delayed")
+		})
+	outboxRepo.EXPECT().MarkOutboxEventDead(gomock.Any(), int64(2), "failing: unavailable", gomock.Any())
+
+	relay := NewRelay(outboxRepo, []Sink{&failingSink{}}, time.Second, 3, 0, zap.NewNop())
+	delivered, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestRelay_Prune(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	outboxRepo := mocks.NewMockOutboxStorage(mockCtrl)
+
+	before := time.Now()
+	outboxRepo.EXPECT().DeleteDeliveredOutboxEvents(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, deliveredBefore time.Time) (int64, error) {
+			assert.WithinDuration(t, before.Add(-time.Hour), deliveredBefore, time.Second)
+			return 3, nil
+		})
+
+	relay := NewRelay(outboxRepo, nil, time.Second, 0, time.Hour, zap.NewNop())
+	deleted, err := relay.Prune(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	assert.Equal(t, defaultRetention, NewRelay(outboxRepo, nil, time.Second, 0, 0, zap.NewNop()).Retention)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryBackoff(1))
+	assert.Equal(t, 4*time.Second, retryBackoff(3))
+	assert.Equal(t, maxBackoff, retryBackoff(1000))
+}
+
+func TestParseSinks(t *testing.T) {
+	sinks, err := ParseSinks("log, file:/tmp/events.jsonl,http://localhost:9000/events", zap.NewNop())
+	require.NoError(t, err)
+	require.Len(t, sinks, 3)
+	assert.Equal(t, "log", sinks[0].Name())
+	assert.Equal(t, "file:/tmp/events.jsonl", sinks[1].Name())
+	assert.Equal(t, "http://localhost:9000/events", sinks[2].Name())
+
+	_, err = ParseSinks("kafka://localhost", zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a destination the relay publishes outbox events to.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event entities.OutboxEventModel) error
+}
+
+type message struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Login     string          `json:"login"`
+	CreatedAt string          `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func encode(event entities.OutboxEventModel) ([]byte, error) {
+	return json.Marshal(message{
+		ID:        event.ID,
+		Type:      event.EventType,
+		Login:     event.Login,
+		CreatedAt: event.CreatedAt.Format(time.RFC3339),
+		Payload:   json.RawMessage(event.Payload),
+	})
+}
+
+type LogSink struct {
+	Log *zap.Logger
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Send(_ context.Context, event entities.OutboxEventModel) error {
+	b, err := encode(event)
+	if err != nil {
+		return err
+	}
+	s.Log.Info("outbox event", zap.Any("event", json.RawMessage(b)))
+	return nil
+}
+
+// FileSink appends events to a file, one JSON document per line.
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSink) Name() string {
+	return "file:" + s.Path
+}
+
+func (s *FileSink) Send(_ context.Context, event entities.OutboxEventModel) error {
+	b, err := encode(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HTTPSink POSTs every event as JSON to the URL.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPSink) Name() string {
+	return s.URL
+}
+
+func (s *HTTPSink) Send(ctx context.Context, event entities.OutboxEventModel) error {
+	b, err := encode(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ParseSinks builds sinks from a comma separated list like
+// "log,file:/var/log/gophermart/events.jsonl,http://collector/events".
+func ParseSinks(spec string, log *zap.Logger) ([]Sink, error) {
+	var sinks []Sink
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "log":
+			sinks = append(sinks, &LogSink{Log: log})
+		case strings.HasPrefix(item, "file:"):
+			sinks = append(sinks, &FileSink{Path: strings.TrimPrefix(item, "file:")})
+		case strings.HasPrefix(item, "http://"), strings.HasPrefix(item, "https://"):
+			sinks = append(sinks, &HTTPSink{URL: item, Client: &http.Client{Timeout: 10 * time.Second}})
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", item)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,48 @@
+// Package ratelimit limits the rate of requests per client with fixed window
+// counters kept in a pluggable store.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Window, the zero Limit allows everything.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Window <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// ParseLimit parses limits like "60/1m"; an empty string or "0" is unlimited.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<window>", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 0 {
+		return Limit{}, fmt.Errorf("invalid number of requests in rate limit %q", s)
+	}
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return Limit{}, fmt.Errorf("invalid window in rate limit %q", s)
+	}
+	return Limit{Requests: requests, Window: window}, nil
+}
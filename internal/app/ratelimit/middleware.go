@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	LimitHeader     = "RateLimit-Limit"
+	RemainingHeader = "RateLimit-Remaining"
+	ResetHeader     = "RateLimit-Reset"
+	PolicyHeader    = "RateLimit-Policy"
+)
+
+// KeyFunc identifies the client of the request.
+type KeyFunc func(r *http.Request) string
+
+type Limiter struct {
+	Store Store
+	Key   KeyFunc
+	Log   *zap.Logger
+}
+
+func NewLimiter(store Store, key KeyFunc, log *zap.Logger) *Limiter {
+	return &Limiter{Store: store, Key: key, Log: log}
+}
+
+// Handler limits the requests of every client to the route group. Groups are
+// counted separately, so a route in two groups is limited by both, and the
+// headers describe the one closest to its limit.
+func (l *Limiter) Handler(group string, limit Limit) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Unlimited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count, resetAt, err := l.Store.Increment(r.Context(), group+":"+l.Key(r), limit.Window)
+			if err != nil {
+				// an unavailable store must not take the API down with it
+				logging.For(r.Context(), l.Log).Error("failed to count request for rate limit",
+					zap.String("group", group), zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			remaining := limit.Requests - count
+			if remaining < 0 {
+				remaining = 0
+			}
+			reset := int(math.Ceil(time.Until(resetAt).Seconds()))
+			if reset < 0 {
+				reset = 0
+			}
+			h := w.Header()
+			if prev, err := strconv.Atoi(h.Get(RemainingHeader)); err != nil || remaining <= prev {
+				h.Set(LimitHeader, strconv.Itoa(limit.Requests))
+				h.Set(RemainingHeader, strconv.Itoa(remaining))
+				h.Set(ResetHeader, strconv.Itoa(reset))
+				h.Set(PolicyHeader, strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(int(limit.Window.Seconds())))
+			}
+
+			if count > limit.Requests {
+				metrics.RateLimited.WithLabelValues(group).Inc()
+				h.Set("Retry-After", strconv.Itoa(reset))
+				er.WriteHTTP(w, http.StatusTooManyRequests, er.CodeRateLimited, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IPKey identifies clients by their address, which RealIP sets from the
+// headers of the trusted proxies.
+func IPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Limit
+		wantErr bool
+	}{
+		{spec: "", want: Limit{}},
+		{spec: "0", want: Limit{}},
+		{spec: "60/1m", want: Limit{Requests: 60, Window: time.Minute}},
+		{spec: " 5/10s ", want: Limit{Requests: 5, Window: 10 * time.Second}},
+		{spec: "60", wantErr: true},
+		{spec: "x/1m", wantErr: true},
+		{spec: "60/minute", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseLimit(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		count, resetAt, err := store.Increment(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, count)
+		assert.Equal(t, now.Add(time.Minute), resetAt)
+	}
+	count, _, _ := store.Increment(ctx, "b", time.Minute)
+	assert.Equal(t, 1, count, "keys are counted separately")
+
+	now = now.Add(time.Minute)
+	count, resetAt, _ := store.Increment(ctx, "a", time.Minute)
+	assert.Equal(t, 1, count, "a new window starts")
+	assert.Equal(t, now.Add(time.Minute), resetAt)
+	assert.Len(t, store.counters, 1, "expired counters are swept")
+}
+
+type failingStore struct{}
+
+func (failingStore) Increment(context.Context, string, time.Duration) (int, time.Time, error) {
+	return 0, time.Time{}, errors.New("connection refused")
+}
+
+func ok(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLimiter_Handler(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), IPKey, zap.NewNop())
+	h := limiter.Handler("orders", Limit{Requests: 2, Window: time.Minute})(http.HandlerFunc(ok))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(LimitHeader))
+	assert.Equal(t, "1", rec.Header().Get(RemainingHeader))
+	assert.Equal(t, "60", rec.Header().Get(ResetHeader))
+	assert.Equal(t, "2;w=60", rec.Header().Get(PolicyHeader))
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:4321").Code, "the port does not identify the client")
+	rec = serve("10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"rate_limited","message":"Too many requests"}}`, rec.Body.String())
+	assert.Equal(t, "0", rec.Header().Get(RemainingHeader))
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234").Code)
+}
+
+func TestLimiter_Handler_Groups(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), IPKey, zap.NewNop())
+	api := limiter.Handler("api", Limit{Requests: 100, Window: time.Minute})
+	orders := limiter.Handler("orders", Limit{Requests: 1, Window: time.Minute})
+	h := api(orders(http.HandlerFunc(ok)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/user/orders", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(LimitHeader), "the headers describe the tightest limit")
+	assert.Equal(t, "0", rec.Header().Get(RemainingHeader))
+}
+
+func TestLimiter_Handler_StoreFailure(t *testing.T) {
+	limiter := NewLimiter(failingStore{}, IPKey, zap.NewNop())
+	h := limiter.Handler("api", Limit{Requests: 1, Window: time.Minute})(http.HandlerFunc(ok))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/balance", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(LimitHeader))
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseNetworks([]string{"10.0.0.0/8", " 192.168.1.10"})
+	require.NoError(t, err)
+	var key string
+	h := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = IPKey(r)
+	}))
+
+	serve := func(remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return key
+	}
+
+	assert.Equal(t, "ip:203.0.113.7", serve("10.1.2.3:1234"))
+	assert.Equal(t, "ip:203.0.113.7", serve("192.168.1.10:1234"))
+	assert.Equal(t, "ip:192.168.1.11", serve("192.168.1.11:1234"), "untrusted clients can not pick their address")
+
+	serveForwarded := func(forwardedFor string) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+		req.RemoteAddr = "10.1.2.3:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return key
+	}
+	assert.Equal(t, "ip:203.0.113.7", serveForwarded("198.51.100.1, 203.0.113.7"), "the client can not pick the leftmost entry")
+	assert.Equal(t, "ip:203.0.113.7", serveForwarded("198.51.100.1, 203.0.113.7, 10.0.0.2"))
+	assert.Equal(t, "ip:10.0.0.1", serveForwarded("10.0.0.1, 10.0.0.2"))
+	assert.Equal(t, "ip:10.1.2.3", serveForwarded("203.0.113.7, proxy"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Real-IP", "203.0.113.8")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "ip:203.0.113.8", key)
+
+	_, err = ParseNetworks([]string{"proxy"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNetworks parses CIDRs and single addresses, like "10.0.0.0/8, 192.168.1.10".
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RealIP sets the client address for requests coming from the trusted proxies
+// only: anyone else could pick the address they are limited by. Every proxy
+// appends the address it got the request from to X-Forwarded-For, so the
+// client is the rightmost entry which is not a trusted proxy, the entries left
+// of it can be forged. Without X-Forwarded-For, X-Real-IP and then
+// True-Client-IP are used.
+func RealIP(trusted []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrusted(trusted, r.RemoteAddr) {
+				if ip := clientIP(trusted, r.Header); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(trusted []*net.IPNet, header http.Header) string {
+	if forwarded := header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		entries := strings.Split(strings.Join(forwarded, ","), ",")
+		var ip net.IP
+		for i := len(entries) - 1; i >= 0; i-- {
+			ip = net.ParseIP(strings.TrimSpace(entries[i]))
+			if ip == nil {
+				// nothing left of a malformed entry can be relied on
+				return ""
+			}
+			if !contains(trusted, ip) {
+				break
+			}
+		}
+		// the leftmost entry if the request went through trusted proxies only
+		return ip.String()
+	}
+	for _, name := range []string{"X-Real-IP", "True-Client-IP"} {
+		if ip := net.ParseIP(strings.TrimSpace(header.Get(name))); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func isTrusted(trusted []*net.IPNet, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return contains(trusted, ip)
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store counts the requests of a key in the current window. Sharing a store,
+// e.g. one backed by Redis INCR and EXPIRE, shares the limits between instances.
+type Store interface {
+	// Increment counts a request of the key and returns the number of requests
+	// in the current window and when the window ends.
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+type counter struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryStore keeps the counters in process memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*counter), now: time.Now}
+}
+
+func (s *MemoryStore) Increment(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, window)
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, c.resetAt, nil
+}
+
+// sweep drops the expired counters once in a while so idle clients do not
+// pile up.
+func (s *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"errors"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusCodes maps the codes of the domain errors to gRPC.
+var statusCodes = map[string]codes.Code{
+	er.CodeInvalidRequest:     codes.InvalidArgument,
+	er.CodeUnauthorized:       codes.Unauthenticated,
+	er.CodeInsufficientFunds:  codes.FailedPrecondition,
+	er.CodeNotFound:           codes.NotFound,
+	er.CodeAlreadyExists:      codes.AlreadyExists,
+	er.CodeConflict:           codes.Aborted,
+	er.CodeIllegalTransition:  codes.FailedPrecondition,
+	er.CodeInvalidOrderNumber: codes.InvalidArgument,
+	er.CodeUnprocessable:      codes.FailedPrecondition,
+}
+
+// errorStatus returns the gRPC status of err. Errors which are not domain
+// errors are internal and their message is not shown to clients; the invalid
+// fields of validation errors are sent as BadRequest details.
+func errorStatus(err error) *status.Status {
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+	var coded er.Coded
+	if !errors.As(err, &coded) {
+		return status.New(codes.Internal, "Internal server error")
+	}
+
+	code, ok := statusCodes[coded.Code()]
+	if !ok {
+		code = codes.Internal
+	}
+	s := status.New(code, coded.Error())
+	var validationErr *er.ValidationError
+	if errors.As(err, &validationErr) && len(validationErr.Fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, f := range validationErr.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+		}
+		if detailed, err := s.WithDetails(badRequest); err == nil {
+			s = detailed
+		}
+	}
+	return s
+}
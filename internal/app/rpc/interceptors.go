@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/rpc/pb"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// TokenMetadataKey is the metadata key of the session token.
+const TokenMetadataKey = "authorization"
+
+// publicMethods are served without a session.
+var publicMethods = map[string]bool{
+	"/" + pb.Gophermart_ServiceDesc.ServiceName + "/Register": true,
+	"/" + pb.Gophermart_ServiceDesc.ServiceName + "/Login":    true,
+}
+
+type loginKey struct{}
+
+// loginFrom returns the login of the user authenticated by the interceptors.
+func loginFrom(ctx context.Context) string {
+	login, _ := ctx.Value(loginKey{}).(string)
+	return login
+}
+
+// authenticator resolves the session token of the call to the login of its
+// user.
+type authenticator struct {
+	service *core.UserService
+}
+
+func (a authenticator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a authenticator) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if publicMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(TokenMetadataKey)
+	if len(tokens) == 0 {
+		return nil, er.NewAuthenticationError()
+	}
+	session, err := core.Decrypt(strings.TrimPrefix(tokens[0], "Bearer "))
+	if err != nil {
+		return nil, er.NewAuthenticationError()
+	}
+	sessionModel, err := a.service.GetUserBySession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	logging.SetLogin(ctx, sessionModel.Login)
+	return context.WithValue(ctx, loginKey{}, sessionModel.Login), nil
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// unaryLogger logs every call when it is served, converts its error to a gRPC
+// status and recovers its panics. It must run first.
+func unaryLogger(log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		start := time.Now()
+		ctx = logging.WithRequest(ctx, core.GenerateUUID())
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				logging.For(ctx, log).Error("handler panicked", zap.Any("panic", rvr), zap.Stack("stack"))
+				resp, err = nil, status.Error(codes.Internal, "Internal server error")
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+		err = logCall(ctx, log, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamLogger is unaryLogger for streams, which are logged when they end.
+func streamLogger(log *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx := logging.WithRequest(ss.Context(), core.GenerateUUID())
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				logging.For(ctx, log).Error("handler panicked", zap.Any("panic", rvr), zap.Stack("stack"))
+				err = status.Error(codes.Internal, "Internal server error")
+			}
+		}()
+
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		return logCall(ctx, log, info.FullMethod, start, err)
+	}
+}
+
+func logCall(ctx context.Context, log *zap.Logger, method string, start time.Time, err error) error {
+	s := errorStatus(err)
+	level := zapcore.InfoLevel
+	if s.Code() == codes.Internal || s.Code() == codes.Unknown {
+		level = zapcore.ErrorLevel
+	}
+	if entry := logging.For(ctx, log).Check(level, "call served"); entry != nil {
+		fields := []zap.Field{
+			zap.String("method", method),
+			zap.String("code", s.Code().String()),
+			zap.Duration("duration", time.Since(start)),
+		}
+		if err != nil && level == zapcore.ErrorLevel {
+			fields = append(fields, zap.Error(err))
+		}
+		entry.Write(fields...)
+	}
+	return s.Err()
+}
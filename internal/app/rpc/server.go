@@ -0,0 +1,298 @@
+// Package rpc serves the gRPC API, which mirrors the HTTP API on top of the
+// same services.
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/rpc/pb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"strings"
+	"time"
+)
+
+type Server struct {
+	pb.UnimplementedGophermartServer
+	UserService    *core.UserService
+	OrderService   *core.OrderService
+	BalanceService *core.BalanceService
+	Bus            *events.Bus
+	Log            *zap.Logger
+	// Done is closed on shutdown to end the open streams, which would
+	// otherwise keep grpc.Server.GracefulStop waiting.
+	Done <-chan struct{}
+}
+
+// NewGRPCServer registers srv on a gRPC server which authenticates, logs and
+// maps the errors of its calls.
+func NewGRPCServer(srv *Server, opts ...grpc.ServerOption) *grpc.Server {
+	a := authenticator{service: srv.UserService}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(unaryLogger(srv.Log), a.unary),
+		grpc.ChainStreamInterceptor(streamLogger(srv.Log), a.stream),
+	)
+	s := grpc.NewServer(opts...)
+	pb.RegisterGophermartServer(s, srv)
+	return s
+}
+
+func (s *Server) Register(ctx context.Context, req *pb.Credentials) (*pb.Session, error) {
+	user := entities.LoginRequest{Login: req.Login, Password: req.Password}
+	if user.Login == "" || user.Password == "" {
+		return nil, er.NewValidationError("Password or login empty")
+	}
+	if err := s.UserService.CheckUserExists(ctx, user); err != nil {
+		return nil, err
+	}
+
+	session, token, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	err = s.UserService.InsertNewUser(ctx, entities.UserModel{Login: user.Login, PasswordHash: user.Password, Session: session})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Session{Token: token}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *pb.Credentials) (*pb.Session, error) {
+	user := entities.LoginRequest{Login: req.Login, Password: req.Password}
+	if user.Login == "" || user.Password == "" {
+		return nil, er.NewValidationError("Password or login empty")
+	}
+	if err := s.UserService.CheckUserCredentials(ctx, user); err != nil {
+		return nil, err
+	}
+
+	session, token, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	err = s.UserService.UpdateUserSession(ctx, entities.UserSessionModel{Login: user.Login, Session: session})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Session{Token: token}, nil
+}
+
+func (s *Server) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	login := loginFrom(ctx)
+	if req.Number == "" {
+		return nil, er.NewFieldError("number", "is required")
+	}
+
+	created, err := s.OrderService.CreateNewOrder(ctx, login, req.Number)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.OrderService.GetOrder(ctx, login, req.Number)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UploadOrderResponse{Order: orderMessage(order.OrderResponse), Created: created}, nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *pb.ListRequest) (*pb.ListOrdersResponse, error) {
+	query, err := listQuery(req, core.OrderStatuses)
+	if err != nil {
+		return nil, err
+	}
+	orders, page, err := s.OrderService.GetOrdersForUser(ctx, loginFrom(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListOrdersResponse{Orders: make([]*pb.Order, 0, len(orders)), Pagination: pagination(page)}
+	for _, o := range orders {
+		resp.Orders = append(resp.Orders, orderMessage(o))
+	}
+	return resp, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, _ *pb.GetBalanceRequest) (*pb.Balance, error) {
+	balance, err := s.BalanceService.GetUsersBalance(ctx, loginFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Balance{
+		Current:   moneyMessage(core.NewMoney(balance.Balance)),
+		Withdrawn: moneyMessage(core.NewMoney(balance.Spent)),
+	}, nil
+}
+
+func (s *Server) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.Withdrawal, error) {
+	if req.Order == "" {
+		return nil, er.NewFieldError("order", "is required")
+	}
+	if req.Sum == nil {
+		return nil, er.NewFieldError("sum", "is required")
+	}
+	sum, err := core.Points("sum", entities.Money{Value: req.Sum.Value, Currency: req.Sum.Currency, Scale: int(req.Sum.Scale)})
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal, err := s.BalanceService.ProcessBalanceWithdraw(ctx, loginFrom(ctx),
+		entities.BalanceWithdrawRequest{Order: req.Order, Sum: sum})
+	if err != nil {
+		return nil, err
+	}
+	return withdrawalMessage(*withdrawal), nil
+}
+
+func (s *Server) ListWithdrawals(ctx context.Context, req *pb.ListRequest) (*pb.ListWithdrawalsResponse, error) {
+	query, err := listQuery(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, page, err := s.BalanceService.GetWithdrawalsForUser(ctx, loginFrom(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListWithdrawalsResponse{Withdrawals: make([]*pb.Withdrawal, 0, len(withdrawals)), Pagination: pagination(page)}
+	for _, bw := range withdrawals {
+		resp.Withdrawals = append(resp.Withdrawals, withdrawalMessage(bw))
+	}
+	return resp, nil
+}
+
+// WatchOrders sends the order status changes of the user, starting with the
+// ones after req.LastEventId still kept by the bus, or a reset if some are not.
+func (s *Server) WatchOrders(req *pb.WatchOrdersRequest, stream pb.Gophermart_WatchOrdersServer) error {
+	ctx := stream.Context()
+	backlog, ch, cancel := s.Bus.Subscribe(loginFrom(ctx), req.LastEventId)
+	defer cancel()
+
+	for _, e := range backlog {
+		if err := s.sendStatusUpdate(stream, e); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Done:
+			return nil
+		case e, ok := <-ch:
+			if !ok {
+				// the subscriber fell behind, the client resumes from the last event
+				return er.NewConflictError("Too many events, resume from the last received one")
+			}
+			if err := s.sendStatusUpdate(stream, e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) sendStatusUpdate(stream pb.Gophermart_WatchOrdersServer, e events.Event) error {
+	if e.Type == events.StreamReset {
+		return stream.Send(&pb.OrderStatusUpdate{EventId: e.ID, StreamReset: true})
+	}
+	if e.Type != events.OrderStatusChanged {
+		return nil
+	}
+	status, ok := e.Data.(entities.OrderStatusEvent)
+	if !ok {
+		logging.For(stream.Context(), s.Log).Warn("unexpected order status event", zap.String("event_id", e.ID))
+		return nil
+	}
+	return stream.Send(&pb.OrderStatusUpdate{
+		EventId:   e.ID,
+		Number:    status.OrderNum,
+		Status:    status.Status,
+		Accrual:   moneyMessageIfSet(status.Accrual),
+		ChangedAt: e.At.Format(time.RFC3339),
+	})
+}
+
+// newSession returns a session id and the token carrying it, which is
+// encrypted the same way as the session cookie of the HTTP API.
+func newSession() (string, string, error) {
+	session := core.GenerateUUID()
+	token, err := core.Encrypt(session)
+	if err != nil {
+		return "", "", err
+	}
+	return session, token, nil
+}
+
+func listQuery(req *pb.ListRequest, allowedStatuses []string) (entities.ListQuery, error) {
+	query := entities.ListQuery{Desc: req.Desc}
+	if req.Cursor != "" {
+		after, err := core.DecodeCursor(req.Cursor)
+		if err != nil {
+			return query, er.NewFieldError("cursor", "is malformed")
+		}
+		query.After = after
+	}
+	if req.Limit < 0 {
+		return query, er.NewFieldError("limit", "must not be negative")
+	}
+	query.Limit = int(req.Limit)
+
+	if allowedStatuses != nil {
+		for _, status := range req.Statuses {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !contains(allowedStatuses, status) {
+				return query, er.NewFieldError("statuses", fmt.Sprintf("must be one of %v", allowedStatuses))
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+	return query, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func orderMessage(o entities.OrderResponse) *pb.Order {
+	return &pb.Order{
+		Number:     o.OrderNum,
+		Status:     o.Status,
+		Accrual:    moneyMessageIfSet(o.Accrual),
+		UploadedAt: o.UploadedAt,
+	}
+}
+
+func withdrawalMessage(bw entities.BalanceWithdrawalsResponse) *pb.Withdrawal {
+	return &pb.Withdrawal{
+		Order:       bw.OrderNum,
+		Sum:         moneyMessage(core.NewMoney(bw.Sum)),
+		ProcessedAt: bw.ProcessedAt,
+	}
+}
+
+func moneyMessage(m entities.Money) *pb.Money {
+	return &pb.Money{Value: m.Value, Currency: m.Currency, Scale: int32(m.Scale)}
+}
+
+func moneyMessageIfSet(points *float64) *pb.Money {
+	if points == nil {
+		return nil
+	}
+	return moneyMessage(core.NewMoney(*points))
+}
+
+func pagination(page *entities.PageInfo) *pb.Pagination {
+	return &pb.Pagination{
+		Total:      int32(page.Total),
+		Limit:      int32(page.Limit),
+		NextCursor: page.NextCursor,
+	}
+}
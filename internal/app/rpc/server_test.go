@@ -0,0 +1,179 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
+	"github.com/xbreathoflife/gophermart/internal/app/rpc/pb"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/memory"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) (pb.GophermartClient, *events.Bus) {
+	mem := memory.NewStorage()
+	bus := events.NewBus(100)
+	done := make(chan struct{})
+	srv := NewGRPCServer(&Server{
+		UserService:    core.NewUserService(mem, mem, mem),
+		OrderService:   core.NewOrderService(mem, mem, mem, bus, core.AccrualConfig{}, zap.NewNop(), context.Background()),
+		BalanceService: core.NewBalanceService(mem, mem, mem, bus),
+		Bus:            bus,
+		Log:            zap.NewNop(),
+		Done:           done,
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(lis) }()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		close(done)
+		_ = conn.Close()
+		srv.Stop()
+	})
+	return pb.NewGophermartClient(conn), bus
+}
+
+func withToken(ctx context.Context, session *pb.Session) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, session.Token)
+}
+
+func TestServer_Auth(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.GetBalance(ctx, &pb.GetBalanceRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetBalance(metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, "heh"), &pb.GetBalanceRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Login(ctx, &pb.Credentials{Login: "hello", Password: "123456"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Register(ctx, &pb.Credentials{Login: "hello"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	registered, err := client.Register(ctx, &pb.Credentials{Login: "hello", Password: "123456"})
+	require.NoError(t, err)
+	_, err = client.Register(ctx, &pb.Credentials{Login: "hello", Password: "123456"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	session, err := client.Login(ctx, &pb.Credentials{Login: "hello", Password: "123456"})
+	require.NoError(t, err)
+	_, err = client.GetBalance(withToken(ctx, registered), &pb.GetBalanceRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "the previous session ends on login")
+	_, err = client.GetBalance(withToken(ctx, session), &pb.GetBalanceRequest{})
+	assert.NoError(t, err)
+}
+
+func TestServer_OrdersAndBalance(t *testing.T) {
+	client, _ := newTestClient(t)
+	session, err := client.Register(context.Background(), &pb.Credentials{Login: "hello", Password: "123456"})
+	require.NoError(t, err)
+	ctx := withToken(context.Background(), session)
+
+	uploaded, err := client.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "2377225624"})
+	require.NoError(t, err)
+	assert.True(t, uploaded.Created)
+	assert.Equal(t, "2377225624", uploaded.Order.Number)
+	assert.Equal(t, core.NewStatus, uploaded.Order.Status)
+	assert.Nil(t, uploaded.Order.Accrual)
+	uploaded, err = client.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "2377225624"})
+	require.NoError(t, err)
+	assert.False(t, uploaded.Created)
+
+	_, err = client.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "12345"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	orders, err := client.ListOrders(ctx, &pb.ListRequest{Statuses: []string{"new"}})
+	require.NoError(t, err)
+	require.Len(t, orders.Orders, 1)
+	assert.Equal(t, "2377225624", orders.Orders[0].Number)
+	assert.Equal(t, int32(1), orders.Pagination.Total)
+	assert.Equal(t, int32(core.DefaultPageLimit), orders.Pagination.Limit)
+
+	_, err = client.ListOrders(ctx, &pb.ListRequest{Statuses: []string{"heh"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	violations := details[0].(*errdetails.BadRequest).FieldViolations
+	require.Len(t, violations, 1)
+	assert.Equal(t, "statuses", violations[0].Field)
+
+	balance, err := client.GetBalance(ctx, &pb.GetBalanceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, &pb.Money{Value: 0, Currency: core.PointsCurrency, Scale: core.PointsScale}, balance.Current)
+
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624",
+		Sum: &pb.Money{Value: 1050, Currency: core.PointsCurrency, Scale: 2}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624",
+		Sum: &pb.Money{Value: 1050, Currency: "USD", Scale: 2}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, value := range []int64{-1050, 0} {
+		_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624",
+			Sum: &pb.Money{Value: value, Currency: core.PointsCurrency, Scale: 2}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "sum %d", value)
+		violations := status.Convert(err).Details()[0].(*errdetails.BadRequest).FieldViolations
+		assert.Equal(t, "sum", violations[0].Field)
+	}
+
+	withdrawals, err := client.ListWithdrawals(ctx, &pb.ListRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals.Withdrawals)
+}
+
+func TestServer_WatchOrders(t *testing.T) {
+	client, bus := newTestClient(t)
+	session, err := client.Register(context.Background(), &pb.Credentials{Login: "hello", Password: "123456"})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(withToken(context.Background(), session), 5*time.Second)
+	defer cancel()
+
+	accrual := 10.5
+	first := bus.Publish("goodbye", events.OrderStatusChanged, entities.OrderStatusEvent{OrderNum: "1", Status: core.ProcessingStatus})
+	bus.Publish("hello", events.BalanceChanged, entities.BalanceModel{Balance: accrual})
+
+	third := bus.Publish("hello", events.OrderStatusChanged, entities.OrderStatusEvent{OrderNum: "2377225624", Status: core.ProcessingStatus})
+
+	stream, err := client.WatchOrders(ctx, &pb.WatchOrdersRequest{LastEventId: first.ID})
+	require.NoError(t, err)
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, third.ID, update.EventId)
+	assert.Equal(t, "2377225624", update.Number)
+	assert.Equal(t, core.ProcessingStatus, update.Status)
+	assert.Nil(t, update.Accrual)
+
+	bus.Publish("hello", events.OrderStatusChanged, entities.OrderStatusEvent{OrderNum: "2377225624", Status: core.ProcessedStatus, Accrual: &accrual})
+	update, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, core.ProcessedStatus, update.Status)
+	assert.Equal(t, &pb.Money{Value: 1050, Currency: core.PointsCurrency, Scale: core.PointsScale}, update.Accrual)
+
+	resumed, err := client.WatchOrders(ctx, &pb.WatchOrdersRequest{LastEventId: "before-restart-1"})
+	require.NoError(t, err)
+	update, err = resumed.Recv()
+	require.NoError(t, err)
+	assert.True(t, update.StreamReset)
+	assert.NotEmpty(t, update.EventId)
+
+	unauthenticated, err := client.WatchOrders(context.Background(), &pb.WatchOrdersRequest{})
+	require.NoError(t, err)
+	_, err = unauthenticated.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/xbreathoflife/gophermart/internal/app/rpc"
+	"google.golang.org/grpc"
+)
+
+// GRPCServer returns the gRPC API on top of the services of the HTTP one. Its
+// streams end on Shutdown as well.
+func (gs *gophServer) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	return rpc.NewGRPCServer(&rpc.Server{
+		UserService:    gs.userService,
+		OrderService:   gs.orderService,
+		BalanceService: gs.balanceService,
+		Bus:            gs.bus,
+		Log:            gs.log,
+		Done:           gs.shutdown,
+	}, opts...)
+}
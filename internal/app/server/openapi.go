@@ -0,0 +1,590 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/xbreathoflife/gophermart/internal/app/auth"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
+	"github.com/xbreathoflife/gophermart/internal/app/handler"
+	"github.com/xbreathoflife/gophermart/internal/app/openapi"
+	"net/http"
+)
+
+const OpenAPIPath = "/api/openapi.json"
+
+var sessionSecurity = []map[string][]string{{"session": {}}}
+
+// Document describes every route of ServerHandler, the validator rejects
+// requests which do not match it.
+func Document() *openapi.Document {
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Gophermart",
+			Description: "Loyalty points for orders checked by the accrual system.",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]*openapi.PathItem{
+			"/api/user/register": {
+				"post": {
+					OperationID: "register",
+					Summary:     "Registers a user and logs them in",
+					Tags:        []string{"user"},
+					RequestBody: jsonBody("LoginRequest"),
+					Responses: responses(
+						response("200", "Registered, the session cookie is set"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("409", "The login is taken"),
+						errorResponse("429", "Too many requests"),
+					),
+				},
+			},
+			"/api/user/login": {
+				"post": {
+					OperationID: "login",
+					Summary:     "Logs a user in",
+					Tags:        []string{"user"},
+					RequestBody: jsonBody("LoginRequest"),
+					Responses: responses(
+						response("200", "Logged in, the session cookie is set"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Wrong login or password"),
+						errorResponse("429", "Too many requests"),
+					),
+				},
+			},
+			"/api/user/csrf": {
+				"get": {
+					OperationID: "getCSRFToken",
+					Summary:     "Returns the CSRF token of the session",
+					Tags:        []string{"user"},
+					Security:    sessionSecurity,
+					Responses: responses(
+						jsonResponse("200", "The token to send in the "+csrf.HeaderName+" header", openapi.Ref("CSRFToken")),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/orders": {
+				"post": {
+					OperationID: "uploadOrder",
+					Summary:     "Uploads an order number to be checked for accrual",
+					Tags:        []string{"orders"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{idempotencyKey()},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]openapi.MediaType{
+							"text/plain": {Schema: &openapi.Schema{Type: "string", Pattern: "^[0-9]+$"}},
+						},
+					},
+					Responses: responses(
+						response("200", "The order was uploaded by the user before"),
+						response("202", "The order is accepted"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("409", "The order was uploaded by another user"),
+						errorResponse("422", "The order number fails the Luhn check"),
+						errorResponse("429", "Too many requests"),
+					),
+				},
+				"get": {
+					OperationID: "listOrders",
+					Summary:     "Lists the orders of the user",
+					Tags:        []string{"orders"},
+					Security:    sessionSecurity,
+					Parameters: append(listParameters(), openapi.Parameter{
+						Name:        "status",
+						In:          "query",
+						Description: "Comma separated statuses to filter by, case insensitive",
+						Schema:      &openapi.Schema{Type: "string"},
+					}),
+					Responses: responses(
+						pagedResponse("200", "The orders", arrayOf("Order")),
+						response("204", "There are no orders"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/orders/{number}": {
+				"get": {
+					OperationID: "getOrder",
+					Summary:     "Returns an order with its status history",
+					Tags:        []string{"orders"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{orderNumber()},
+					Responses: responses(
+						jsonResponse("200", "The order", openapi.Ref("OrderDetail")),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("404", "The order is not found"),
+					),
+				},
+				"delete": {
+					OperationID: "cancelOrder",
+					Summary:     "Cancels an order which is not processed yet",
+					Tags:        []string{"orders"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{orderNumber()},
+					Responses: responses(
+						response("204", "The order is cancelled"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("404", "The order is not found"),
+						errorResponse("409", "The order can not be cancelled anymore"),
+					),
+				},
+			},
+			"/api/user/balance": {
+				"get": {
+					OperationID: "getBalance",
+					Summary:     "Returns the balance of the user",
+					Tags:        []string{"balance"},
+					Security:    sessionSecurity,
+					Responses: responses(
+						jsonResponse("200", "The balance", openapi.Ref("Balance")),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/balance/withdraw": {
+				"post": {
+					OperationID: "withdraw",
+					Summary:     "Withdraws points for an order",
+					Tags:        []string{"balance"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{idempotencyKey()},
+					RequestBody: jsonBody("WithdrawRequest"),
+					Responses: responses(
+						response("200", "The points are withdrawn"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("402", "Not enough points"),
+						errorResponse("422", "The order number fails the Luhn check"),
+					),
+				},
+			},
+			"/api/user/balance/withdrawals": {
+				"get": {
+					OperationID: "listWithdrawals",
+					Summary:     "Lists the withdrawals of the user",
+					Tags:        []string{"balance"},
+					Security:    sessionSecurity,
+					Parameters:  listParameters(),
+					Responses: responses(
+						pagedResponse("200", "The withdrawals", arrayOf("Withdrawal")),
+						response("204", "There are no withdrawals"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/events": {
+				"get": {
+					OperationID: "streamEvents",
+					Summary:     "Streams the events of the user as server-sent events",
+					Tags:        []string{"events"},
+					Security:    sessionSecurity,
+					Parameters: []openapi.Parameter{{
+						Name:        "Last-Event-ID",
+						In:          "header",
+						Description: "Resumes the stream after this event, or sends a stream.reset event first if the events after it are not kept any more",
+						Schema:      &openapi.Schema{Type: "string"},
+					}},
+					Responses: responses(
+						contentResponse("200", "The events", "text/event-stream", &openapi.Schema{Type: "string"}),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/webhooks": {
+				"post": {
+					OperationID: "createWebhook",
+					Summary:     "Subscribes a URL to events of the user",
+					Tags:        []string{"webhooks"},
+					Security:    sessionSecurity,
+					RequestBody: jsonBody("WebhookRequest"),
+					Responses: responses(
+						jsonResponse("201", "The webhook with its signing secret", openapi.Ref("Webhook")),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+				"get": {
+					OperationID: "listWebhooks",
+					Summary:     "Lists the webhooks of the user",
+					Tags:        []string{"webhooks"},
+					Security:    sessionSecurity,
+					Responses: responses(
+						jsonResponse("200", "The webhooks", arrayOf("Webhook")),
+						response("204", "There are no webhooks"),
+						errorResponse("401", "Not logged in"),
+					),
+				},
+			},
+			"/api/user/webhooks/{id}": {
+				"delete": {
+					OperationID: "deleteWebhook",
+					Summary:     "Deletes a webhook",
+					Tags:        []string{"webhooks"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{webhookID()},
+					Responses: responses(
+						response("204", "The webhook is deleted"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("404", "The webhook is not found"),
+					),
+				},
+			},
+			"/api/user/webhooks/{id}/deliveries": {
+				"get": {
+					OperationID: "listWebhookDeliveries",
+					Summary:     "Lists the delivery attempts of a webhook",
+					Tags:        []string{"webhooks"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{webhookID()},
+					Responses: responses(
+						jsonResponse("200", "The deliveries", arrayOf("WebhookDelivery")),
+						response("204", "There are no deliveries"),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("404", "The webhook is not found"),
+					),
+				},
+			},
+			"/api/user/webhooks/{id}/test": {
+				"post": {
+					OperationID: "testWebhook",
+					Summary:     "Delivers a test event to a webhook",
+					Tags:        []string{"webhooks"},
+					Security:    sessionSecurity,
+					Parameters:  []openapi.Parameter{webhookID()},
+					Responses: responses(
+						jsonResponse("200", "The delivery", openapi.Ref("WebhookDelivery")),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "Not logged in"),
+						errorResponse("404", "The webhook is not found"),
+					),
+				},
+			},
+			"/api/partner/orders/{number}": {
+				"get": {
+					OperationID: "getPartnerOrderStatus",
+					Summary:     "Returns the status of any order, for partners authenticated by client certificate",
+					Tags:        []string{"partner"},
+					Parameters:  []openapi.Parameter{orderNumber()},
+					Responses: responses(
+						jsonResponse("200", "The order", openapi.Ref("Order")),
+						errorResponse("400", "Malformed request"),
+						errorResponse("401", "No verified client certificate"),
+						errorResponse("403", "The client certificate is not allowed"),
+						errorResponse("404", "The order is not found"),
+					),
+				},
+			},
+			OpenAPIPath: {
+				"get": {
+					OperationID: "getOpenAPI",
+					Summary:     "Returns this document",
+					Tags:        []string{"meta"},
+					Responses: responses(
+						jsonResponse("200", "The OpenAPI document", &openapi.Schema{Type: "object"}),
+					),
+				},
+			},
+			"/metrics": {
+				"get": {
+					OperationID: "getMetrics",
+					Summary:     "Returns the Prometheus metrics",
+					Tags:        []string{"meta"},
+					Responses: responses(
+						contentResponse("200", "The metrics", "text/plain", &openapi.Schema{Type: "string"}),
+					),
+				},
+			},
+			"/healthz": {
+				"get": {
+					OperationID: "getLiveness",
+					Summary:     "Reports that the process is alive",
+					Tags:        []string{"meta"},
+					Responses: responses(
+						jsonResponse("200", "Alive", openapi.Ref("HealthCheck")),
+					),
+				},
+			},
+			"/readyz": {
+				"get": {
+					OperationID: "getReadiness",
+					Summary:     "Reports whether the dependencies are available",
+					Tags:        []string{"meta"},
+					Responses: responses(
+						jsonResponse("200", "Ready", openapi.Ref("Readiness")),
+						jsonResponse("503", "Not ready", openapi.Ref("Readiness")),
+					),
+				},
+			},
+		},
+		Components: openapi.Components{
+			Schemas: schemas(),
+			SecuritySchemes: map[string]openapi.SecurityScheme{
+				"session": {
+					Type:        "apiKey",
+					In:          "cookie",
+					Name:        auth.CookieName,
+					Description: "Set by register and login. Mutations from browsers also need the X-CSRF-Token header.",
+				},
+			},
+		},
+	}
+	documentV2(doc)
+	return doc
+}
+
+func schemas() map[string]*openapi.Schema {
+	str := &openapi.Schema{Type: "string"}
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	id := &openapi.Schema{Type: "integer", Format: "int64"}
+	amount := &openapi.Schema{Type: "number"}
+	orderStatus := &openapi.Schema{Type: "string", Enum: core.OrderStatuses}
+
+	return map[string]*openapi.Schema{
+		"LoginRequest": {
+			Type:     "object",
+			Required: []string{"login", "password"},
+			Properties: map[string]*openapi.Schema{
+				"login":    {Type: "string", MinLength: openapi.Int(1)},
+				"password": {Type: "string", MinLength: openapi.Int(1)},
+			},
+		},
+		"CSRFToken": {
+			Type:       "object",
+			Required:   []string{"token"},
+			Properties: map[string]*openapi.Schema{"token": str},
+		},
+		"Order": {
+			Type:     "object",
+			Required: []string{"number", "status", "uploaded_at"},
+			Properties: map[string]*openapi.Schema{
+				"number":      str,
+				"status":      orderStatus,
+				"accrual":     amount,
+				"uploaded_at": dateTime,
+			},
+		},
+		"OrderStatusHistory": {
+			Type:     "object",
+			Required: []string{"status", "changed_at"},
+			Properties: map[string]*openapi.Schema{
+				"status":     orderStatus,
+				"accrual":    amount,
+				"changed_at": dateTime,
+			},
+		},
+		"OrderDetail": {
+			Type:     "object",
+			Required: []string{"number", "status", "uploaded_at", "history"},
+			Properties: map[string]*openapi.Schema{
+				"number":      str,
+				"status":      orderStatus,
+				"accrual":     amount,
+				"uploaded_at": dateTime,
+				"history":     arrayOf("OrderStatusHistory"),
+			},
+		},
+		"Balance": {
+			Type:     "object",
+			Required: []string{"current", "withdrawn"},
+			Properties: map[string]*openapi.Schema{
+				"current":   amount,
+				"withdrawn": amount,
+			},
+		},
+		"WithdrawRequest": {
+			Type:     "object",
+			Required: []string{"order", "sum"},
+			Properties: map[string]*openapi.Schema{
+				"order": {Type: "string", MinLength: openapi.Int(1)},
+				"sum":   {Type: "number", Minimum: openapi.Float(0), ExclusiveMinimum: true},
+			},
+		},
+		"Withdrawal": {
+			Type:     "object",
+			Required: []string{"order", "sum", "processed_at"},
+			Properties: map[string]*openapi.Schema{
+				"order":        str,
+				"sum":          amount,
+				"processed_at": dateTime,
+			},
+		},
+		"WebhookRequest": {
+			Type:     "object",
+			Required: []string{"url"},
+			Properties: map[string]*openapi.Schema{
+				"url": {Type: "string", Format: "uri"},
+				"event_types": {
+					Type:        "array",
+					Description: "All event types if empty",
+					Items:       &openapi.Schema{Type: "string", Enum: core.WebhookEventTypes},
+				},
+			},
+		},
+		"Webhook": {
+			Type:     "object",
+			Required: []string{"id", "url", "event_types", "created_at"},
+			Properties: map[string]*openapi.Schema{
+				"id":          id,
+				"url":         str,
+				"event_types": {Type: "array", Items: str},
+				"secret":      {Type: "string", Description: "Signs the deliveries, only returned on creation"},
+				"created_at":  dateTime,
+			},
+		},
+		"WebhookDelivery": {
+			Type:     "object",
+			Required: []string{"id", "event_id", "event_type", "attempt", "status_code", "success", "delivered_at"},
+			Properties: map[string]*openapi.Schema{
+				"id":           id,
+				"event_id":     str,
+				"event_type":   str,
+				"attempt":      {Type: "integer"},
+				"status_code":  {Type: "integer"},
+				"error":        str,
+				"success":      {Type: "boolean"},
+				"delivered_at": dateTime,
+			},
+		},
+		"HealthCheck": {
+			Type:     "object",
+			Required: []string{"status"},
+			Properties: map[string]*openapi.Schema{
+				"status":  str,
+				"error":   str,
+				"pending": {Type: "integer"},
+				"state":   str,
+			},
+		},
+		"Readiness": {
+			Type:     "object",
+			Required: []string{"status", "checks"},
+			Properties: map[string]*openapi.Schema{
+				"status": str,
+				"checks": {Type: "object", Description: "Health checks by name"},
+			},
+		},
+		"Error": {
+			Type:     "object",
+			Required: []string{"error"},
+			Properties: map[string]*openapi.Schema{
+				"error": {
+					Type:     "object",
+					Required: []string{"code", "message"},
+					Properties: map[string]*openapi.Schema{
+						"code":    str,
+						"message": str,
+						"details": {
+							Type: "array",
+							Items: &openapi.Schema{
+								Type:     "object",
+								Required: []string{"field", "message"},
+								Properties: map[string]*openapi.Schema{
+									"field":   str,
+									"message": str,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func listParameters() []openapi.Parameter {
+	return []openapi.Parameter{
+		{Name: "cursor", In: "query", Description: "Continues after the page which returned it in " + handler.NextCursorHeader, Schema: &openapi.Schema{Type: "string"}},
+		{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Minimum: openapi.Float(1)}},
+		{Name: "sort", In: "query", Description: "By upload time", Schema: &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}},
+		{Name: "from", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+		{Name: "to", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	}
+}
+
+func idempotencyKey() openapi.Parameter {
+	return openapi.Parameter{
+		Name:        handler.IdempotencyKeyHeader,
+		In:          "header",
+		Description: "Repeats with the same key replay the first response",
+		Schema:      &openapi.Schema{Type: "string", MaxLength: openapi.Int(255)},
+	}
+}
+
+func orderNumber() openapi.Parameter {
+	return openapi.Parameter{Name: "number", In: "path", Required: true, Schema: &openapi.Schema{Type: "string", Pattern: "^[0-9]+$"}}
+}
+
+func webhookID() openapi.Parameter {
+	return openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer", Format: "int64"}}
+}
+
+func jsonBody(schema string) *openapi.RequestBody {
+	return &openapi.RequestBody{
+		Required: true,
+		Content:  map[string]openapi.MediaType{"application/json": {Schema: openapi.Ref(schema)}},
+	}
+}
+
+func arrayOf(schema string) *openapi.Schema {
+	return &openapi.Schema{Type: "array", Items: openapi.Ref(schema)}
+}
+
+// statusResponse is a response with the status code it is documented under.
+type statusResponse struct {
+	status   string
+	response openapi.Response
+}
+
+func response(status string, description string) statusResponse {
+	return statusResponse{status: status, response: openapi.Response{Description: description}}
+}
+
+func contentResponse(status string, description string, mediaType string, schema *openapi.Schema) statusResponse {
+	return statusResponse{status: status, response: openapi.Response{
+		Description: description,
+		Content:     map[string]openapi.MediaType{mediaType: {Schema: schema}},
+	}}
+}
+
+func jsonResponse(status string, description string, schema *openapi.Schema) statusResponse {
+	return contentResponse(status, description, "application/json", schema)
+}
+
+func pagedResponse(status string, description string, schema *openapi.Schema) statusResponse {
+	return jsonResponse(status, description+", "+handler.TotalCountHeader+" has the total and "+
+		handler.NextCursorHeader+" the cursor of the next page", schema)
+}
+
+func errorResponse(status string, description string) statusResponse {
+	return jsonResponse(status, description, openapi.Ref("Error"))
+}
+
+func responses(rs ...statusResponse) map[string]openapi.Response {
+	m := make(map[string]openapi.Response, len(rs))
+	for _, r := range rs {
+		m[r.status] = r.response
+	}
+	return m
+}
+
+func (gs *gophServer) serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	js, err := json.Marshal(gs.document)
+	if err != nil {
+		http.Error(w, "Error during building response json", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(js)
+}
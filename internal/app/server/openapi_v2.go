@@ -0,0 +1,231 @@
+package server
+
+import (
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/openapi"
+)
+
+// documentV2 adds the routes of routeV2 and their schemas to doc.
+func documentV2(doc *openapi.Document) {
+	paths := map[string]*openapi.PathItem{
+		"/api/v2/orders": {
+			"post": {
+				OperationID: "uploadOrderV2",
+				Summary:     "Uploads an order number to be checked for accrual",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters:  []openapi.Parameter{idempotencyKey()},
+				RequestBody: jsonBody("OrderRequestV2"),
+				Responses: responses(
+					jsonResponse("200", "The order was uploaded by the user before", openapi.Ref("OrderV2")),
+					jsonResponse("202", "The order is accepted", openapi.Ref("OrderV2")),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+					errorResponse("409", "The order was uploaded by another user"),
+					errorResponse("422", "The order number fails the Luhn check"),
+					errorResponse("429", "Too many requests"),
+				),
+			},
+			"get": {
+				OperationID: "listOrdersV2",
+				Summary:     "Lists the orders of the user",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters: append(listParameters(), openapi.Parameter{
+					Name:        "status",
+					In:          "query",
+					Description: "Comma separated statuses to filter by, case insensitive",
+					Schema:      &openapi.Schema{Type: "string"},
+				}),
+				Responses: responses(
+					jsonResponse("200", "A page of orders, empty if there are none", pageOf("OrderV2")),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+				),
+			},
+		},
+		"/api/v2/orders/{number}": {
+			"get": {
+				OperationID: "getOrderV2",
+				Summary:     "Returns an order with its status history",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters:  []openapi.Parameter{orderNumber()},
+				Responses: responses(
+					jsonResponse("200", "The order", openapi.Ref("OrderDetailV2")),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+					errorResponse("404", "The order is not found"),
+				),
+			},
+			"delete": {
+				OperationID: "cancelOrderV2",
+				Summary:     "Cancels an order which is not processed yet",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters:  []openapi.Parameter{orderNumber()},
+				Responses: responses(
+					response("204", "The order is cancelled"),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+					errorResponse("404", "The order is not found"),
+					errorResponse("409", "The order can not be cancelled anymore"),
+				),
+			},
+		},
+		"/api/v2/balance": {
+			"get": {
+				OperationID: "getBalanceV2",
+				Summary:     "Returns the balance of the user",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Responses: responses(
+					jsonResponse("200", "The balance", openapi.Ref("BalanceV2")),
+					errorResponse("401", "Not logged in"),
+				),
+			},
+		},
+		"/api/v2/balance/withdrawals": {
+			"post": {
+				OperationID: "withdrawV2",
+				Summary:     "Withdraws points for an order",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters:  []openapi.Parameter{idempotencyKey()},
+				RequestBody: jsonBody("WithdrawRequestV2"),
+				Responses: responses(
+					jsonResponse("201", "The withdrawal", openapi.Ref("WithdrawalV2")),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+					errorResponse("402", "Not enough points"),
+					errorResponse("422", "The order number fails the Luhn check"),
+				),
+			},
+			"get": {
+				OperationID: "listWithdrawalsV2",
+				Summary:     "Lists the withdrawals of the user",
+				Tags:        []string{"v2"},
+				Security:    sessionSecurity,
+				Parameters:  listParameters(),
+				Responses: responses(
+					jsonResponse("200", "A page of withdrawals, empty if there are none", pageOf("WithdrawalV2")),
+					errorResponse("400", "Malformed request"),
+					errorResponse("401", "Not logged in"),
+				),
+			},
+		},
+	}
+	for path, item := range paths {
+		doc.Paths[path] = item
+	}
+
+	str := &openapi.Schema{Type: "string"}
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	orderStatus := &openapi.Schema{Type: "string", Enum: core.OrderStatuses}
+	accrual := &openapi.Schema{AllOf: []*openapi.Schema{openapi.Ref("Money")}, Nullable: true}
+	schemas := map[string]*openapi.Schema{
+		"Money": {
+			Type:        "object",
+			Description: "An amount in minor units, value / 10^scale of the currency",
+			Required:    []string{"value", "currency", "scale"},
+			Properties: map[string]*openapi.Schema{
+				"value":    {Type: "integer", Format: "int64"},
+				"currency": {Type: "string", Enum: []string{core.PointsCurrency}},
+				"scale":    {Type: "integer", Minimum: openapi.Float(0)},
+			},
+		},
+		"Pagination": {
+			Type:     "object",
+			Required: []string{"total", "limit"},
+			Properties: map[string]*openapi.Schema{
+				"total":       {Type: "integer"},
+				"limit":       {Type: "integer"},
+				"next_cursor": {Type: "string", Description: "Continues after this page, absent on the last page"},
+			},
+		},
+		"OrderRequestV2": {
+			Type:     "object",
+			Required: []string{"number"},
+			Properties: map[string]*openapi.Schema{
+				"number": {Type: "string", Pattern: "^[0-9]+$"},
+			},
+		},
+		"OrderV2": {
+			Type:     "object",
+			Required: []string{"number", "status", "accrual", "uploaded_at"},
+			Properties: map[string]*openapi.Schema{
+				"number":      str,
+				"status":      orderStatus,
+				"accrual":     accrual,
+				"uploaded_at": dateTime,
+			},
+		},
+		"OrderStatusHistoryV2": {
+			Type:     "object",
+			Required: []string{"status", "accrual", "changed_at"},
+			Properties: map[string]*openapi.Schema{
+				"status":     orderStatus,
+				"accrual":    accrual,
+				"changed_at": dateTime,
+			},
+		},
+		"OrderDetailV2": {
+			Type:     "object",
+			Required: []string{"number", "status", "accrual", "uploaded_at", "history"},
+			Properties: map[string]*openapi.Schema{
+				"number":      str,
+				"status":      orderStatus,
+				"accrual":     accrual,
+				"uploaded_at": dateTime,
+				"history":     arrayOf("OrderStatusHistoryV2"),
+			},
+		},
+		"BalanceV2": {
+			Type:     "object",
+			Required: []string{"current", "withdrawn"},
+			Properties: map[string]*openapi.Schema{
+				"current":   openapi.Ref("Money"),
+				"withdrawn": openapi.Ref("Money"),
+			},
+		},
+		"WithdrawRequestV2": {
+			Type:     "object",
+			Required: []string{"order", "sum"},
+			Properties: map[string]*openapi.Schema{
+				"order": {Type: "string", MinLength: openapi.Int(1)},
+				"sum": {
+					Type:     "object",
+					Required: []string{"value", "currency", "scale"},
+					Properties: map[string]*openapi.Schema{
+						"value":    {Type: "integer", Format: "int64", Minimum: openapi.Float(0), ExclusiveMinimum: true},
+						"currency": {Type: "string", Enum: []string{core.PointsCurrency}},
+						"scale":    {Type: "integer", Minimum: openapi.Float(0)},
+					},
+				},
+			},
+		},
+		"WithdrawalV2": {
+			Type:     "object",
+			Required: []string{"order", "sum", "processed_at"},
+			Properties: map[string]*openapi.Schema{
+				"order":        str,
+				"sum":          openapi.Ref("Money"),
+				"processed_at": dateTime,
+			},
+		},
+	}
+	for name, schema := range schemas {
+		doc.Components.Schemas[name] = schema
+	}
+}
+
+func pageOf(schema string) *openapi.Schema {
+	return &openapi.Schema{
+		Type:     "object",
+		Required: []string{"data", "pagination"},
+		Properties: map[string]*openapi.Schema{
+			"data":       arrayOf(schema),
+			"pagination": openapi.Ref("Pagination"),
+		},
+	}
+}
@@ -5,43 +5,189 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/xbreathoflife/gophermart/internal/app/auth"
+	"github.com/xbreathoflife/gophermart/internal/app/certs"
+	"github.com/xbreathoflife/gophermart/internal/app/compress"
 	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/cors"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/events"
 	"github.com/xbreathoflife/gophermart/internal/app/handler"
+	"github.com/xbreathoflife/gophermart/internal/app/logging"
+	"github.com/xbreathoflife/gophermart/internal/app/metrics"
+	"github.com/xbreathoflife/gophermart/internal/app/openapi"
+	"github.com/xbreathoflife/gophermart/internal/app/ratelimit"
 	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"github.com/xbreathoflife/gophermart/internal/app/tracing"
+	"go.uber.org/zap"
+	"net"
 	"net/http"
 )
 
+const eventHistorySize = 1000
+
+// Storages are the backends the server is built on.
+type Storages struct {
+	Balance     storage.BalanceStorage
+	Order       storage.OrderStorage
+	User        storage.UserStorage
+	Idempotency storage.IdempotencyStorage
+	Webhook     storage.WebhookStorage
+	Tx          storage.TxManager
+	Health      storage.HealthStorage
+}
+
+// Options configure the server beyond its storages.
+type Options struct {
+	Accrual     core.AccrualConfig
+	Webhooks    core.WebhookConfig
+	Compression compress.Config
+	RateLimits  RateLimits
+	// RateLimitStore keeps the rate limit counters, in memory if nil.
+	RateLimitStore ratelimit.Store
+	// TrustedProxies may set the client address in the X-Forwarded-For
+	// header, the header is ignored if empty.
+	TrustedProxies []*net.IPNet
+	CORS           cors.Config
+	CSRF           csrf.Config
+	Partners       Partners
+}
+
+// Partners are the clients of the partner routes, which are authenticated by
+// client certificate.
+type Partners struct {
+	// Enabled mounts the partner routes, the server must verify client
+	// certificates then.
+	Enabled bool
+	// Names are the allowed certificate common names, any verified
+	// certificate is allowed if empty.
+	Names []string
+}
+
+// RateLimits are the budgets of the route groups, per client.
+type RateLimits struct {
+	// Auth limits registration and login by IP.
+	Auth ratelimit.Limit
+	// Orders limits order uploads, on top of API.
+	Orders ratelimit.Limit
+	// API limits all authenticated routes.
+	API ratelimit.Limit
+}
+
 type gophServer struct {
-	balanceHandler *handler.BalanceHandler
-	orderHandler   *handler.OrderHandler
-	userHandler    *handler.UserHandler
+	log                *zap.Logger
+	document           *openapi.Document
+	validator          *openapi.Validator
+	compressor         *compress.Compressor
+	limiter            *ratelimit.Limiter
+	cors               *cors.Policy
+	csrf               *csrf.Protector
+	csrfHandler        *handler.CSRFHandler
+	partners           Partners
+	partnerHandler     *handler.PartnerHandler
+	rateLimits         RateLimits
+	trustedProxies     []*net.IPNet
+	bus                *events.Bus
+	userService        *core.UserService
+	orderService       *core.OrderService
+	balanceService     *core.BalanceService
+	healthService      *core.HealthService
+	shutdown           chan struct{}
+	healthHandler      *handler.HealthHandler
+	balanceHandler     *handler.BalanceHandler
+	orderHandler       *handler.OrderHandler
+	userHandler        *handler.UserHandler
+	idempotencyHandler *handler.IdempotencyHandler
+	eventsHandler      *handler.EventsHandler
+	webhookHandler     *handler.WebhookHandler
+	v2Handler          *handler.V2Handler
 }
 
-func NewGothServer(balanceStorage storage.BalanceStorage, orderStorage storage.OrderStorage, userStorage storage.UserStorage, serviceAddress string) *gophServer {
+func NewGothServer(stores Storages, opts Options, log *zap.Logger) *gophServer {
 	ctx := context.Background()
+	bus := events.NewBus(eventHistorySize)
+	shutdown := make(chan struct{})
 
-	balanceService := core.NewBalanceService(balanceStorage)
-	orderService := core.NewOrderService(orderStorage, balanceStorage, serviceAddress, ctx)
-	userService := core.NewUserService(userStorage, balanceStorage)
+	balanceService := core.NewBalanceService(stores.Balance, stores.Idempotency, stores.Tx, bus)
+	orderService := core.NewOrderService(stores.Order, stores.Balance, stores.Tx, bus, opts.Accrual, log, ctx)
+	userService := core.NewUserService(stores.User, stores.Balance, stores.Tx)
+	idempotencyService := core.NewIdempotencyService(stores.Idempotency)
+	go idempotencyService.RunCleanup(ctx, log)
+	webhookService := core.NewWebhookService(stores.Webhook, bus, opts.Webhooks, log, ctx)
+	healthService := core.NewHealthService(stores.Health, orderService.Accrual)
+
+	healthHandler := handler.HealthHandler{Service: healthService}
 
 	balanceHandler := handler.BalanceHandler{Service: balanceService, UserService: userService}
 	orderHandler := handler.OrderHandler{Service: orderService, UserService: userService}
 	userHandler := handler.UserHandler{Service: userService}
+	idempotencyHandler := handler.IdempotencyHandler{Service: idempotencyService, UserService: userService, Log: log}
+	eventsHandler := handler.EventsHandler{Bus: bus, UserService: userService, Done: shutdown}
+	webhookHandler := handler.WebhookHandler{Service: webhookService, UserService: userService}
+
+	csrfConfig := opts.CSRF
+	csrfConfig.CookieName = auth.CookieName
+	protector := csrf.New(csrfConfig)
+	csrfHandler := handler.CSRFHandler{Protector: protector, UserService: userService}
+	partnerHandler := handler.PartnerHandler{Service: orderService}
+	v2Handler := handler.V2Handler{OrderService: orderService, BalanceService: balanceService, UserService: userService}
+
+	rateLimitStore := opts.RateLimitStore
+	if rateLimitStore == nil {
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
 
-	return &gophServer{balanceHandler: &balanceHandler, orderHandler: &orderHandler, userHandler: &userHandler}
+	document := Document()
+
+	gs := &gophServer{log: log, document: document, validator: openapi.NewValidator(document), compressor: compress.New(opts.Compression), rateLimits: opts.RateLimits, trustedProxies: opts.TrustedProxies,
+		bus: bus, userService: userService, orderService: orderService, balanceService: balanceService, cors: cors.New(opts.CORS), csrf: protector, csrfHandler: &csrfHandler,
+		partners: opts.Partners, partnerHandler: &partnerHandler, healthService: healthService, shutdown: shutdown, healthHandler: &healthHandler,
+		balanceHandler: &balanceHandler, orderHandler: &orderHandler, userHandler: &userHandler,
+		idempotencyHandler: &idempotencyHandler, eventsHandler: &eventsHandler, webhookHandler: &webhookHandler,
+		v2Handler: &v2Handler}
+	gs.limiter = ratelimit.NewLimiter(rateLimitStore, gs.rateLimitKey, log)
+	return gs
+}
+
+// rateLimitKey identifies the client by its login when the session is valid,
+// by its IP otherwise.
+func (gs *gophServer) rateLimitKey(r *http.Request) string {
+	if sessionModel, ok := r.Context().Value(auth.UserCtxKey).(*entities.UserSessionModel); ok {
+		return "login:" + sessionModel.Login
+	}
+	return ratelimit.IPKey(r)
+}
+
+// Shutdown turns readiness off and ends the open event streams, which would
+// otherwise keep http.Server.Shutdown waiting.
+func (gs *gophServer) Shutdown() {
+	gs.healthService.SetShuttingDown()
+	close(gs.shutdown)
 }
 
 func (gs *gophServer) ServerHandler() *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(ratelimit.RealIP(gs.trustedProxies))
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware(gs.log))
+	r.Use(logging.Recoverer(gs.log))
+	r.Use(metrics.Middleware)
+	r.Use(gs.compressor.Handler)
+	r.Use(gs.cors.Handler)
 
 	r.Group(func(r chi.Router) {
 		r.Use(auth.CheckAuth)
+		r.Use(gs.csrf.Handler)
+		r.Use(auth.ResolveUser(gs.userService.GetUserBySession))
+		r.Use(gs.limiter.Handler("api", gs.rateLimits.API))
+		r.Use(gs.validator.Handler)
+
+		r.Get("/api/user/csrf", func(rw http.ResponseWriter, r *http.Request) {
+			gs.csrfHandler.GetToken(rw, r)
+		})
 
-		r.Post("/api/user/orders", func(rw http.ResponseWriter, r *http.Request) {
+		r.With(gs.limiter.Handler("orders", gs.rateLimits.Orders), gs.idempotencyHandler.Middleware).Post("/api/user/orders", func(rw http.ResponseWriter, r *http.Request) {
 			gs.orderHandler.PostNewOrderHandler(rw, r)
 		})
 
@@ -49,25 +195,87 @@ func (gs *gophServer) ServerHandler() *chi.Mux {
 			gs.orderHandler.GetOrders(rw, r)
 		})
 
+		r.Get("/api/user/orders/{number}", func(rw http.ResponseWriter, r *http.Request) {
+			gs.orderHandler.GetOrder(rw, r)
+		})
+
+		r.Delete("/api/user/orders/{number}", func(rw http.ResponseWriter, r *http.Request) {
+			gs.orderHandler.DeleteOrder(rw, r)
+		})
+
 		r.Get("/api/user/balance", func(rw http.ResponseWriter, r *http.Request) {
 			gs.balanceHandler.GetBalance(rw, r)
 		})
 
-		r.Post("/api/user/balance/withdraw", func(rw http.ResponseWriter, r *http.Request) {
+		r.With(gs.idempotencyHandler.Middleware).Post("/api/user/balance/withdraw", func(rw http.ResponseWriter, r *http.Request) {
 			gs.balanceHandler.PostBalanceWithdraw(rw, r)
 		})
 
 		r.Get("/api/user/balance/withdrawals", func(rw http.ResponseWriter, r *http.Request) {
 			gs.balanceHandler.GetBalanceWithdrawals(rw, r)
 		})
+
+		r.Get("/api/user/events", func(rw http.ResponseWriter, r *http.Request) {
+			gs.eventsHandler.StreamEvents(rw, r)
+		})
+
+		r.Post("/api/user/webhooks", func(rw http.ResponseWriter, r *http.Request) {
+			gs.webhookHandler.PostWebhook(rw, r)
+		})
+
+		r.Get("/api/user/webhooks", func(rw http.ResponseWriter, r *http.Request) {
+			gs.webhookHandler.GetWebhooks(rw, r)
+		})
+
+		r.Delete("/api/user/webhooks/{id}", func(rw http.ResponseWriter, r *http.Request) {
+			gs.webhookHandler.DeleteWebhook(rw, r)
+		})
+
+		r.Get("/api/user/webhooks/{id}/deliveries", func(rw http.ResponseWriter, r *http.Request) {
+			gs.webhookHandler.GetDeliveries(rw, r)
+		})
+
+		r.Post("/api/user/webhooks/{id}/test", func(rw http.ResponseWriter, r *http.Request) {
+			gs.webhookHandler.PostTestDelivery(rw, r)
+		})
+	})
+
+	r.Route("/api/v2", gs.routeV2)
+
+	if gs.partners.Enabled {
+		r.Group(func(r chi.Router) {
+			r.Use(certs.RequireClientCert(gs.partners.Names, gs.log))
+			r.Use(gs.validator.Handler)
+
+			r.Get("/api/partner/orders/{number}", func(rw http.ResponseWriter, r *http.Request) {
+				gs.partnerHandler.GetOrderStatus(rw, r)
+			})
+		})
+	}
+
+	r.Get(OpenAPIPath, gs.serveOpenAPI)
+
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	r.Get("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		gs.healthHandler.Liveness(rw, r)
 	})
 
-	r.Post("/api/user/register", func(rw http.ResponseWriter, r *http.Request) {
-		gs.userHandler.RegisterHandler(rw, r)
+	r.Get("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		gs.healthHandler.Readiness(rw, r)
 	})
 
-	r.Post("/api/user/login", func(rw http.ResponseWriter, r *http.Request) {
-		gs.userHandler.LoginHandler(rw, r)
+	r.Group(func(r chi.Router) {
+		r.Use(gs.limiter.Handler("auth", gs.rateLimits.Auth))
+		r.Use(gs.validator.Handler)
+
+		r.Post("/api/user/register", func(rw http.ResponseWriter, r *http.Request) {
+			gs.userHandler.RegisterHandler(rw, r)
+		})
+
+		r.Post("/api/user/login", func(rw http.ResponseWriter, r *http.Request) {
+			gs.userHandler.LoginHandler(rw, r)
+		})
 	})
 
 	return r
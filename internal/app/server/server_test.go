@@ -1,17 +1,34 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
+	"github.com/joeljunstrom/go-luhn"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/core"
+	"github.com/xbreathoflife/gophermart/internal/app/cors"
+	"github.com/xbreathoflife/gophermart/internal/app/csrf"
 	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	er "github.com/xbreathoflife/gophermart/internal/app/errors"
+	"github.com/xbreathoflife/gophermart/internal/app/openapi"
+	"github.com/xbreathoflife/gophermart/internal/app/ratelimit"
+	"github.com/xbreathoflife/gophermart/internal/app/storage/memory"
 	"github.com/xbreathoflife/gophermart/internal/app/storage/mocks"
+	"github.com/xbreathoflife/gophermart/internal/app/webhook"
+	"go.uber.org/zap"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -81,6 +98,9 @@ func TestServer_RegisterLogin(t *testing.T) {
 	userRepo := mocks.NewMockUserStorage(mockCtrl)
 	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
 	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
 
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(nil, nil).MinTimes(0)
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("goodbye")).Return(
@@ -89,7 +109,8 @@ func TestServer_RegisterLogin(t *testing.T) {
 	balanceRepo.EXPECT().InsertNewBalance(gomock.Any(), gomock.Any()).MinTimes(0)
 	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
 
-	server := NewGothServer(balanceRepo, orderRepo, userRepo, "")
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			body, err := json.Marshal(tt.userData)
@@ -108,6 +129,13 @@ func TestServer_RegisterLogin(t *testing.T) {
 	}
 }
 
+// passThroughTx runs the closure directly, the mocked storages have nothing to roll back.
+type passThroughTx struct{}
+
+func (passThroughTx) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 func checkAuth(server *gophServer, t *testing.T) *http.Cookie {
 	body, err := json.Marshal(entities.LoginRequest{Login: "hello", Password: "123456"})
 	require.NoError(t, err)
@@ -173,6 +201,9 @@ func TestServer_InsertNewOrder(t *testing.T) {
 	userRepo := mocks.NewMockUserStorage(mockCtrl)
 	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
 	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
 		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
 	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
@@ -196,8 +227,10 @@ func TestServer_InsertNewOrder(t *testing.T) {
 			Status:     "NEW",
 		}, nil).MinTimes(0)
 	orderRepo.EXPECT().InsertNewOrder(gomock.Any(), gomock.Any()).MinTimes(0)
+	orderRepo.EXPECT().InsertOrderStatusHistory(gomock.Any(), gomock.Any()).MinTimes(0)
 
-	server := NewGothServer(balanceRepo, orderRepo, userRepo, "")
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
 	cookie := checkAuth(server, t)
 
 	for _, tt := range tests {
@@ -268,16 +301,22 @@ func TestServer_GetOrders(t *testing.T) {
 	userRepo := mocks.NewMockUserStorage(mockCtrl)
 	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
 	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
 		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
 	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
 	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
 		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
 
-	orderRepo.EXPECT().GetOrdersForUser(gomock.Any(), "hello").Return(
+	orderRepo.EXPECT().GetOrdersForUser(gomock.Any(), "hello", gomock.Any()).Return(
 		orders, nil).MinTimes(0)
+	orderRepo.EXPECT().CountOrdersForUser(gomock.Any(), "hello", gomock.Any()).Return(
+		len(orders), nil).MinTimes(0)
 
-	server := NewGothServer(balanceRepo, orderRepo, userRepo, "")
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
 	cookie := checkAuth(server, t)
 
 	request := httptest.NewRequest(http.MethodGet, "/api/user/orders", bytes.NewBuffer(nil))
@@ -288,6 +327,7 @@ func TestServer_GetOrders(t *testing.T) {
 	result := w.Result()
 
 	assert.Equal(t, 200, result.StatusCode)
+	assert.Equal(t, "4", result.Header.Get("X-Total-Count"))
 
 	ordersResult, err := ioutil.ReadAll(result.Body)
 	require.NoError(t, err)
@@ -300,12 +340,263 @@ func TestServer_GetOrders(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestServer_GetOrdersPagination(t *testing.T) {
+	orderTime, err := time.Parse(time.RFC3339, "2022-04-30T20:00:00+03:00")
+	require.NoError(t, err)
+	orders := []entities.OrderModel{
+		{ID: 3, OrderNum: "9278923470", Login: "hello", UploadedAt: orderTime.Add(time.Minute), Status: "PROCESSED"},
+		{ID: 2, OrderNum: "12345678903", Login: "hello", UploadedAt: orderTime, Status: "PROCESSED"},
+		{ID: 1, OrderNum: "12345674", Login: "hello", UploadedAt: orderTime, Status: "PROCESSED"},
+	}
+	type want struct {
+		statusCode int
+		orders     int
+		nextCursor bool
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   want
+	}{
+		{
+			name:   "first page",
+			target: "/api/user/orders?limit=2&sort=desc&status=processed",
+			want:   want{statusCode: 200, orders: 2, nextCursor: true},
+		},
+		{
+			name:   "bad limit",
+			target: "/api/user/orders?limit=-1",
+			want:   want{statusCode: 400},
+		},
+		{
+			name:   "bad status",
+			target: "/api/user/orders?status=REGISTERED",
+			want:   want{statusCode: 400},
+		},
+		{
+			name:   "bad cursor",
+			target: "/api/user/orders?cursor=???",
+			want:   want{statusCode: 400},
+		},
+		{
+			name:   "bad date",
+			target: "/api/user/orders?from=yesterday",
+			want:   want{statusCode: 400},
+		},
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+
+	expectedQuery := entities.ListQuery{Limit: 3, Statuses: []string{"PROCESSED"}, Desc: true}
+	orderRepo.EXPECT().GetOrdersForUser(gomock.Any(), "hello", expectedQuery).Return(orders, nil)
+	orderRepo.EXPECT().CountOrdersForUser(gomock.Any(), "hello", expectedQuery).Return(len(orders), nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			h := server.ServerHandler()
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			if tt.want.statusCode == http.StatusOK {
+				var actualOrders []entities.OrderResponse
+				err = json.NewDecoder(result.Body).Decode(&actualOrders)
+				require.NoError(t, err)
+				assert.Len(t, actualOrders, tt.want.orders)
+				assert.Equal(t, "3", result.Header.Get("X-Total-Count"))
+				assert.Equal(t, tt.want.nextCursor, result.Header.Get("X-Next-Cursor") != "")
+			}
+
+			err = result.Body.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestServer_GetOrder(t *testing.T) {
+	orderTime, err := time.Parse(time.RFC3339, "2022-04-30T20:00:00+03:00")
+	require.NoError(t, err)
+	accrual := 500.0
+	type want struct {
+		statusCode int
+		order      *entities.OrderDetailResponse
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   want
+	}{
+		{
+			name:   "order with history",
+			target: "/api/user/orders/9278923470",
+			want: want{
+				statusCode: 200,
+				order: &entities.OrderDetailResponse{
+					OrderResponse: entities.OrderResponse{
+						OrderNum:   "9278923470",
+						UploadedAt: "2022-04-30T20:00:00+03:00",
+						Status:     "PROCESSED",
+						Accrual:    &accrual,
+					},
+					History: []entities.OrderStatusHistoryResponse{
+						{Status: "NEW", ChangedAt: "2022-04-30T20:00:00+03:00"},
+						{Status: "PROCESSING", ChangedAt: "2022-04-30T20:01:00+03:00"},
+						{Status: "PROCESSED", Accrual: &accrual, ChangedAt: "2022-04-30T20:02:00+03:00"},
+					},
+				},
+			},
+		},
+		{
+			name:   "order of another user",
+			target: "/api/user/orders/562246784655",
+			want:   want{statusCode: 404},
+		},
+		{
+			name:   "unknown order",
+			target: "/api/user/orders/2377225624",
+			want:   want{statusCode: 404},
+		},
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "9278923470").Return(
+		&entities.OrderModel{OrderNum: "9278923470", Login: "hello", UploadedAt: orderTime, Status: "PROCESSED",
+			Accrual: sql.NullFloat64{Float64: accrual, Valid: true}}, nil)
+	orderRepo.EXPECT().GetOrderStatusHistory(gomock.Any(), "9278923470").Return(
+		[]entities.OrderStatusHistoryModel{
+			{OrderNum: "9278923470", Status: "NEW", ChangedAt: orderTime},
+			{OrderNum: "9278923470", Status: "PROCESSING", ChangedAt: orderTime.Add(time.Minute)},
+			{OrderNum: "9278923470", Status: "PROCESSED", ChangedAt: orderTime.Add(2 * time.Minute),
+				Accrual: sql.NullFloat64{Float64: accrual, Valid: true}},
+		}, nil)
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "562246784655").Return(
+		&entities.OrderModel{OrderNum: "562246784655", Login: "goodbye", UploadedAt: orderTime, Status: "NEW"}, nil)
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "2377225624").Return(nil, nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			h := server.ServerHandler()
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			if tt.want.order != nil {
+				var actual entities.OrderDetailResponse
+				err = json.NewDecoder(result.Body).Decode(&actual)
+				require.NoError(t, err)
+				assert.Equal(t, *tt.want.order, actual)
+			}
+
+			err = result.Body.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestServer_DeleteOrder(t *testing.T) {
+	orderTime, err := time.Parse(time.RFC3339, "2022-04-30T20:00:00+03:00")
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		target     string
+		statusCode int
+	}{
+		{name: "cancel processing order", target: "/api/user/orders/12345678903", statusCode: 204},
+		{name: "cancel processed order", target: "/api/user/orders/9278923470", statusCode: 409},
+		{name: "cancel order of another user", target: "/api/user/orders/562246784655", statusCode: 404},
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "12345678903").Return(
+		&entities.OrderModel{OrderNum: "12345678903", Login: "hello", UploadedAt: orderTime, Status: "PROCESSING"}, nil)
+	orderRepo.EXPECT().DeleteOrder(gomock.Any(), "12345678903", "PROCESSING").Return(true, nil)
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "9278923470").Return(
+		&entities.OrderModel{OrderNum: "9278923470", Login: "hello", UploadedAt: orderTime, Status: "PROCESSED"}, nil)
+	orderRepo.EXPECT().GetOrderIfExists(gomock.Any(), "562246784655").Return(
+		&entities.OrderModel{OrderNum: "562246784655", Login: "goodbye", UploadedAt: orderTime, Status: "NEW"}, nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodDelete, tt.target, nil)
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			h := server.ServerHandler()
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+
+			err = result.Body.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
 func TestServer_GetBalance(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	userRepo := mocks.NewMockUserStorage(mockCtrl)
 	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
 	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
 		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
 	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
@@ -315,7 +606,8 @@ func TestServer_GetBalance(t *testing.T) {
 	expectedBalance := entities.BalanceModel{Login: "hello", Balance: 510.5, Spent: 330}
 	balanceRepo.EXPECT().GetBalance(gomock.Any(), gomock.Eq("hello")).Return(&expectedBalance, nil)
 
-	server := NewGothServer(balanceRepo, orderRepo, userRepo, "")
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
 	cookie := checkAuth(server, t)
 
 	request := httptest.NewRequest(http.MethodGet, "/api/user/balance", bytes.NewBuffer(nil))
@@ -372,20 +664,29 @@ func TestServer_Withdrawals(t *testing.T) {
 	userRepo := mocks.NewMockUserStorage(mockCtrl)
 	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
 	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
 	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
 		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
 	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
 	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
 		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
 
-	expectedBalance := entities.BalanceModel{Login: "hello", Balance: 510.5, Spent: 330}
-	balanceRepo.EXPECT().GetBalance(gomock.Any(), gomock.Eq("hello")).Return(&expectedBalance, nil)
-	balanceRepo.EXPECT().UpdateBalance(gomock.Any(), entities.BalanceModel{Login: "hello", Balance: 10, Spent: 830.5})
-	balanceRepo.EXPECT().InsertNewBalanceWithdrawals(gomock.Any(), gomock.Any())
-	balanceRepo.EXPECT().GetBalanceWithdrawalsForUser(gomock.Any(), "hello").Return(
+	balanceRepo.EXPECT().WithdrawBalance(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+			assert.Equal(t, "hello", withdrawal.Login)
+			assert.Equal(t, 500.5, withdrawal.Sum)
+			require.Len(t, events, 1)
+			assert.Equal(t, "balance.withdrawn", events[0].EventType)
+			return &entities.BalanceModel{Login: "hello", Balance: 10, Spent: 830.5}, nil
+		})
+	balanceRepo.EXPECT().GetBalanceWithdrawalsForUser(gomock.Any(), "hello", gomock.Any()).Return(
 		[]entities.BalanceWithdrawalsModel{{Login: "hello", OrderNum: "2377225624", Sum: 500.5, ProcessedAt: processedAt}}, nil)
+	balanceRepo.EXPECT().CountBalanceWithdrawalsForUser(gomock.Any(), "hello", gomock.Any()).Return(1, nil)
 
-	server := NewGothServer(balanceRepo, orderRepo, userRepo, "")
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
 	cookie := checkAuth(server, t)
 
 	for _, tt := range tests {
@@ -420,4 +721,766 @@ func TestServer_Withdrawals(t *testing.T) {
 			require.NoError(t, err)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestServer_IdempotentWithdraw(t *testing.T) {
+	body, err := json.Marshal(entities.BalanceWithdrawRequest{Order: "2377225624", Sum: 500.5})
+	require.NoError(t, err)
+	otherBody, err := json.Marshal(entities.BalanceWithdrawRequest{Order: "2377225624", Sum: 10})
+	require.NoError(t, err)
+	requestHash := core.HashRequest(http.MethodPost, "/api/user/balance/withdraw", body)
+
+	type want struct {
+		statusCode int
+		replayed   bool
+	}
+	tests := []struct {
+		name string
+		body []byte
+		want want
+	}{
+		{name: "first request", body: body, want: want{statusCode: 200}},
+		{name: "repeated request", body: body, want: want{statusCode: 200, replayed: true}},
+		{name: "reused key with another body", body: otherBody, want: want{statusCode: 422}},
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+
+	balanceRepo.EXPECT().WithdrawBalance(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		&entities.BalanceModel{Login: "hello", Balance: 10, Spent: 830.5}, nil).Times(1)
+
+	gomock.InOrder(
+		idempotencyRepo.EXPECT().InsertIdempotencyKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil),
+		idempotencyRepo.EXPECT().CommitIdempotencyKey(gomock.Any(), gomock.Any()).Return(true, nil),
+		idempotencyRepo.EXPECT().UpdateIdempotencyKey(gomock.Any(), gomock.Any()).Return(nil),
+	)
+	idempotencyRepo.EXPECT().InsertIdempotencyKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).Times(2)
+	idempotencyRepo.EXPECT().GetIdempotencyKey(gomock.Any(), "hello", "key-1").Return(
+		&entities.IdempotencyModel{Login: "hello", Key: "key-1", RequestHash: requestHash, StatusCode: 200}, nil).Times(2)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewBuffer(tt.body))
+			request.Header.Set("Idempotency-Key", "key-1")
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			h := server.ServerHandler()
+			h.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Equal(t, tt.want.replayed, result.Header.Get("Idempotent-Replayed") == "true")
+
+			err = result.Body.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+	balanceRepo.EXPECT().WithdrawBalance(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		&entities.BalanceModel{Login: "hello", Balance: 10, Spent: 830.5}, nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+	ts := httptest.NewServer(server.ServerHandler())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/user/events", nil)
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	stream, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+
+	body, err := json.Marshal(entities.BalanceWithdrawRequest{Order: "2377225624", Sum: 500.5})
+	require.NoError(t, err)
+	request, err = http.NewRequest(http.MethodPost, ts.URL+"/api/user/balance/withdraw", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	assert.Equal(t, 200, result.StatusCode)
+	err = result.Body.Close()
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(stream.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^id: \w+-1$`, lines[0])
+	assert.Equal(t, []string{"event: balance.changed", `data: {"current":10,"withdrawn":830.5}`}, lines[1:])
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	receivedBody := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		receivedBody <- b
+	}))
+	defer receiver.Close()
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	orderRepo := mocks.NewMockOrderStorage(mockCtrl)
+	idempotencyRepo := mocks.NewMockIdempotencyStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil).MinTimes(0)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any()).MinTimes(0)
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).MinTimes(0)
+
+	var secret string
+	webhookRepo.EXPECT().InsertWebhook(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, webhook entities.WebhookModel) (int64, error) {
+			secret = webhook.Secret
+			assert.Equal(t, []string{"balance.withdrawn"}, webhook.EventTypes)
+			return 7, nil
+		})
+	webhookRepo.EXPECT().GetWebhookIfExists(gomock.Any(), int64(7)).DoAndReturn(
+		func(_ context.Context, _ int64) (*entities.WebhookModel, error) {
+			return &entities.WebhookModel{ID: 7, Login: "hello", URL: receiver.URL, Secret: secret,
+				EventTypes: []string{"balance.withdrawn"}}, nil
+		})
+	webhookRepo.EXPECT().InsertWebhookDelivery(gomock.Any(), gomock.Any()).Return(int64(1), nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, Order: orderRepo, User: userRepo,
+		Idempotency: idempotencyRepo, Webhook: webhookRepo, Tx: passThroughTx{}},
+		Options{Webhooks: core.WebhookConfig{AllowPrivateNetworks: true}}, zap.NewNop())
+	cookie := checkAuth(server, t)
+	h := server.ServerHandler()
+
+	body, err := json.Marshal(entities.WebhookRequest{URL: receiver.URL, EventTypes: []string{"balance.withdrawn"}})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", bytes.NewBuffer(body))
+	request.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	result := w.Result()
+	assert.Equal(t, 201, result.StatusCode)
+	var created entities.WebhookResponse
+	err = json.NewDecoder(result.Body).Decode(&created)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), created.ID)
+	assert.Equal(t, secret, created.Secret)
+	err = result.Body.Close()
+	require.NoError(t, err)
+
+	request = httptest.NewRequest(http.MethodPost, "/api/user/webhooks/7/test", nil)
+	request.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	result = w.Result()
+	assert.Equal(t, 200, result.StatusCode)
+	var delivery entities.WebhookDeliveryResponse
+	err = json.NewDecoder(result.Body).Decode(&delivery)
+	require.NoError(t, err)
+	assert.True(t, delivery.Success)
+	err = result.Body.Close()
+	require.NoError(t, err)
+
+	r := <-received
+	b := <-receivedBody
+	assert.Equal(t, "webhook.test", r.Header.Get("X-Gophermart-Event"))
+	expectedSignature := "sha256=" + webhook.SignPayload(secret, r.Header.Get("X-Gophermart-Timestamp"), b)
+	assert.Equal(t, expectedSignature, r.Header.Get("X-Gophermart-Signature"))
+
+	request = httptest.NewRequest(http.MethodPost, "/api/user/webhooks", bytes.NewBufferString(`{"url":"ftp://example.com"}`))
+	request.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	result = w.Result()
+	assert.Equal(t, 400, result.StatusCode)
+	err = result.Body.Close()
+	require.NoError(t, err)
+}
+
+func TestServer_WebhooksPrivateNetworks(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewBufferString(`{"login":"hello","password":"123456"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	auth := w.Result().Cookies()[0]
+	cookie := &http.Cookie{Name: auth.Name, Value: auth.Value}
+
+	for _, url := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:5432", "http://10.0.0.1/", "http://[::1]/"} {
+		body, err := json.Marshal(entities.WebhookRequest{URL: url})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", bytes.NewBuffer(body))
+		request.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		result := w.Result()
+		assert.Equal(t, 400, result.StatusCode, url)
+		require.NoError(t, result.Body.Close())
+	}
+}
+
+func TestServer_InMemoryStorage(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+
+	do := func(method string, target string, body string, cookie *http.Cookie) *http.Response {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		result := w.Result()
+		require.NoError(t, result.Body.Close())
+		return result
+	}
+
+	result := do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"123456"}`, nil)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	auth := result.Cookies()[0]
+	cookie := &http.Cookie{Name: auth.Name, Value: auth.Value}
+
+	result = do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"654321"}`, nil)
+	assert.Equal(t, http.StatusConflict, result.StatusCode)
+
+	result = do(http.MethodPost, "/api/user/orders", "2377225624", cookie)
+	assert.Equal(t, http.StatusAccepted, result.StatusCode)
+	result = do(http.MethodPost, "/api/user/orders", "2377225624", cookie)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	request.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	var orders []entities.OrderResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &orders))
+	require.Len(t, orders, 1)
+	assert.Equal(t, "2377225624", orders[0].OrderNum)
+	assert.Equal(t, core.NewStatus, orders[0].Status)
+
+	result = do(http.MethodPost, "/api/user/balance/withdraw", `{"order":"2377225624","sum":10}`, cookie)
+	assert.Equal(t, http.StatusPaymentRequired, result.StatusCode)
+}
+
+func TestServer_GetOrdersUnbounded(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+
+	do := func(method string, target string, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"123456"}`, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	auth := w.Result().Cookies()[0]
+	cookie := &http.Cookie{Name: auth.Name, Value: auth.Value}
+
+	const count = core.DefaultPageLimit + 20
+	uploaded := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		orderNum := luhn.GenerateWithPrefix(10, fmt.Sprintf("%04d", i))
+		w = do(http.MethodPost, "/api/user/orders", orderNum, cookie)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		uploaded = append(uploaded, orderNum)
+	}
+
+	w = do(http.MethodGet, "/api/user/orders", "", cookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	var orders []entities.OrderResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &orders))
+	var got []string
+	for _, o := range orders {
+		got = append(got, o.OrderNum)
+	}
+	assert.ElementsMatch(t, uploaded, got)
+	assert.Empty(t, w.Header().Get("X-Next-Cursor"))
+
+	w = do(http.MethodGet, "/api/user/orders?limit=50", "", cookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	orders = nil
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &orders))
+	assert.Len(t, orders, 50)
+	assert.NotEmpty(t, w.Header().Get("X-Next-Cursor"))
+}
+
+func TestServer_Health(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+
+	get := func(target string) (int, entities.ReadinessResponse) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var response entities.ReadinessResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	statusCode, response := get("/healthz")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, core.HealthOK, response.Status)
+
+	statusCode, response = get("/readyz")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, core.HealthOK, response.Status)
+	assert.Equal(t, core.HealthOK, response.Checks["database"].Status)
+	assert.Equal(t, core.HealthOK, response.Checks["migrations"].Status)
+	assert.Equal(t, core.HealthDisabled, response.Checks["accrual_worker"].Status)
+	assert.Equal(t, core.CircuitClosed, response.Checks["accrual_circuit"].State)
+
+	server.Shutdown()
+	statusCode, response = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, core.HealthFailing, response.Status)
+	assert.Equal(t, core.HealthFailing, response.Checks["shutdown"].Status)
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{RateLimits: RateLimits{
+		Auth:   ratelimit.Limit{Requests: 3, Window: time.Minute},
+		Orders: ratelimit.Limit{Requests: 1, Window: time.Minute},
+	}}, zap.NewNop())
+	h := server.ServerHandler()
+
+	do := func(method string, target string, body string, cookie *http.Cookie) *http.Response {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		result := w.Result()
+		require.NoError(t, result.Body.Close())
+		return result
+	}
+	session := func(result *http.Response) *http.Cookie {
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		return &http.Cookie{Name: result.Cookies()[0].Name, Value: result.Cookies()[0].Value}
+	}
+
+	session(do(http.MethodPost, "/api/user/register", `{"login":"first","password":"123456"}`, nil))
+	second := session(do(http.MethodPost, "/api/user/register", `{"login":"second","password":"123456"}`, nil))
+	first := session(do(http.MethodPost, "/api/user/login", `{"login":"first","password":"123456"}`, nil))
+	result := do(http.MethodPost, "/api/user/login", `{"login":"first","password":"123456"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode, "auth routes are limited by IP")
+
+	result = do(http.MethodPost, "/api/user/orders", "2377225624", first)
+	assert.Equal(t, http.StatusAccepted, result.StatusCode)
+	assert.Equal(t, "0", result.Header.Get(ratelimit.RemainingHeader))
+
+	result = do(http.MethodPost, "/api/user/orders", "12345678903", first)
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.NotEmpty(t, result.Header.Get("Retry-After"))
+
+	result = do(http.MethodPost, "/api/user/orders", "12345678903", second)
+	assert.Equal(t, http.StatusAccepted, result.StatusCode, "users on the same IP have their own budgets")
+
+	result = do(http.MethodGet, "/api/user/orders", "", first)
+	assert.Equal(t, http.StatusOK, result.StatusCode, "other routes have their own budget")
+}
+
+func TestServer_RateLimitSessionLookup(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any())
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).Times(1)
+	balanceRepo.EXPECT().GetBalance(gomock.Any(), gomock.Eq("hello")).Return(&entities.BalanceModel{Login: "hello"}, nil)
+
+	server := NewGothServer(Storages{Balance: balanceRepo, User: userRepo, Webhook: webhookRepo, Tx: passThroughTx{}},
+		Options{
+			RateLimits:     RateLimits{API: ratelimit.Limit{Requests: 10, Window: time.Minute}},
+			TrustedProxies: []*net.IPNet{{IP: net.IPv4(192, 0, 2, 0), Mask: net.CIDRMask(24, 32)}},
+		}, zap.NewNop())
+	cookie := checkAuth(server, t)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+	request.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	server.ServerHandler().ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code, "the rate limiter and the handler share one session lookup")
+
+	// the client picks the address in X-Forwarded-For, it is only trusted from proxies
+	do := func(remoteAddr string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewBufferString(`{`))
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		server.ServerHandler().ServeHTTP(w, request)
+		return w.Code
+	}
+	server.rateLimits.Auth = ratelimit.Limit{Requests: 1, Window: time.Minute}
+	assert.Equal(t, http.StatusBadRequest, do("198.51.100.1:1234"))
+	assert.Equal(t, http.StatusBadRequest, do("192.0.2.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.2:1234"), "both proxies forward the same client")
+	assert.Equal(t, http.StatusTooManyRequests, do("198.51.100.1:4321"))
+}
+
+func TestServer_CSRF(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{CORS: cors.Config{AllowedOrigins: []string{"https://app.example.com"}}}, zap.NewNop())
+	h := server.ServerHandler()
+
+	do := func(method string, target string, body string, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		request.Header.Set("Origin", "https://app.example.com")
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		if token != "" {
+			request.Header.Set(csrf.HeaderName, token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"123456"}`, nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	cookie := &http.Cookie{Name: w.Result().Cookies()[0].Name, Value: w.Result().Cookies()[0].Value}
+
+	w = do(http.MethodPost, "/api/user/orders", "2377225624", cookie, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(http.MethodGet, "/api/user/csrf", "", cookie, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var token entities.CSRFTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &token))
+	assert.Equal(t, token.Token, w.Header().Get(csrf.HeaderName))
+
+	w = do(http.MethodPost, "/api/user/orders", "2377225624", cookie, token.Token)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// API clients of the v1 contract send no token and no browser headers
+	request := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewBufferString("12345678903"))
+	request.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	strict := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{CSRF: csrf.Config{Mode: csrf.ModeAlways}}, zap.NewNop())
+	request = httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewBufferString("9278923470"))
+	request.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	strict.ServerHandler().ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the always mode is opt-in")
+}
+
+func TestServer_Partners(t *testing.T) {
+	mem := memory.NewStorage()
+	stores := Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem, Tx: mem, Health: mem}
+
+	w := httptest.NewRecorder()
+	NewGothServer(stores, Options{}, zap.NewNop()).ServerHandler().
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/partner/orders/2377225624", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "partner routes are not mounted by default")
+
+	w = httptest.NewRecorder()
+	NewGothServer(stores, Options{Partners: Partners{Enabled: true}}, zap.NewNop()).ServerHandler().
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/partner/orders/2377225624", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "partner routes require a client certificate")
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{Partners: Partners{Enabled: true}}, zap.NewNop())
+	h := server.ServerHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, OpenAPIPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+
+	routes := 0
+	err := chi.Walk(h, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes++
+		assert.NotNil(t, doc.Operation(route, strings.ToLower(method)), "%s %s is not documented", method, route)
+		return nil
+	})
+	require.NoError(t, err)
+	operations := 0
+	for _, item := range doc.Paths {
+		operations += len(*item)
+	}
+	assert.Equal(t, routes, operations, "documented operations which are not routed")
+
+	result := func(method string, target string, contentType string, body string) (int, entities.ErrorResponse) {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		var response entities.ErrorResponse
+		if w.Code >= http.StatusBadRequest {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w.Code, response
+	}
+
+	statusCode, response := result(http.MethodPost, "/api/user/register", "application/json", `{"login":"hello"}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.Equal(t, er.CodeInvalidRequest, response.Error.Code)
+	assert.Equal(t, []entities.FieldError{{Field: "body.password", Message: "is required"}}, response.Error.Details)
+
+	statusCode, response = result(http.MethodPost, "/api/user/register", "text/plain", `{"login":"hello","password":"123456"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, statusCode)
+	assert.Equal(t, "header.Content-Type", response.Error.Details[0].Field)
+
+	statusCode, _ = result(http.MethodPost, "/api/user/register", "application/json; charset=utf-8", `{"login":"hello","password":"123456"}`)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestServer_Errors(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+
+	do := func(method string, target string, body string, cookie *http.Cookie) (*http.Response, entities.ErrorResponse) {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		var response entities.ErrorResponse
+		if w.Code >= http.StatusBadRequest {
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w.Result(), response
+	}
+
+	result, response := do(http.MethodPost, "/api/user/login", `{"login":"hello","password":"123456"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(t, er.CodeUnauthorized, response.Error.Code)
+
+	result, response = do(http.MethodGet, "/api/user/balance", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(t, er.CodeUnauthorized, response.Error.Code)
+	result, response = do(http.MethodGet, "/api/user/balance", "", &http.Cookie{Name: "authorization", Value: "forged"})
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(t, er.CodeUnauthorized, response.Error.Code)
+	assert.Equal(t, "Invalid session", response.Error.Message)
+
+	result, _ = do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"123456"}`, nil)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	auth := result.Cookies()[0]
+	cookie := &http.Cookie{Name: auth.Name, Value: auth.Value}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		statusCode int
+		code       string
+	}{
+		{name: "taken login", method: http.MethodPost, target: "/api/user/register", body: `{"login":"hello","password":"1"}`,
+			statusCode: http.StatusConflict, code: er.CodeAlreadyExists},
+		{name: "luhn", method: http.MethodPost, target: "/api/user/orders", body: "123",
+			statusCode: http.StatusUnprocessableEntity, code: er.CodeInvalidOrderNumber},
+		{name: "order not found", method: http.MethodGet, target: "/api/user/orders/2377225624",
+			statusCode: http.StatusNotFound, code: er.CodeNotFound},
+		{name: "insufficient funds", method: http.MethodPost, target: "/api/user/balance/withdraw", body: `{"order":"2377225624","sum":10}`,
+			statusCode: http.StatusPaymentRequired, code: er.CodeInsufficientFunds},
+		{name: "negative sum", method: http.MethodPost, target: "/api/user/balance/withdraw", body: `{"order":"2377225624","sum":-10}`,
+			statusCode: http.StatusBadRequest, code: er.CodeInvalidRequest},
+		{name: "bad cursor", method: http.MethodGet, target: "/api/user/orders?cursor=!",
+			statusCode: http.StatusBadRequest, code: er.CodeInvalidRequest},
+		{name: "webhook not found", method: http.MethodDelete, target: "/api/user/webhooks/7",
+			statusCode: http.StatusNotFound, code: er.CodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, response := do(tt.method, tt.target, tt.body, cookie)
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			assert.Equal(t, tt.code, response.Error.Code)
+			assert.NotEmpty(t, response.Error.Message)
+		})
+	}
+
+	result, _ = do(http.MethodPost, "/api/user/orders", "2377225624", cookie)
+	require.Equal(t, http.StatusAccepted, result.StatusCode)
+	result, response = do(http.MethodPost, "/api/user/balance/withdraw", `{"order":"2377225624","sum":10}`, cookie)
+	assert.Equal(t, http.StatusPaymentRequired, result.StatusCode)
+	assert.Equal(t, er.CodeInsufficientFunds, response.Error.Code)
+}
+
+func TestServer_InternalErrorsAreHidden(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	userRepo := mocks.NewMockUserStorage(mockCtrl)
+	balanceRepo := mocks.NewMockBalanceStorage(mockCtrl)
+	webhookRepo := mocks.NewMockWebhookStorage(mockCtrl)
+	webhookRepo.EXPECT().GetWebhooksForUser(gomock.Any(), gomock.Any()).AnyTimes()
+	userRepo.EXPECT().GetUserIfExists(gomock.Any(), gomock.Eq("hello")).Return(
+		&entities.UserModel{Login: "hello", PasswordHash: "123456", Session: "123"}, nil)
+	userRepo.EXPECT().UpdateUserSession(gomock.Any(), gomock.Any())
+	userRepo.EXPECT().GetUserBySessionIfExists(gomock.Any(), gomock.Any()).Return(
+		&entities.UserSessionModel{Login: "hello", Session: "123"}, nil).AnyTimes()
+	balanceRepo.EXPECT().GetBalance(gomock.Any(), "hello").Return(nil, errors.New("pq: relation \"balance\" does not exist"))
+
+	server := NewGothServer(Storages{Balance: balanceRepo, User: userRepo, Webhook: webhookRepo,
+		Tx: passThroughTx{}}, Options{}, zap.NewNop())
+	cookie := checkAuth(server, t)
+	request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+	request.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	server.ServerHandler().ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var response entities.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, er.CodeInternal, response.Error.Code)
+	assert.NotContains(t, w.Body.String(), "relation")
+}
+
+func TestServer_V2(t *testing.T) {
+	mem := memory.NewStorage()
+	server := NewGothServer(Storages{Balance: mem, Order: mem, User: mem, Idempotency: mem, Webhook: mem,
+		Tx: mem, Health: mem}, Options{}, zap.NewNop())
+	h := server.ServerHandler()
+
+	var cookie *http.Cookie
+	do := func(method string, target string, body string, v interface{}) int {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+		if w.Code == http.StatusOK && cookie == nil {
+			auth := w.Result().Cookies()[0]
+			cookie = &http.Cookie{Name: auth.Name, Value: auth.Value}
+		}
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/user/register", `{"login":"hello","password":"123456"}`, nil))
+
+	var page struct {
+		Data       []entities.OrderV2    `json:"data"`
+		Pagination entities.PaginationV2 `json:"pagination"`
+	}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/orders", "", &page))
+	assert.NotNil(t, page.Data)
+	assert.Empty(t, page.Data)
+	assert.Equal(t, entities.PaginationV2{Total: 0, Limit: core.DefaultPageLimit}, page.Pagination)
+
+	var order entities.OrderV2
+	assert.Equal(t, http.StatusAccepted, do(http.MethodPost, "/api/v2/orders", `{"number":"2377225624"}`, &order))
+	assert.Equal(t, "2377225624", order.Number)
+	assert.Equal(t, core.NewStatus, order.Status)
+	assert.Nil(t, order.Accrual)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/v2/orders", `{"number":"2377225624"}`, &order))
+
+	var errResponse entities.ErrorResponse
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v2/orders", `{"number":"heh"}`, &errResponse))
+	assert.Equal(t, "body.number", errResponse.Error.Details[0].Field)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/orders?limit=1", "", &page))
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, entities.PaginationV2{Total: 1, Limit: 1}, page.Pagination)
+
+	var detail entities.OrderDetailV2
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/orders/2377225624", "", &detail))
+	require.Len(t, detail.History, 1)
+	assert.Equal(t, core.NewStatus, detail.History[0].Status)
+
+	var balance entities.BalanceV2
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/balance", "", &balance))
+	assert.Equal(t, entities.BalanceV2{Current: core.NewMoney(0), Withdrawn: core.NewMoney(0)}, balance)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v2/balance/withdrawals",
+		`{"order":"2377225624","sum":{"value":1050,"currency":"USD","scale":2}}`, &errResponse))
+	assert.Equal(t, "body.sum.currency", errResponse.Error.Details[0].Field)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v2/balance/withdrawals",
+		`{"order":"2377225624","sum":{"value":1050,"currency":"POINTS","scale":3}}`, &errResponse))
+	assert.Equal(t, "body.sum.scale", errResponse.Error.Details[0].Field)
+	assert.Equal(t, http.StatusPaymentRequired, do(http.MethodPost, "/api/v2/balance/withdrawals",
+		`{"order":"2377225624","sum":{"value":1050,"currency":"POINTS","scale":2}}`, &errResponse))
+	assert.Equal(t, er.CodeInsufficientFunds, errResponse.Error.Code)
+
+	var withdrawals struct {
+		Data []entities.WithdrawalV2 `json:"data"`
+	}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/balance/withdrawals", "", &withdrawals))
+	assert.NotNil(t, withdrawals.Data)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/balance/withdrawals", "", nil))
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v2/orders/2377225624", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v2/orders/2377225624", "", &errResponse))
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/xbreathoflife/gophermart/internal/app/auth"
+	"net/http"
+)
+
+// routeV2 mounts the v2 API, which shares authentication, protections and
+// budgets with the v1 user routes.
+func (gs *gophServer) routeV2(r chi.Router) {
+	// grouped, as the validator needs the route pattern, which is only known
+	// to middlewares of the routes of the mounted router
+	r.Group(func(r chi.Router) {
+		r.Use(auth.CheckAuth)
+		r.Use(gs.csrf.Handler)
+		r.Use(auth.ResolveUser(gs.userService.GetUserBySession))
+		r.Use(gs.limiter.Handler("api", gs.rateLimits.API))
+		r.Use(gs.validator.Handler)
+
+		r.With(gs.limiter.Handler("orders", gs.rateLimits.Orders), gs.idempotencyHandler.Middleware).Post("/orders", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.PostOrder(rw, r)
+		})
+
+		r.Get("/orders", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.GetOrders(rw, r)
+		})
+
+		r.Get("/orders/{number}", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.GetOrder(rw, r)
+		})
+
+		r.Delete("/orders/{number}", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.DeleteOrder(rw, r)
+		})
+
+		r.Get("/balance", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.GetBalance(rw, r)
+		})
+
+		r.With(gs.idempotencyHandler.Middleware).Post("/balance/withdrawals", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.PostWithdrawal(rw, r)
+		})
+
+		r.Get("/balance/withdrawals", func(rw http.ResponseWriter, r *http.Request) {
+			gs.v2Handler.GetWithdrawals(rw, r)
+		})
+	})
+}
@@ -10,40 +10,30 @@ type BalanceStorage interface {
 	InsertNewBalance(ctx context.Context, balance entities.BalanceModel) error
 	InsertNewBalanceWithdrawals(ctx context.Context, balanceWithdrawals entities.BalanceWithdrawalsModel) error
 	UpdateBalance(ctx context.Context, balance entities.BalanceModel) error
+	WithdrawBalance(ctx context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error)
 	GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error)
-	GetBalanceWithdrawalsForUser(ctx context.Context, login string) ([]entities.BalanceWithdrawalsModel, error)
+	GetBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsModel, error)
+	CountBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) (int, error)
 }
 
 type BalanceStorageImpl struct {
-	ConnString string
+	DB *sql.DB
 }
 
-func NewBalanceStorage(connString string) *BalanceStorageImpl {
-	storage := &BalanceStorageImpl{ConnString: connString}
+func NewBalanceStorage(db *sql.DB) *BalanceStorageImpl {
+	storage := &BalanceStorageImpl{DB: db}
 	return storage
 }
 
 func (s *BalanceStorageImpl) InsertNewBalance(ctx context.Context, balance entities.BalanceModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`INSERT INTO balance(login) VALUES ($1)`, balance.Login)
 
 	return err
 }
 
 func (s *BalanceStorageImpl) InsertNewBalanceWithdrawals(ctx context.Context, balanceWithdrawals entities.BalanceWithdrawalsModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`INSERT INTO balance_withdrawals(login, order_num, sum, processed_at) VALUES ($1, $2, $3, $4)`,
 		balanceWithdrawals.Login, balanceWithdrawals.OrderNum, balanceWithdrawals.Sum, balanceWithdrawals.ProcessedAt)
 
@@ -51,30 +41,51 @@ func (s *BalanceStorageImpl) InsertNewBalanceWithdrawals(ctx context.Context, ba
 }
 
 func (s *BalanceStorageImpl) UpdateBalance(ctx context.Context, balance entities.BalanceModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`UPDATE balance SET balance = $1, spent = $2 WHERE login = $3`,
 		balance.Balance, balance.Spent, balance.Login)
 
 	return err
 }
 
-func (s *BalanceStorageImpl) GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return nil, err
-	}
+// WithdrawBalance charges the sum, records the withdrawal and its outbox events
+// in one transaction. It returns nil if the balance is not sufficient.
+func (s *BalanceStorageImpl) WithdrawBalance(ctx context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	var balance *entities.BalanceModel
+	err := withTx(ctx, s.DB, func(tx *sql.Tx) error {
+		b := entities.BalanceModel{Login: withdrawal.Login}
+		row := tx.QueryRowContext(ctx,
+			`UPDATE balance SET balance = balance - $1, spent = spent + $1 WHERE login = $2 AND balance >= $1
+					RETURNING balance, spent`,
+			withdrawal.Sum, withdrawal.Login)
+		if err := row.Scan(&b.Balance, &b.Spent); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO balance_withdrawals(login, order_num, sum, processed_at) VALUES ($1, $2, $3, $4)`,
+			withdrawal.Login, withdrawal.OrderNum, withdrawal.Sum, withdrawal.ProcessedAt)
+		if err != nil {
+			return err
+		}
+		if err := insertOutboxEvents(ctx, tx, events); err != nil {
+			return err
+		}
+		balance = &b
+		return nil
+	})
 
-	defer conn.Close()
+	return balance, err
+}
+
+func (s *BalanceStorageImpl) GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
 	var balance entities.BalanceModel
-	row := conn.QueryRowContext(ctx,
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
 		`SELECT login, balance, spent FROM balance WHERE login = $1`, login)
-	err = row.Scan(&balance.Login, &balance.Balance, &balance.Spent)
+	err := row.Scan(&balance.Login, &balance.Balance, &balance.Spent)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -86,30 +97,37 @@ func (s *BalanceStorageImpl) GetBalance(ctx context.Context, login string) (*ent
 	return &balance, nil
 }
 
-func (s *BalanceStorageImpl) GetBalanceWithdrawalsForUser(ctx context.Context, login string) ([]entities.BalanceWithdrawalsModel, error) {
-	conn, err := connect(ctx, s.ConnString)
+func (s *BalanceStorageImpl) GetBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsModel, error) {
+	f := newListFilter(login)
+	f.dateRange("processed_at", query)
+	f.after("processed_at", query)
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`SELECT id, login, order_num, sum, processed_at FROM balance_withdrawals 
+				WHERE `+f.where()+` `+orderBy("processed_at", query)+` `+limit(f, query), f.args...)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Close()
-	rows, err := conn.QueryContext(ctx,
-		`SELECT login, order_num, sum, processed_at FROM balance_withdrawals 
-				WHERE login = $1 ORDER BY processed_at`, login)
-	if err != nil && rows.Err() != nil {
-		return nil, err
-	}
-
 	defer rows.Close()
 
 	var balances []entities.BalanceWithdrawalsModel
 	for rows.Next() {
 		var b entities.BalanceWithdrawalsModel
-		if err := rows.Scan(&b.Login, &b.OrderNum, &b.Sum, &b.ProcessedAt); err != nil {
+		if err := rows.Scan(&b.ID, &b.Login, &b.OrderNum, &b.Sum, &b.ProcessedAt); err != nil {
 			return nil, err
 		}
 		balances = append(balances, b)
 	}
 
-	return balances, nil
+	return balances, rows.Err()
+}
+
+func (s *BalanceStorageImpl) CountBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	f := newListFilter(login)
+	f.dateRange("processed_at", query)
+	var total int
+	row := executor(ctx, s.DB).QueryRowContext(ctx, `SELECT COUNT(*) FROM balance_withdrawals WHERE `+f.where(), f.args...)
+	err := row.Scan(&total)
+
+	return total, err
 }
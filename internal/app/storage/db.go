@@ -3,50 +3,116 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	_ "github.com/jackc/pgx/stdlib"
-	"log"
-	"os"
+	"errors"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/stdlib"
+	"github.com/xbreathoflife/gophermart/migrations"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
 )
 
 type CommonStorage interface {
 	Init(ctx context.Context) error
 }
 
-type DBStorage struct {
-	ConnString string
+// HealthStorage reports whether the storage is able to serve requests.
+type HealthStorage interface {
+	Ping(ctx context.Context) error
+	PendingMigrations(ctx context.Context) (int, error)
 }
 
-func NewDBStorage(connString string) *DBStorage {
-	storage := &DBStorage{ConnString: connString}
-	return storage
+// PoolConfig tunes the connection pool shared by all storages. Zero values keep
+// the database/sql defaults, a zero StatementTimeout leaves it to the server.
+type PoolConfig struct {
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	StatementTimeout time.Duration
 }
 
-func connect(_ context.Context, connString string) (*sql.DB, error) {
+// OpenDB creates the connection pool. It is created once and injected into
+// the storages, which must not close it.
+func OpenDB(connString string, pool PoolConfig) (*sql.DB, error) {
 	if connString == "" {
-		log.Fatal("Connection string is empty\n")
+		return nil, errors.New("connection string is empty")
+	}
+
+	driverConfig := &stdlib.DriverConfig{ConnConfig: pgx.ConnConfig{RuntimeParams: map[string]string{}}}
+	if pool.StatementTimeout > 0 {
+		driverConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(pool.StatementTimeout.Milliseconds(), 10)
 	}
-	conn, err := sql.Open("pgx", connString)
+	stdlib.RegisterDriverConfig(driverConfig)
+
+	db, err := sql.Open("pgx", driverConfig.ConnectionString(connString))
 	if err != nil {
-		fmt.Printf("Unable to connect to database: %v\n", err)
 		return nil, err
 	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
 
-	return conn, nil
+	return db, nil
 }
 
-func (s *DBStorage) Init(ctx context.Context) error {
-	// run migrations
-	createTableQuery, err := os.ReadFile("./migrations/2022-04-16-create-tables.sql")
+type DBStorage struct {
+	DB  *sql.DB
+	Log *zap.Logger
+}
+
+func NewDBStorage(db *sql.DB, log *zap.Logger) *DBStorage {
+	storage := &DBStorage{DB: db, Log: log}
+	return storage
+}
+
+func rowsAffected(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// withTx runs fn in a transaction which is committed if fn succeeds and rolled
+// back otherwise. Inside WithinTx it runs in the outer transaction.
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(tx)
+	}
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	conn, err := connect(ctx, s.ConnString)
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *DBStorage) Init(ctx context.Context) error {
+	migrator, err := NewMigrator(s.DB, migrations.FS)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-	_, err = conn.ExecContext(ctx, string(createTableQuery))
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		s.Log.Info("applied migration", zap.Int64("version", m.Version), zap.String("name", m.Name))
+	}
 
 	return err
-}
\ No newline at end of file
+}
+
+func (s *DBStorage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *DBStorage) PendingMigrations(ctx context.Context) (int, error) {
+	migrator, err := NewMigrator(s.DB, migrations.FS)
+	if err != nil {
+		return 0, err
+	}
+	return migrator.Pending(ctx)
+}
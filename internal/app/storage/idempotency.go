@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"time"
+)
+
+// IdempotencyStorage keeps the idempotency keys. A key expires once it was
+// created before expiredBefore, or before staleBefore while its request is
+// still in progress and its operation is not committed: an expired key is
+// taken over on insert. Updates and deletes only apply while the key is held
+// by the request with the record's token.
+type IdempotencyStorage interface {
+	InsertIdempotencyKey(ctx context.Context, record entities.IdempotencyModel, expiredBefore time.Time, staleBefore time.Time) (bool, error)
+	CommitIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) (bool, error)
+	UpdateIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error
+	DeleteIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time, staleBefore time.Time) (int64, error)
+	GetIdempotencyKey(ctx context.Context, login string, key string) (*entities.IdempotencyModel, error)
+}
+
+type IdempotencyStorageImpl struct {
+	DB *sql.DB
+}
+
+func NewIdempotencyStorage(db *sql.DB) *IdempotencyStorageImpl {
+	storage := &IdempotencyStorageImpl{DB: db}
+	return storage
+}
+
+// InsertIdempotencyKey reserves the key and reports false if it is already
+// taken and not expired.
+func (s *IdempotencyStorageImpl) InsertIdempotencyKey(ctx context.Context, record entities.IdempotencyModel, expiredBefore time.Time, staleBefore time.Time) (bool, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`INSERT INTO idempotency_keys(login, key, request_hash, created_at, token) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (login, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = 0,
+					content_type = '', body = NULL, created_at = EXCLUDED.created_at, token = EXCLUDED.token,
+					committed = FALSE
+				WHERE idempotency_keys.created_at < $6
+					OR (idempotency_keys.status_code = 0 AND NOT idempotency_keys.committed
+						AND idempotency_keys.created_at < $7)`,
+		record.Login, record.Key, record.RequestHash, record.CreatedAt, record.Token, expiredBefore, staleBefore)
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected(res)
+}
+
+// CommitIdempotencyKey marks the operation of the key as committed and reports
+// false if the request no longer holds the key. Called in the transaction of
+// the operation, the key can't be taken over once the operation is committed.
+func (s *IdempotencyStorageImpl) CommitIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) (bool, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE idempotency_keys SET committed = TRUE WHERE login = $1 AND key = $2 AND token = $3 AND status_code = 0`,
+		record.Login, record.Key, record.Token)
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected(res)
+}
+
+func (s *IdempotencyStorageImpl) UpdateIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE idempotency_keys SET status_code = $1, content_type = $2, body = $3
+				WHERE login = $4 AND key = $5 AND token = $6`,
+		record.StatusCode, record.ContentType, record.Body, record.Login, record.Key, record.Token)
+
+	return err
+}
+
+// DeleteIdempotencyKey releases the key unless its operation is committed.
+func (s *IdempotencyStorageImpl) DeleteIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE login = $1 AND key = $2 AND token = $3 AND NOT committed`,
+		record.Login, record.Key, record.Token)
+
+	return err
+}
+
+func (s *IdempotencyStorageImpl) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time, staleBefore time.Time) (int64, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE created_at < $1 OR (status_code = 0 AND NOT committed AND created_at < $2)`,
+		expiredBefore, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *IdempotencyStorageImpl) GetIdempotencyKey(ctx context.Context, login string, key string) (*entities.IdempotencyModel, error) {
+	var record entities.IdempotencyModel
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
+		`SELECT login, key, request_hash, status_code, content_type, body, created_at, token, committed
+				FROM idempotency_keys WHERE login = $1 AND key = $2`, login, key)
+	err := row.Scan(&record.Login, &record.Key, &record.RequestHash, &record.StatusCode,
+		&record.ContentType, &record.Body, &record.CreatedAt, &record.Token, &record.Committed)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &record, nil
+}
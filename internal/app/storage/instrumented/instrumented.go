@@ -0,0 +1,366 @@
+// Package instrumented wraps storages to observe every call, e.g. to record
+// its latency or trace it, without touching the storage implementations.
+package instrumented
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"time"
+)
+
+// Observer is called before a storage call with the "Interface.Method" name.
+// The storage is called with the returned context and done with its error.
+type Observer func(ctx context.Context, method string) (context.Context, func(err error))
+
+// Chain combines the observers into one, the first one observing the outermost.
+func Chain(observers ...Observer) Observer {
+	return func(ctx context.Context, method string) (context.Context, func(err error)) {
+		dones := make([]func(err error), len(observers))
+		for i, observe := range observers {
+			ctx, dones[i] = observe(ctx, method)
+		}
+		return ctx, func(err error) {
+			for i := len(dones) - 1; i >= 0; i-- {
+				dones[i](err)
+			}
+		}
+	}
+}
+
+type txManager struct {
+	next    storage.TxManager
+	observe Observer
+}
+
+func TxManager(next storage.TxManager, observe Observer) storage.TxManager {
+	return &txManager{next: next, observe: observe}
+}
+
+func (s *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, done := s.observe(ctx, "TxManager.WithinTx")
+	err := s.next.WithinTx(ctx, fn)
+	done(err)
+	return err
+}
+
+type userStorage struct {
+	next    storage.UserStorage
+	observe Observer
+}
+
+func UserStorage(next storage.UserStorage, observe Observer) storage.UserStorage {
+	return &userStorage{next: next, observe: observe}
+}
+
+func (s *userStorage) InsertNewUser(ctx context.Context, user entities.UserModel) error {
+	ctx, done := s.observe(ctx, "UserStorage.InsertNewUser")
+	err := s.next.InsertNewUser(ctx, user)
+	done(err)
+	return err
+}
+
+func (s *userStorage) UpdateUserSession(ctx context.Context, userSession entities.UserSessionModel) error {
+	ctx, done := s.observe(ctx, "UserStorage.UpdateUserSession")
+	err := s.next.UpdateUserSession(ctx, userSession)
+	done(err)
+	return err
+}
+
+func (s *userStorage) GetUserIfExists(ctx context.Context, login string) (*entities.UserModel, error) {
+	ctx, done := s.observe(ctx, "UserStorage.GetUserIfExists")
+	res, err := s.next.GetUserIfExists(ctx, login)
+	done(err)
+	return res, err
+}
+
+func (s *userStorage) GetUserBySessionIfExists(ctx context.Context, session string) (*entities.UserSessionModel, error) {
+	ctx, done := s.observe(ctx, "UserStorage.GetUserBySessionIfExists")
+	res, err := s.next.GetUserBySessionIfExists(ctx, session)
+	done(err)
+	return res, err
+}
+
+type orderStorage struct {
+	next    storage.OrderStorage
+	observe Observer
+}
+
+func OrderStorage(next storage.OrderStorage, observe Observer) storage.OrderStorage {
+	return &orderStorage{next: next, observe: observe}
+}
+
+func (s *orderStorage) InsertNewOrder(ctx context.Context, order entities.OrderModel) error {
+	ctx, done := s.observe(ctx, "OrderStorage.InsertNewOrder")
+	err := s.next.InsertNewOrder(ctx, order)
+	done(err)
+	return err
+}
+
+func (s *orderStorage) UpdateOrderStatus(ctx context.Context, orderNum string, from string, to string) (bool, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.UpdateOrderStatus")
+	res, err := s.next.UpdateOrderStatus(ctx, orderNum, from, to)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) ProcessOrderAccrual(ctx context.Context, orderNum string, from string, accrual sql.NullFloat64, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.ProcessOrderAccrual")
+	res, err := s.next.ProcessOrderAccrual(ctx, orderNum, from, accrual, events)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderModel, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.GetOrdersForUser")
+	res, err := s.next.GetOrdersForUser(ctx, login, query)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) CountOrdersForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.CountOrdersForUser")
+	res, err := s.next.CountOrdersForUser(ctx, login, query)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.CountOrdersByStatus")
+	res, err := s.next.CountOrdersByStatus(ctx)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.GetOrderIfExists")
+	res, err := s.next.GetOrderIfExists(ctx, orderNum)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) DeleteOrder(ctx context.Context, orderNum string, status string) (bool, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.DeleteOrder")
+	res, err := s.next.DeleteOrder(ctx, orderNum, status)
+	done(err)
+	return res, err
+}
+
+func (s *orderStorage) InsertOrderStatusHistory(ctx context.Context, entry entities.OrderStatusHistoryModel) error {
+	ctx, done := s.observe(ctx, "OrderStorage.InsertOrderStatusHistory")
+	err := s.next.InsertOrderStatusHistory(ctx, entry)
+	done(err)
+	return err
+}
+
+func (s *orderStorage) GetOrderStatusHistory(ctx context.Context, orderNum string) ([]entities.OrderStatusHistoryModel, error) {
+	ctx, done := s.observe(ctx, "OrderStorage.GetOrderStatusHistory")
+	res, err := s.next.GetOrderStatusHistory(ctx, orderNum)
+	done(err)
+	return res, err
+}
+
+type balanceStorage struct {
+	next    storage.BalanceStorage
+	observe Observer
+}
+
+func BalanceStorage(next storage.BalanceStorage, observe Observer) storage.BalanceStorage {
+	return &balanceStorage{next: next, observe: observe}
+}
+
+func (s *balanceStorage) InsertNewBalance(ctx context.Context, balance entities.BalanceModel) error {
+	ctx, done := s.observe(ctx, "BalanceStorage.InsertNewBalance")
+	err := s.next.InsertNewBalance(ctx, balance)
+	done(err)
+	return err
+}
+
+func (s *balanceStorage) InsertNewBalanceWithdrawals(ctx context.Context, balanceWithdrawals entities.BalanceWithdrawalsModel) error {
+	ctx, done := s.observe(ctx, "BalanceStorage.InsertNewBalanceWithdrawals")
+	err := s.next.InsertNewBalanceWithdrawals(ctx, balanceWithdrawals)
+	done(err)
+	return err
+}
+
+func (s *balanceStorage) UpdateBalance(ctx context.Context, balance entities.BalanceModel) error {
+	ctx, done := s.observe(ctx, "BalanceStorage.UpdateBalance")
+	err := s.next.UpdateBalance(ctx, balance)
+	done(err)
+	return err
+}
+
+func (s *balanceStorage) WithdrawBalance(ctx context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	ctx, done := s.observe(ctx, "BalanceStorage.WithdrawBalance")
+	res, err := s.next.WithdrawBalance(ctx, withdrawal, events)
+	done(err)
+	return res, err
+}
+
+func (s *balanceStorage) GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
+	ctx, done := s.observe(ctx, "BalanceStorage.GetBalance")
+	res, err := s.next.GetBalance(ctx, login)
+	done(err)
+	return res, err
+}
+
+func (s *balanceStorage) GetBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsModel, error) {
+	ctx, done := s.observe(ctx, "BalanceStorage.GetBalanceWithdrawalsForUser")
+	res, err := s.next.GetBalanceWithdrawalsForUser(ctx, login, query)
+	done(err)
+	return res, err
+}
+
+func (s *balanceStorage) CountBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	ctx, done := s.observe(ctx, "BalanceStorage.CountBalanceWithdrawalsForUser")
+	res, err := s.next.CountBalanceWithdrawalsForUser(ctx, login, query)
+	done(err)
+	return res, err
+}
+
+type idempotencyStorage struct {
+	next    storage.IdempotencyStorage
+	observe Observer
+}
+
+func IdempotencyStorage(next storage.IdempotencyStorage, observe Observer) storage.IdempotencyStorage {
+	return &idempotencyStorage{next: next, observe: observe}
+}
+
+func (s *idempotencyStorage) InsertIdempotencyKey(ctx context.Context, record entities.IdempotencyModel, expiredBefore time.Time, staleBefore time.Time) (bool, error) {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.InsertIdempotencyKey")
+	res, err := s.next.InsertIdempotencyKey(ctx, record, expiredBefore, staleBefore)
+	done(err)
+	return res, err
+}
+
+func (s *idempotencyStorage) CommitIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) (bool, error) {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.CommitIdempotencyKey")
+	res, err := s.next.CommitIdempotencyKey(ctx, record)
+	done(err)
+	return res, err
+}
+
+func (s *idempotencyStorage) UpdateIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.UpdateIdempotencyKey")
+	err := s.next.UpdateIdempotencyKey(ctx, record)
+	done(err)
+	return err
+}
+
+func (s *idempotencyStorage) DeleteIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.DeleteIdempotencyKey")
+	err := s.next.DeleteIdempotencyKey(ctx, record)
+	done(err)
+	return err
+}
+
+func (s *idempotencyStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time, staleBefore time.Time) (int64, error) {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.DeleteExpiredIdempotencyKeys")
+	res, err := s.next.DeleteExpiredIdempotencyKeys(ctx, expiredBefore, staleBefore)
+	done(err)
+	return res, err
+}
+
+func (s *idempotencyStorage) GetIdempotencyKey(ctx context.Context, login string, key string) (*entities.IdempotencyModel, error) {
+	ctx, done := s.observe(ctx, "IdempotencyStorage.GetIdempotencyKey")
+	res, err := s.next.GetIdempotencyKey(ctx, login, key)
+	done(err)
+	return res, err
+}
+
+type webhookStorage struct {
+	next    storage.WebhookStorage
+	observe Observer
+}
+
+func WebhookStorage(next storage.WebhookStorage, observe Observer) storage.WebhookStorage {
+	return &webhookStorage{next: next, observe: observe}
+}
+
+func (s *webhookStorage) InsertWebhook(ctx context.Context, webhook entities.WebhookModel) (int64, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.InsertWebhook")
+	res, err := s.next.InsertWebhook(ctx, webhook)
+	done(err)
+	return res, err
+}
+
+func (s *webhookStorage) DeleteWebhook(ctx context.Context, id int64, login string) (bool, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.DeleteWebhook")
+	res, err := s.next.DeleteWebhook(ctx, id, login)
+	done(err)
+	return res, err
+}
+
+func (s *webhookStorage) GetWebhookIfExists(ctx context.Context, id int64) (*entities.WebhookModel, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.GetWebhookIfExists")
+	res, err := s.next.GetWebhookIfExists(ctx, id)
+	done(err)
+	return res, err
+}
+
+func (s *webhookStorage) GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookModel, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.GetWebhooksForUser")
+	res, err := s.next.GetWebhooksForUser(ctx, login)
+	done(err)
+	return res, err
+}
+
+func (s *webhookStorage) InsertWebhookDelivery(ctx context.Context, delivery entities.WebhookDeliveryModel) (int64, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.InsertWebhookDelivery")
+	res, err := s.next.InsertWebhookDelivery(ctx, delivery)
+	done(err)
+	return res, err
+}
+
+func (s *webhookStorage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]entities.WebhookDeliveryModel, error) {
+	ctx, done := s.observe(ctx, "WebhookStorage.GetWebhookDeliveries")
+	res, err := s.next.GetWebhookDeliveries(ctx, webhookID, limit)
+	done(err)
+	return res, err
+}
+
+type outboxStorage struct {
+	next    storage.OutboxStorage
+	observe Observer
+}
+
+func OutboxStorage(next storage.OutboxStorage, observe Observer) storage.OutboxStorage {
+	return &outboxStorage{next: next, observe: observe}
+}
+
+func (s *outboxStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]entities.OutboxEventModel, error) {
+	ctx, done := s.observe(ctx, "OutboxStorage.ClaimOutboxEvents")
+	res, err := s.next.ClaimOutboxEvents(ctx, now, claimedUntil, limit)
+	done(err)
+	return res, err
+}
+
+func (s *outboxStorage) MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	ctx, done := s.observe(ctx, "OutboxStorage.MarkOutboxEventDelivered")
+	err := s.next.MarkOutboxEventDelivered(ctx, id, deliveredAt)
+	done(err)
+	return err
+}
+
+func (s *outboxStorage) MarkOutboxEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	ctx, done := s.observe(ctx, "OutboxStorage.MarkOutboxEventFailed")
+	err := s.next.MarkOutboxEventFailed(ctx, id, lastError, nextAttemptAt)
+	done(err)
+	return err
+}
+
+func (s *outboxStorage) MarkOutboxEventDead(ctx context.Context, id int64, lastError string, deadAt time.Time) error {
+	ctx, done := s.observe(ctx, "OutboxStorage.MarkOutboxEventDead")
+	err := s.next.MarkOutboxEventDead(ctx, id, lastError, deadAt)
+	done(err)
+	return err
+}
+
+func (s *outboxStorage) DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	ctx, done := s.observe(ctx, "OutboxStorage.DeleteDeliveredOutboxEvents")
+	res, err := s.next.DeleteDeliveredOutboxEvents(ctx, deliveredBefore)
+	done(err)
+	return res, err
+}
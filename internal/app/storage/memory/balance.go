@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"sort"
+)
+
+func (s *Storage) InsertNewBalance(ctx context.Context, balance entities.BalanceModel) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.balances[balance.Login]; ok {
+		return errors.NewDuplicateError(balance.Login)
+	}
+	s.balances[balance.Login] = entities.BalanceModel{Login: balance.Login}
+	return nil
+}
+
+func (s *Storage) InsertNewBalanceWithdrawals(ctx context.Context, balanceWithdrawals entities.BalanceWithdrawalsModel) error {
+	defer s.lock(ctx)()
+
+	balanceWithdrawals.ID = s.nextID()
+	s.withdrawals = append(s.withdrawals, balanceWithdrawals)
+	return nil
+}
+
+func (s *Storage) UpdateBalance(ctx context.Context, balance entities.BalanceModel) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.balances[balance.Login]; ok {
+		s.balances[balance.Login] = balance
+	}
+	return nil
+}
+
+// WithdrawBalance charges the sum, records the withdrawal and its outbox events
+// atomically. It returns nil if the balance is not sufficient.
+func (s *Storage) WithdrawBalance(ctx context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	defer s.lock(ctx)()
+
+	balance, ok := s.balances[withdrawal.Login]
+	if !ok || balance.Balance < withdrawal.Sum {
+		return nil, nil
+	}
+	balance.Balance -= withdrawal.Sum
+	balance.Spent += withdrawal.Sum
+	s.balances[withdrawal.Login] = balance
+
+	withdrawal.ID = s.nextID()
+	s.withdrawals = append(s.withdrawals, withdrawal)
+	s.insertOutboxEvents(events)
+	return &balance, nil
+}
+
+func (s *Storage) GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
+	defer s.rlock(ctx)()
+
+	balance, ok := s.balances[login]
+	if !ok {
+		return nil, nil
+	}
+	return &balance, nil
+}
+
+func (s *Storage) GetBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsModel, error) {
+	defer s.rlock(ctx)()
+
+	var withdrawals []entities.BalanceWithdrawalsModel
+	for _, w := range s.withdrawals {
+		if w.Login == login && matchesList(withdrawalQuery(query), w.ProcessedAt, w.ID, "", true) {
+			withdrawals = append(withdrawals, w)
+		}
+	}
+	sort.Slice(withdrawals, func(i, j int) bool {
+		return listOrder(query, withdrawals[i].ProcessedAt, withdrawals[i].ID, withdrawals[j].ProcessedAt, withdrawals[j].ID)
+	})
+	return withdrawals[:pageSize(query, len(withdrawals))], nil
+}
+
+func (s *Storage) CountBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	defer s.rlock(ctx)()
+
+	total := 0
+	for _, w := range s.withdrawals {
+		if w.Login == login && matchesList(withdrawalQuery(query), w.ProcessedAt, w.ID, "", false) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// withdrawalQuery drops the status filter, withdrawals have no status.
+func withdrawalQuery(query entities.ListQuery) entities.ListQuery {
+	query.Statuses = nil
+	return query
+}
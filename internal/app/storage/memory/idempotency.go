@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"time"
+)
+
+func idempotencyKeyExpired(record entities.IdempotencyModel, expiredBefore time.Time, staleBefore time.Time) bool {
+	return record.CreatedAt.Before(expiredBefore) || (record.StatusCode == 0 && !record.Committed && record.CreatedAt.Before(staleBefore))
+}
+
+// InsertIdempotencyKey reserves the key and reports false if it is already
+// taken and not expired.
+func (s *Storage) InsertIdempotencyKey(ctx context.Context, record entities.IdempotencyModel, expiredBefore time.Time, staleBefore time.Time) (bool, error) {
+	defer s.lock(ctx)()
+
+	k := idempotencyKey{login: record.Login, key: record.Key}
+	if stored, ok := s.idempotency[k]; ok && !idempotencyKeyExpired(stored, expiredBefore, staleBefore) {
+		return false, nil
+	}
+	s.idempotency[k] = entities.IdempotencyModel{
+		Login:       record.Login,
+		Key:         record.Key,
+		RequestHash: record.RequestHash,
+		CreatedAt:   record.CreatedAt,
+		Token:       record.Token,
+	}
+	return true, nil
+}
+
+// CommitIdempotencyKey marks the operation of the key as committed and reports
+// false if the request no longer holds the key.
+func (s *Storage) CommitIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) (bool, error) {
+	defer s.lock(ctx)()
+
+	k := idempotencyKey{login: record.Login, key: record.Key}
+	stored, ok := s.idempotency[k]
+	if !ok || stored.Token != record.Token || stored.StatusCode != 0 {
+		return false, nil
+	}
+	stored.Committed = true
+	s.idempotency[k] = stored
+	return true, nil
+}
+
+func (s *Storage) UpdateIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	defer s.lock(ctx)()
+
+	k := idempotencyKey{login: record.Login, key: record.Key}
+	stored, ok := s.idempotency[k]
+	if !ok || stored.Token != record.Token {
+		return nil
+	}
+	stored.StatusCode = record.StatusCode
+	stored.ContentType = record.ContentType
+	stored.Body = append([]byte(nil), record.Body...)
+	s.idempotency[k] = stored
+	return nil
+}
+
+// DeleteIdempotencyKey releases the key unless its operation is committed.
+func (s *Storage) DeleteIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	defer s.lock(ctx)()
+
+	k := idempotencyKey{login: record.Login, key: record.Key}
+	if stored, ok := s.idempotency[k]; ok && stored.Token == record.Token && !stored.Committed {
+		delete(s.idempotency, k)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time, staleBefore time.Time) (int64, error) {
+	defer s.lock(ctx)()
+
+	var deleted int64
+	for k, record := range s.idempotency {
+		if idempotencyKeyExpired(record, expiredBefore, staleBefore) {
+			delete(s.idempotency, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Storage) GetIdempotencyKey(ctx context.Context, login string, key string) (*entities.IdempotencyModel, error) {
+	defer s.rlock(ctx)()
+
+	record, ok := s.idempotency[idempotencyKey{login: login, key: key}]
+	if !ok {
+		return nil, nil
+	}
+	record.Body = append([]byte(nil), record.Body...)
+	return &record, nil
+}
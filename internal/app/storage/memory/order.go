@@ -0,0 +1,156 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"sort"
+)
+
+const processedStatus = "PROCESSED"
+
+func (s *Storage) InsertNewOrder(ctx context.Context, order entities.OrderModel) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.orders[order.OrderNum]; ok {
+		return errors.NewDuplicateError(order.OrderNum)
+	}
+	order.ID = s.nextID()
+	order.Accrual = sql.NullFloat64{}
+	s.orders[order.OrderNum] = order
+	return nil
+}
+
+// UpdateOrderStatus moves the order to the new status only if it is still in
+// the expected one and reports whether the order was updated.
+func (s *Storage) UpdateOrderStatus(ctx context.Context, orderNum string, from string, to string) (bool, error) {
+	defer s.lock(ctx)()
+
+	order, ok := s.orders[orderNum]
+	if !ok || order.Status != from {
+		return false, nil
+	}
+	order.Status = to
+	s.orders[orderNum] = order
+	return true, nil
+}
+
+// ProcessOrderAccrual marks the order as PROCESSED, credits the accrual to the
+// owner's balance and writes the outbox events atomically. It returns the new
+// balance, or nil if the order is no longer in the expected status.
+func (s *Storage) ProcessOrderAccrual(ctx context.Context, orderNum string, from string, accrual sql.NullFloat64, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	defer s.lock(ctx)()
+
+	order, ok := s.orders[orderNum]
+	if !ok || order.Status != from {
+		return nil, nil
+	}
+	balance, ok := s.balances[order.Login]
+	if !ok {
+		return nil, fmt.Errorf("no balance for user %s", order.Login)
+	}
+
+	order.Status = processedStatus
+	order.Accrual = accrual
+	s.orders[orderNum] = order
+	balance.Balance += accrual.Float64
+	s.balances[order.Login] = balance
+	s.insertOutboxEvents(events)
+	return &balance, nil
+}
+
+func (s *Storage) GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderModel, error) {
+	defer s.rlock(ctx)()
+
+	var orders []entities.OrderModel
+	for _, o := range s.orders {
+		if o.Login == login && matchesList(query, o.UploadedAt, o.ID, o.Status, true) {
+			orders = append(orders, o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return listOrder(query, orders[i].UploadedAt, orders[i].ID, orders[j].UploadedAt, orders[j].ID)
+	})
+	return orders[:pageSize(query, len(orders))], nil
+}
+
+func (s *Storage) CountOrdersForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	defer s.rlock(ctx)()
+
+	total := 0
+	for _, o := range s.orders {
+		if o.Login == login && matchesList(query, o.UploadedAt, o.ID, o.Status, false) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// CountOrdersByStatus returns the number of orders of all users in every status.
+func (s *Storage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	defer s.rlock(ctx)()
+
+	counts := make(map[string]int)
+	for _, o := range s.orders {
+		counts[o.Status]++
+	}
+	return counts, nil
+}
+
+func (s *Storage) GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error) {
+	defer s.rlock(ctx)()
+
+	order, ok := s.orders[orderNum]
+	if !ok {
+		return nil, nil
+	}
+	return &order, nil
+}
+
+// DeleteOrder removes the order (and its history) only if it is still in the given status.
+func (s *Storage) DeleteOrder(ctx context.Context, orderNum string, status string) (bool, error) {
+	defer s.lock(ctx)()
+
+	order, ok := s.orders[orderNum]
+	if !ok || order.Status != status {
+		return false, nil
+	}
+	delete(s.orders, orderNum)
+	delete(s.orderHistory, orderNum)
+	return true, nil
+}
+
+// InsertOrderStatusHistory appends a transition unless the latest recorded
+// status of the order is already the same, so repeated polls don't add entries.
+func (s *Storage) InsertOrderStatusHistory(ctx context.Context, entry entities.OrderStatusHistoryModel) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.orders[entry.OrderNum]; !ok {
+		return fmt.Errorf("order %s does not exist", entry.OrderNum)
+	}
+	history := s.orderHistory[entry.OrderNum]
+	var latest *entities.OrderStatusHistoryModel
+	for i := range history {
+		if latest == nil || listLess(latest.ChangedAt, latest.ID, history[i].ChangedAt, history[i].ID) {
+			latest = &history[i]
+		}
+	}
+	if latest != nil && latest.Status == entry.Status {
+		return nil
+	}
+	entry.ID = s.nextID()
+	s.orderHistory[entry.OrderNum] = append(history, entry)
+	return nil
+}
+
+func (s *Storage) GetOrderStatusHistory(ctx context.Context, orderNum string) ([]entities.OrderStatusHistoryModel, error) {
+	defer s.rlock(ctx)()
+
+	history := append([]entities.OrderStatusHistoryModel(nil), s.orderHistory[orderNum]...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return listLess(history[i].ChangedAt, history[i].ID, history[j].ChangedAt, history[j].ID)
+	})
+	return history, nil
+}
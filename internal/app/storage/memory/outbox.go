@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"time"
+)
+
+// insertOutboxEvents writes events together with the change they describe,
+// the caller must hold the write lock.
+func (s *Storage) insertOutboxEvents(events []entities.OutboxEventModel) {
+	for _, e := range events {
+		e.ID = s.nextID()
+		e.Attempts = 0
+		e.LastError = ""
+		s.outbox = append(s.outbox, outboxRecord{event: e, nextAttemptAt: e.CreatedAt})
+	}
+}
+
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]entities.OutboxEventModel, error) {
+	defer s.lock(ctx)()
+
+	var events []entities.OutboxEventModel
+	for i := range s.outbox {
+		if len(events) >= limit {
+			break
+		}
+		r := &s.outbox[i]
+		if r.deliveredAt == nil && r.deadAt == nil && !r.nextAttemptAt.After(now) {
+			r.nextAttemptAt = claimedUntil
+			events = append(events, r.event)
+		}
+	}
+	return events, nil
+}
+
+func (s *Storage) MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	defer s.lock(ctx)()
+
+	if r := s.findOutboxRecord(id); r != nil {
+		r.deliveredAt = &deliveredAt
+		r.event.Attempts++
+		r.event.LastError = ""
+	}
+	return nil
+}
+
+func (s *Storage) MarkOutboxEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	defer s.lock(ctx)()
+
+	if r := s.findOutboxRecord(id); r != nil {
+		r.event.Attempts++
+		r.event.LastError = lastError
+		r.nextAttemptAt = nextAttemptAt
+	}
+	return nil
+}
+
+func (s *Storage) MarkOutboxEventDead(ctx context.Context, id int64, lastError string, deadAt time.Time) error {
+	defer s.lock(ctx)()
+
+	if r := s.findOutboxRecord(id); r != nil {
+		r.event.Attempts++
+		r.event.LastError = lastError
+		r.deadAt = &deadAt
+	}
+	return nil
+}
+
+func (s *Storage) DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	defer s.lock(ctx)()
+
+	kept := s.outbox[:0]
+	for _, r := range s.outbox {
+		if r.deliveredAt == nil || !r.deliveredAt.Before(deliveredBefore) {
+			kept = append(kept, r)
+		}
+	}
+	deleted := int64(len(s.outbox) - len(kept))
+	s.outbox = kept
+	return deleted, nil
+}
+
+func (s *Storage) findOutboxRecord(id int64) *outboxRecord {
+	for i := range s.outbox {
+		if s.outbox[i].event.ID == id {
+			return &s.outbox[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Package memory implements the storage interfaces in process memory. It keeps
+// the semantics of the Postgres storages (unique keys, nil for not found,
+// conditional updates) and is used when no database is configured and in tests.
+package memory
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/storage"
+	"sync"
+	"time"
+)
+
+type idempotencyKey struct {
+	login string
+	key   string
+}
+
+type outboxRecord struct {
+	event         entities.OutboxEventModel
+	nextAttemptAt time.Time
+	deliveredAt   *time.Time
+	deadAt        *time.Time
+}
+
+// Storage holds all tables behind one lock, so that operations touching
+// several of them are atomic like the transactions of the database storages.
+type Storage struct {
+	mu sync.RWMutex
+	tables
+}
+
+type tables struct {
+	lastID       int64
+	users        map[string]entities.UserModel
+	sessions     map[string]string
+	orders       map[string]entities.OrderModel
+	orderHistory map[string][]entities.OrderStatusHistoryModel
+	balances     map[string]entities.BalanceModel
+	withdrawals  []entities.BalanceWithdrawalsModel
+	idempotency  map[idempotencyKey]entities.IdempotencyModel
+	webhooks     map[int64]entities.WebhookModel
+	deliveries   []entities.WebhookDeliveryModel
+	outbox       []outboxRecord
+}
+
+func NewStorage() *Storage {
+	storage := &Storage{tables: tables{
+		users:        make(map[string]entities.UserModel),
+		sessions:     make(map[string]string),
+		orders:       make(map[string]entities.OrderModel),
+		orderHistory: make(map[string][]entities.OrderStatusHistoryModel),
+		balances:     make(map[string]entities.BalanceModel),
+		idempotency:  make(map[idempotencyKey]entities.IdempotencyModel),
+		webhooks:     make(map[int64]entities.WebhookModel),
+	}}
+	return storage
+}
+
+// clone copies the tables deep enough for in place updates of the copy not
+// to show in the original.
+func (t *tables) clone() tables {
+	c := tables{
+		lastID:       t.lastID,
+		users:        make(map[string]entities.UserModel, len(t.users)),
+		sessions:     make(map[string]string, len(t.sessions)),
+		orders:       make(map[string]entities.OrderModel, len(t.orders)),
+		orderHistory: make(map[string][]entities.OrderStatusHistoryModel, len(t.orderHistory)),
+		balances:     make(map[string]entities.BalanceModel, len(t.balances)),
+		withdrawals:  append([]entities.BalanceWithdrawalsModel(nil), t.withdrawals...),
+		idempotency:  make(map[idempotencyKey]entities.IdempotencyModel, len(t.idempotency)),
+		webhooks:     make(map[int64]entities.WebhookModel, len(t.webhooks)),
+		deliveries:   append([]entities.WebhookDeliveryModel(nil), t.deliveries...),
+		outbox:       append([]outboxRecord(nil), t.outbox...),
+	}
+	for k, v := range t.users {
+		c.users[k] = v
+	}
+	for k, v := range t.sessions {
+		c.sessions[k] = v
+	}
+	for k, v := range t.orders {
+		c.orders[k] = v
+	}
+	for k, v := range t.orderHistory {
+		c.orderHistory[k] = append([]entities.OrderStatusHistoryModel(nil), v...)
+	}
+	for k, v := range t.balances {
+		c.balances[k] = v
+	}
+	for k, v := range t.idempotency {
+		c.idempotency[k] = v
+	}
+	for k, v := range t.webhooks {
+		c.webhooks[k] = v
+	}
+	return c
+}
+
+// WithinTx runs fn holding the storage lock and restores the tables if it
+// fails. Calls made with the context passed to fn don't take the lock again.
+func (s *Storage) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.inTx(ctx) {
+		return fn(ctx)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.tables.clone()
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		s.tables = snapshot
+		return err
+	}
+	return nil
+}
+
+type txKey struct{}
+
+func (s *Storage) inTx(ctx context.Context) bool {
+	owner, ok := ctx.Value(txKey{}).(*Storage)
+	return ok && owner == s
+}
+
+// lock takes the write lock unless ctx is in a transaction of this storage,
+// which already holds it, and returns the matching unlock.
+func (s *Storage) lock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Storage) rlock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+func (s *Storage) Init(_ context.Context) error {
+	return nil
+}
+
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
+}
+
+// PendingMigrations is always zero, there is no schema to migrate.
+func (s *Storage) PendingMigrations(_ context.Context) (int, error) {
+	return 0, nil
+}
+
+// nextID returns a new row id, the caller must hold the write lock.
+func (s *Storage) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+// matchesList reports whether a row passes the filters of the list query,
+// including the keyset cursor when withCursor is set.
+func matchesList(query entities.ListQuery, at time.Time, id int64, status string, withCursor bool) bool {
+	if len(query.Statuses) > 0 && !contains(query.Statuses, status) {
+		return false
+	}
+	if query.From != nil && at.Before(*query.From) {
+		return false
+	}
+	if query.To != nil && !at.Before(*query.To) {
+		return false
+	}
+	if withCursor && query.After != nil {
+		if query.Desc {
+			return listLess(at, id, query.After.At, query.After.ID)
+		}
+		return listLess(query.After.At, query.After.ID, at, id)
+	}
+	return true
+}
+
+func listLess(aAt time.Time, aID int64, bAt time.Time, bID int64) bool {
+	if aAt.Equal(bAt) {
+		return aID < bID
+	}
+	return aAt.Before(bAt)
+}
+
+// listOrder compares two rows by (at, id) in the direction of the list query.
+func listOrder(query entities.ListQuery, aAt time.Time, aID int64, bAt time.Time, bID int64) bool {
+	if query.Desc {
+		return listLess(bAt, bID, aAt, aID)
+	}
+	return listLess(aAt, aID, bAt, bID)
+}
+
+// pageSize returns how many of n sorted rows fit into the page.
+func pageSize(query entities.ListQuery, n int) int {
+	if query.Limit > 0 && n > query.Limit {
+		return query.Limit
+	}
+	return n
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ storage.CommonStorage      = (*Storage)(nil)
+	_ storage.UserStorage        = (*Storage)(nil)
+	_ storage.OrderStorage       = (*Storage)(nil)
+	_ storage.BalanceStorage     = (*Storage)(nil)
+	_ storage.IdempotencyStorage = (*Storage)(nil)
+	_ storage.WebhookStorage     = (*Storage)(nil)
+	_ storage.OutboxStorage      = (*Storage)(nil)
+	_ storage.TxManager          = (*Storage)(nil)
+	_ storage.HealthStorage      = (*Storage)(nil)
+)
@@ -0,0 +1,254 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_Users(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+
+	user, err := s.GetUserIfExists(ctx, "hello")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	require.NoError(t, s.InsertNewUser(ctx, entities.UserModel{Login: "hello", PasswordHash: "123", Session: "s1"}))
+	err = s.InsertNewUser(ctx, entities.UserModel{Login: "hello", PasswordHash: "456", Session: "s2"})
+	assert.IsType(t, &errors.DuplicateError{}, err)
+
+	require.NoError(t, s.UpdateUserSession(ctx, entities.UserSessionModel{Login: "hello", Session: "s3"}))
+	session, err := s.GetUserBySessionIfExists(ctx, "s1")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+	session, err = s.GetUserBySessionIfExists(ctx, "s3")
+	require.NoError(t, err)
+	assert.Equal(t, &entities.UserSessionModel{Login: "hello", Session: "s3"}, session)
+}
+
+func TestStorage_Orders(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	require.NoError(t, s.InsertNewUser(ctx, entities.UserModel{Login: "hello"}))
+	require.NoError(t, s.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"}))
+
+	start := time.Date(2022, 4, 16, 0, 0, 0, 0, time.UTC)
+	for i, num := range []string{"1", "2", "3"} {
+		require.NoError(t, s.InsertNewOrder(ctx, entities.OrderModel{
+			OrderNum: num, Login: "hello", UploadedAt: start.Add(time.Duration(i) * time.Hour), Status: "NEW"}))
+	}
+	err := s.InsertNewOrder(ctx, entities.OrderModel{OrderNum: "1", Login: "other", Status: "NEW"})
+	assert.IsType(t, &errors.DuplicateError{}, err)
+
+	updated, err := s.UpdateOrderStatus(ctx, "1", "PROCESSING", "INVALID")
+	require.NoError(t, err)
+	assert.False(t, updated)
+	updated, err = s.UpdateOrderStatus(ctx, "1", "NEW", "PROCESSING")
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	balance, err := s.ProcessOrderAccrual(ctx, "1", "NEW", sql.NullFloat64{Float64: 10, Valid: true}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, balance)
+	balance, err = s.ProcessOrderAccrual(ctx, "1", "PROCESSING", sql.NullFloat64{Float64: 10, Valid: true},
+		[]entities.OutboxEventModel{{EventType: "order.processed", Login: "hello", Payload: "{}"}})
+	require.NoError(t, err)
+	assert.Equal(t, &entities.BalanceModel{Login: "hello", Balance: 10}, balance)
+
+	now := time.Now()
+	pending, err := s.ClaimOutboxEvents(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	claimed, err := s.ClaimOutboxEvents(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "a claimed event is not handed out twice")
+
+	require.NoError(t, s.MarkOutboxEventFailed(ctx, pending[0].ID, "unavailable", now.Add(time.Second)))
+	pending, err = s.ClaimOutboxEvents(ctx, now.Add(time.Second), now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+	require.NoError(t, s.MarkOutboxEventDelivered(ctx, pending[0].ID, time.Now()))
+	pending, err = s.ClaimOutboxEvents(ctx, now.Add(time.Hour), now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	pruned, err := s.DeleteDeliveredOutboxEvents(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, pruned, "recently delivered events are kept")
+	pruned, err = s.DeleteDeliveredOutboxEvents(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+
+	query := entities.ListQuery{Limit: 2, Desc: true}
+	page, err := s.GetOrdersForUser(ctx, "hello", query)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "3", page[0].OrderNum)
+	assert.Equal(t, "2", page[1].OrderNum)
+
+	query.After = &entities.Cursor{At: page[1].UploadedAt, ID: page[1].ID}
+	page, err = s.GetOrdersForUser(ctx, "hello", query)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "1", page[0].OrderNum)
+
+	total, err := s.CountOrdersForUser(ctx, "hello", entities.ListQuery{Statuses: []string{"NEW"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	deleted, err := s.DeleteOrder(ctx, "1", "NEW")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	deleted, err = s.DeleteOrder(ctx, "2", "NEW")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	order, err := s.GetOrderIfExists(ctx, "2")
+	require.NoError(t, err)
+	assert.Nil(t, order)
+}
+
+func TestStorage_OrderStatusHistory(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	require.NoError(t, s.InsertNewOrder(ctx, entities.OrderModel{OrderNum: "1", Login: "hello", Status: "NEW"}))
+
+	now := time.Now()
+	for i, status := range []string{"NEW", "PROCESSING", "PROCESSING", "PROCESSED"} {
+		require.NoError(t, s.InsertOrderStatusHistory(ctx, entities.OrderStatusHistoryModel{
+			OrderNum: "1", Status: status, ChangedAt: now.Add(time.Duration(i) * time.Second)}))
+	}
+
+	history, err := s.GetOrderStatusHistory(ctx, "1")
+	require.NoError(t, err)
+	var statuses []string
+	for _, h := range history {
+		statuses = append(statuses, h.Status)
+	}
+	assert.Equal(t, []string{"NEW", "PROCESSING", "PROCESSED"}, statuses)
+}
+
+func TestStorage_WithdrawBalance(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	require.NoError(t, s.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"}))
+	require.NoError(t, s.UpdateBalance(ctx, entities.BalanceModel{Login: "hello", Balance: 100}))
+
+	withdrawal := entities.BalanceWithdrawalsModel{Login: "hello", OrderNum: "2377225624", Sum: 150, ProcessedAt: time.Now()}
+	balance, err := s.WithdrawBalance(ctx, withdrawal, nil)
+	require.NoError(t, err)
+	assert.Nil(t, balance)
+
+	withdrawal.Sum = 40
+	balance, err = s.WithdrawBalance(ctx, withdrawal, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &entities.BalanceModel{Login: "hello", Balance: 60, Spent: 40}, balance)
+
+	withdrawals, err := s.GetBalanceWithdrawalsForUser(ctx, "hello", entities.ListQuery{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, 40.0, withdrawals[0].Sum)
+}
+
+func TestStorage_IdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	now := time.Now()
+	expiredBefore, staleBefore := now.Add(-24*time.Hour), now.Add(-time.Minute)
+	record := entities.IdempotencyModel{Login: "hello", Key: "k", RequestHash: "h", CreatedAt: now}
+
+	inserted, err := s.InsertIdempotencyKey(ctx, record, expiredBefore, staleBefore)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	inserted, err = s.InsertIdempotencyKey(ctx, record, expiredBefore, staleBefore)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+
+	record.StatusCode = 200
+	record.Body = []byte("ok")
+	require.NoError(t, s.UpdateIdempotencyKey(ctx, record))
+	stored, err := s.GetIdempotencyKey(ctx, "hello", "k")
+	require.NoError(t, err)
+	assert.Equal(t, 200, stored.StatusCode)
+	assert.Equal(t, []byte("ok"), stored.Body)
+
+	require.NoError(t, s.DeleteIdempotencyKey(ctx, record))
+	stored, err = s.GetIdempotencyKey(ctx, "hello", "k")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestStorage_ExpiredIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	now := time.Now()
+	expiredBefore, staleBefore := now.Add(-24*time.Hour), now.Add(-time.Minute)
+
+	stale := entities.IdempotencyModel{Login: "hello", Key: "stale", RequestHash: "h", CreatedAt: now.Add(-time.Hour)}
+	completed := entities.IdempotencyModel{Login: "hello", Key: "completed", RequestHash: "h", CreatedAt: now.Add(-time.Hour)}
+	expired := entities.IdempotencyModel{Login: "hello", Key: "expired", RequestHash: "h", CreatedAt: now.Add(-48 * time.Hour)}
+	for _, record := range []entities.IdempotencyModel{stale, completed, expired} {
+		inserted, err := s.InsertIdempotencyKey(ctx, record, expiredBefore, staleBefore)
+		require.NoError(t, err)
+		require.True(t, inserted)
+	}
+	completed.StatusCode = 200
+	require.NoError(t, s.UpdateIdempotencyKey(ctx, completed))
+	expired.StatusCode = 200
+	require.NoError(t, s.UpdateIdempotencyKey(ctx, expired))
+
+	// a request left in progress does not hold the key forever
+	stale.CreatedAt = now
+	inserted, err := s.InsertIdempotencyKey(ctx, stale, expiredBefore, staleBefore)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	completed.CreatedAt = now
+	inserted, err = s.InsertIdempotencyKey(ctx, completed, expiredBefore, staleBefore)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+
+	deleted, err := s.DeleteExpiredIdempotencyKeys(ctx, expiredBefore, staleBefore)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	stored, err := s.GetIdempotencyKey(ctx, "hello", "expired")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	stored, err = s.GetIdempotencyKey(ctx, "hello", "completed")
+	require.NoError(t, err)
+	assert.Equal(t, 200, stored.StatusCode)
+}
+
+func TestStorage_WithinTx(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+
+	err := s.WithinTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, s.InsertNewUser(ctx, entities.UserModel{Login: "hello", Session: "s1"}))
+		require.NoError(t, s.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"}))
+		return s.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"})
+	})
+	assert.IsType(t, &errors.DuplicateError{}, err)
+
+	user, err := s.GetUserIfExists(ctx, "hello")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+	balance, err := s.GetBalance(ctx, "hello")
+	require.NoError(t, err)
+	assert.Nil(t, balance)
+
+	err = s.WithinTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, s.InsertNewUser(ctx, entities.UserModel{Login: "hello", Session: "s1"}))
+		return s.WithinTx(ctx, func(ctx context.Context) error {
+			return s.InsertNewBalance(ctx, entities.BalanceModel{Login: "hello"})
+		})
+	})
+	require.NoError(t, err)
+	balance, err = s.GetBalance(ctx, "hello")
+	require.NoError(t, err)
+	assert.NotNil(t, balance)
+}
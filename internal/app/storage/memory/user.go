@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"github.com/xbreathoflife/gophermart/internal/app/errors"
+)
+
+func (s *Storage) InsertNewUser(ctx context.Context, user entities.UserModel) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[user.Login]; ok {
+		return errors.NewDuplicateError(user.Login)
+	}
+	if _, ok := s.sessions[user.Session]; ok && user.Session != "" {
+		return errors.NewDuplicateError(user.Session)
+	}
+	s.users[user.Login] = user
+	if user.Session != "" {
+		s.sessions[user.Session] = user.Login
+	}
+	return nil
+}
+
+func (s *Storage) UpdateUserSession(ctx context.Context, userSession entities.UserSessionModel) error {
+	defer s.lock(ctx)()
+
+	user, ok := s.users[userSession.Login]
+	if !ok {
+		return nil
+	}
+	if login, ok := s.sessions[userSession.Session]; ok && login != user.Login && userSession.Session != "" {
+		return errors.NewDuplicateError(userSession.Session)
+	}
+	delete(s.sessions, user.Session)
+	user.Session = userSession.Session
+	s.users[user.Login] = user
+	if user.Session != "" {
+		s.sessions[user.Session] = user.Login
+	}
+	return nil
+}
+
+func (s *Storage) GetUserIfExists(ctx context.Context, login string) (*entities.UserModel, error) {
+	defer s.rlock(ctx)()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, nil
+	}
+	return &entities.UserModel{Login: user.Login, PasswordHash: user.PasswordHash}, nil
+}
+
+func (s *Storage) GetUserBySessionIfExists(ctx context.Context, session string) (*entities.UserSessionModel, error) {
+	defer s.rlock(ctx)()
+
+	login, ok := s.sessions[session]
+	if !ok || session == "" {
+		return nil, nil
+	}
+	return &entities.UserSessionModel{Login: login, Session: session}, nil
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"sort"
+)
+
+func (s *Storage) InsertWebhook(ctx context.Context, webhook entities.WebhookModel) (int64, error) {
+	defer s.lock(ctx)()
+
+	webhook.ID = s.nextID()
+	webhook.EventTypes = append([]string(nil), webhook.EventTypes...)
+	s.webhooks[webhook.ID] = webhook
+	return webhook.ID, nil
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64, login string) (bool, error) {
+	defer s.lock(ctx)()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.Login != login {
+		return false, nil
+	}
+	delete(s.webhooks, id)
+	deliveries := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.WebhookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+	return true, nil
+}
+
+func (s *Storage) GetWebhookIfExists(ctx context.Context, id int64) (*entities.WebhookModel, error) {
+	defer s.rlock(ctx)()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	webhook.EventTypes = append([]string(nil), webhook.EventTypes...)
+	return &webhook, nil
+}
+
+func (s *Storage) GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookModel, error) {
+	defer s.rlock(ctx)()
+
+	var webhooks []entities.WebhookModel
+	for _, w := range s.webhooks {
+		if w.Login == login {
+			w.EventTypes = append([]string(nil), w.EventTypes...)
+			webhooks = append(webhooks, w)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+func (s *Storage) InsertWebhookDelivery(ctx context.Context, delivery entities.WebhookDeliveryModel) (int64, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[delivery.WebhookID]; !ok {
+		return 0, fmt.Errorf("webhook %d does not exist", delivery.WebhookID)
+	}
+	delivery.ID = s.nextID()
+	s.deliveries = append(s.deliveries, delivery)
+	return delivery.ID, nil
+}
+
+func (s *Storage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]entities.WebhookDeliveryModel, error) {
+	defer s.rlock(ctx)()
+
+	var deliveries []entities.WebhookDeliveryModel
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return listLess(deliveries[j].DeliveredAt, deliveries[j].ID, deliveries[i].DeliveredAt, deliveries[i].ID)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
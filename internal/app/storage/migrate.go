@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationLockID is the key of the advisory lock which serializes migrations
+// between instances started at the same time.
+const migrationLockID = 4270418001
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the migrations from fsys ordered by version. Every
+// version must have an up script, the down script is optional.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		m := migrationFileRe.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Up applies all pending migrations and returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn, versions map[int64]time.Time) error {
+		for _, migration := range m.Migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			err := m.apply(ctx, conn, migration, migration.Up,
+				`INSERT INTO schema_migrations(version, name, applied_at) VALUES ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now())
+			if err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back up to steps latest applied migrations and returns the rolled back ones.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn, versions map[int64]time.Time) error {
+		for i := len(m.Migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can not be rolled back", migration.Version, migration.Name)
+			}
+			err := m.apply(ctx, conn, migration, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status lists the known migrations with the time they were applied, nil if pending.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn, versions map[int64]time.Time) error {
+		for _, migration := range m.Migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Pending returns the number of migrations which are not applied yet. It does
+// not wait for the migration lock, so it is cheap enough for readiness probes.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	versions, err := appliedVersions(ctx, m.DB)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, migration := range m.Migrations {
+		if _, ok := versions[migration.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory
+// lock, with the versions applied so far.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, versions map[int64]time.Time) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version    BIGINT PRIMARY KEY,
+			name       TEXT                     NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	versions, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, versions)
+}
+
+func appliedVersions(ctx context.Context, q querier) (map[int64]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// apply runs the script and records it in schema_migrations in one transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xbreathoflife/gophermart/migrations"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"README.md":            {Data: []byte("not a migration")},
+	}
+
+	got, err := LoadMigrations(fsys)
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ();"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ();", Down: "DROP TABLE b;"},
+	}, got)
+}
+
+func TestLoadMigrations_Errors(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{"0001_first.down.sql": {Data: []byte("DROP TABLE a;")}})
+	assert.Error(t, err)
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+		"0001_other.up.sql": {Data: []byte("CREATE TABLE b ();")},
+	})
+	assert.Error(t, err)
+}
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	got, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, got)
+	for i, m := range got {
+		assert.Equal(t, int64(i+1), m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d_%s has no down script", m.Version, m.Name)
+	}
+}
@@ -35,6 +35,21 @@ func (m *MockBalanceStorage) EXPECT() *MockBalanceStorageMockRecorder {
 	return m.recorder
 }
 
+// CountBalanceWithdrawalsForUser mocks base method.
+func (m *MockBalanceStorage) CountBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountBalanceWithdrawalsForUser", ctx, login, query)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountBalanceWithdrawalsForUser indicates an expected call of CountBalanceWithdrawalsForUser.
+func (mr *MockBalanceStorageMockRecorder) CountBalanceWithdrawalsForUser(ctx, login, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountBalanceWithdrawalsForUser", reflect.TypeOf((*MockBalanceStorage)(nil).CountBalanceWithdrawalsForUser), ctx, login, query)
+}
+
 // GetBalance mocks base method.
 func (m *MockBalanceStorage) GetBalance(ctx context.Context, login string) (*entities.BalanceModel, error) {
 	m.ctrl.T.Helper()
@@ -51,18 +66,18 @@ func (mr *MockBalanceStorageMockRecorder) GetBalance(ctx, login interface{}) *go
 }
 
 // GetBalanceWithdrawalsForUser mocks base method.
-func (m *MockBalanceStorage) GetBalanceWithdrawalsForUser(ctx context.Context, login string) ([]entities.BalanceWithdrawalsModel, error) {
+func (m *MockBalanceStorage) GetBalanceWithdrawalsForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.BalanceWithdrawalsModel, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBalanceWithdrawalsForUser", ctx, login)
+	ret := m.ctrl.Call(m, "GetBalanceWithdrawalsForUser", ctx, login, query)
 	ret0, _ := ret[0].([]entities.BalanceWithdrawalsModel)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBalanceWithdrawalsForUser indicates an expected call of GetBalanceWithdrawalsForUser.
-func (mr *MockBalanceStorageMockRecorder) GetBalanceWithdrawalsForUser(ctx, login interface{}) *gomock.Call {
+func (mr *MockBalanceStorageMockRecorder) GetBalanceWithdrawalsForUser(ctx, login, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceWithdrawalsForUser", reflect.TypeOf((*MockBalanceStorage)(nil).GetBalanceWithdrawalsForUser), ctx, login)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceWithdrawalsForUser", reflect.TypeOf((*MockBalanceStorage)(nil).GetBalanceWithdrawalsForUser), ctx, login, query)
 }
 
 // InsertNewBalance mocks base method.
@@ -106,3 +121,18 @@ func (mr *MockBalanceStorageMockRecorder) UpdateBalance(ctx, balance interface{}
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBalance", reflect.TypeOf((*MockBalanceStorage)(nil).UpdateBalance), ctx, balance)
 }
+
+// WithdrawBalance mocks base method.
+func (m *MockBalanceStorage) WithdrawBalance(ctx context.Context, withdrawal entities.BalanceWithdrawalsModel, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithdrawBalance", ctx, withdrawal, events)
+	ret0, _ := ret[0].(*entities.BalanceModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WithdrawBalance indicates an expected call of WithdrawBalance.
+func (mr *MockBalanceStorageMockRecorder) WithdrawBalance(ctx, withdrawal, events interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithdrawBalance", reflect.TypeOf((*MockBalanceStorage)(nil).WithdrawBalance), ctx, withdrawal, events)
+}
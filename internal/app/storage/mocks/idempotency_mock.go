@@ -0,0 +1,125 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: idempotency.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	entities "github.com/xbreathoflife/gophermart/internal/app/entities"
+)
+
+// MockIdempotencyStorage is a mock of IdempotencyStorage interface.
+type MockIdempotencyStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyStorageMockRecorder
+}
+
+// MockIdempotencyStorageMockRecorder is the mock recorder for MockIdempotencyStorage.
+type MockIdempotencyStorageMockRecorder struct {
+	mock *MockIdempotencyStorage
+}
+
+// NewMockIdempotencyStorage creates a new mock instance.
+func NewMockIdempotencyStorage(ctrl *gomock.Controller) *MockIdempotencyStorage {
+	mock := &MockIdempotencyStorage{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotencyStorage) EXPECT() *MockIdempotencyStorageMockRecorder {
+	return m.recorder
+}
+
+// CommitIdempotencyKey mocks base method.
+func (m *MockIdempotencyStorage) CommitIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitIdempotencyKey", ctx, record)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitIdempotencyKey indicates an expected call of CommitIdempotencyKey.
+func (mr *MockIdempotencyStorageMockRecorder) CommitIdempotencyKey(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitIdempotencyKey", reflect.TypeOf((*MockIdempotencyStorage)(nil).CommitIdempotencyKey), ctx, record)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockIdempotencyStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore, staleBefore time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx, expiredBefore, staleBefore)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockIdempotencyStorageMockRecorder) DeleteExpiredIdempotencyKeys(ctx, expiredBefore, staleBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockIdempotencyStorage)(nil).DeleteExpiredIdempotencyKeys), ctx, expiredBefore, staleBefore)
+}
+
+// DeleteIdempotencyKey mocks base method.
+func (m *MockIdempotencyStorage) DeleteIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotencyKey", ctx, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyKey indicates an expected call of DeleteIdempotencyKey.
+func (mr *MockIdempotencyStorageMockRecorder) DeleteIdempotencyKey(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyKey", reflect.TypeOf((*MockIdempotencyStorage)(nil).DeleteIdempotencyKey), ctx, record)
+}
+
+// GetIdempotencyKey mocks base method.
+func (m *MockIdempotencyStorage) GetIdempotencyKey(ctx context.Context, login, key string) (*entities.IdempotencyModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdempotencyKey", ctx, login, key)
+	ret0, _ := ret[0].(*entities.IdempotencyModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdempotencyKey indicates an expected call of GetIdempotencyKey.
+func (mr *MockIdempotencyStorageMockRecorder) GetIdempotencyKey(ctx, login, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdempotencyKey", reflect.TypeOf((*MockIdempotencyStorage)(nil).GetIdempotencyKey), ctx, login, key)
+}
+
+// InsertIdempotencyKey mocks base method.
+func (m *MockIdempotencyStorage) InsertIdempotencyKey(ctx context.Context, record entities.IdempotencyModel, expiredBefore, staleBefore time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertIdempotencyKey", ctx, record, expiredBefore, staleBefore)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertIdempotencyKey indicates an expected call of InsertIdempotencyKey.
+func (mr *MockIdempotencyStorageMockRecorder) InsertIdempotencyKey(ctx, record, expiredBefore, staleBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertIdempotencyKey", reflect.TypeOf((*MockIdempotencyStorage)(nil).InsertIdempotencyKey), ctx, record, expiredBefore, staleBefore)
+}
+
+// UpdateIdempotencyKey mocks base method.
+func (m *MockIdempotencyStorage) UpdateIdempotencyKey(ctx context.Context, record entities.IdempotencyModel) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateIdempotencyKey", ctx, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateIdempotencyKey indicates an expected call of UpdateIdempotencyKey.
+func (mr *MockIdempotencyStorageMockRecorder) UpdateIdempotencyKey(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIdempotencyKey", reflect.TypeOf((*MockIdempotencyStorage)(nil).UpdateIdempotencyKey), ctx, record)
+}
@@ -6,6 +6,7 @@ package mocks
 
 import (
 	context "context"
+	sql "database/sql"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,51 @@ func (m *MockOrderStorage) EXPECT() *MockOrderStorageMockRecorder {
 	return m.recorder
 }
 
+// CountOrdersByStatus mocks base method.
+func (m *MockOrderStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountOrdersByStatus", ctx)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountOrdersByStatus indicates an expected call of CountOrdersByStatus.
+func (mr *MockOrderStorageMockRecorder) CountOrdersByStatus(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountOrdersByStatus", reflect.TypeOf((*MockOrderStorage)(nil).CountOrdersByStatus), ctx)
+}
+
+// CountOrdersForUser mocks base method.
+func (m *MockOrderStorage) CountOrdersForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountOrdersForUser", ctx, login, query)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountOrdersForUser indicates an expected call of CountOrdersForUser.
+func (mr *MockOrderStorageMockRecorder) CountOrdersForUser(ctx, login, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountOrdersForUser", reflect.TypeOf((*MockOrderStorage)(nil).CountOrdersForUser), ctx, login, query)
+}
+
+// DeleteOrder mocks base method.
+func (m *MockOrderStorage) DeleteOrder(ctx context.Context, orderNum, status string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOrder", ctx, orderNum, status)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteOrder indicates an expected call of DeleteOrder.
+func (mr *MockOrderStorageMockRecorder) DeleteOrder(ctx, orderNum, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOrder", reflect.TypeOf((*MockOrderStorage)(nil).DeleteOrder), ctx, orderNum, status)
+}
+
 // GetOrderIfExists mocks base method.
 func (m *MockOrderStorage) GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error) {
 	m.ctrl.T.Helper()
@@ -50,19 +96,34 @@ func (mr *MockOrderStorageMockRecorder) GetOrderIfExists(ctx, orderNum interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderIfExists", reflect.TypeOf((*MockOrderStorage)(nil).GetOrderIfExists), ctx, orderNum)
 }
 
+// GetOrderStatusHistory mocks base method.
+func (m *MockOrderStorage) GetOrderStatusHistory(ctx context.Context, orderNum string) ([]entities.OrderStatusHistoryModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderStatusHistory", ctx, orderNum)
+	ret0, _ := ret[0].([]entities.OrderStatusHistoryModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderStatusHistory indicates an expected call of GetOrderStatusHistory.
+func (mr *MockOrderStorageMockRecorder) GetOrderStatusHistory(ctx, orderNum interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatusHistory", reflect.TypeOf((*MockOrderStorage)(nil).GetOrderStatusHistory), ctx, orderNum)
+}
+
 // GetOrdersForUser mocks base method.
-func (m *MockOrderStorage) GetOrdersForUser(ctx context.Context, login string) ([]entities.OrderModel, error) {
+func (m *MockOrderStorage) GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderModel, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersForUser", ctx, login)
+	ret := m.ctrl.Call(m, "GetOrdersForUser", ctx, login, query)
 	ret0, _ := ret[0].([]entities.OrderModel)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrdersForUser indicates an expected call of GetOrdersForUser.
-func (mr *MockOrderStorageMockRecorder) GetOrdersForUser(ctx, login interface{}) *gomock.Call {
+func (mr *MockOrderStorageMockRecorder) GetOrdersForUser(ctx, login, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersForUser", reflect.TypeOf((*MockOrderStorage)(nil).GetOrdersForUser), ctx, login)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersForUser", reflect.TypeOf((*MockOrderStorage)(nil).GetOrdersForUser), ctx, login, query)
 }
 
 // InsertNewOrder mocks base method.
@@ -79,30 +140,46 @@ func (mr *MockOrderStorageMockRecorder) InsertNewOrder(ctx, order interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertNewOrder", reflect.TypeOf((*MockOrderStorage)(nil).InsertNewOrder), ctx, order)
 }
 
-// UpdateOrderStatus mocks base method.
-func (m *MockOrderStorage) UpdateOrderStatus(ctx context.Context, orderNum, status string) error {
+// InsertOrderStatusHistory mocks base method.
+func (m *MockOrderStorage) InsertOrderStatusHistory(ctx context.Context, entry entities.OrderStatusHistoryModel) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, orderNum, status)
+	ret := m.ctrl.Call(m, "InsertOrderStatusHistory", ctx, entry)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateOrderStatus indicates an expected call of UpdateOrderStatus.
-func (mr *MockOrderStorageMockRecorder) UpdateOrderStatus(ctx, orderNum, status interface{}) *gomock.Call {
+// InsertOrderStatusHistory indicates an expected call of InsertOrderStatusHistory.
+func (mr *MockOrderStorageMockRecorder) InsertOrderStatusHistory(ctx, entry interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockOrderStorage)(nil).UpdateOrderStatus), ctx, orderNum, status)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertOrderStatusHistory", reflect.TypeOf((*MockOrderStorage)(nil).InsertOrderStatusHistory), ctx, entry)
 }
 
-// UpdateOrderStatusAndAccrual mocks base method.
-func (m *MockOrderStorage) UpdateOrderStatusAndAccrual(ctx context.Context, orderNum, status string, accrual float64) error {
+// ProcessOrderAccrual mocks base method.
+func (m *MockOrderStorage) ProcessOrderAccrual(ctx context.Context, orderNum, from string, accrual sql.NullFloat64, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrderStatusAndAccrual", ctx, orderNum, status, accrual)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "ProcessOrderAccrual", ctx, orderNum, from, accrual, events)
+	ret0, _ := ret[0].(*entities.BalanceModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ProcessOrderAccrual indicates an expected call of ProcessOrderAccrual.
+func (mr *MockOrderStorageMockRecorder) ProcessOrderAccrual(ctx, orderNum, from, accrual, events interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessOrderAccrual", reflect.TypeOf((*MockOrderStorage)(nil).ProcessOrderAccrual), ctx, orderNum, from, accrual, events)
+}
+
+// UpdateOrderStatus mocks base method.
+func (m *MockOrderStorage) UpdateOrderStatus(ctx context.Context, orderNum, from, to string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, orderNum, from, to)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// UpdateOrderStatusAndAccrual indicates an expected call of UpdateOrderStatusAndAccrual.
-func (mr *MockOrderStorageMockRecorder) UpdateOrderStatusAndAccrual(ctx, orderNum, status, accrual interface{}) *gomock.Call {
+// UpdateOrderStatus indicates an expected call of UpdateOrderStatus.
+func (mr *MockOrderStorageMockRecorder) UpdateOrderStatus(ctx, orderNum, from, to interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatusAndAccrual", reflect.TypeOf((*MockOrderStorage)(nil).UpdateOrderStatusAndAccrual), ctx, orderNum, status, accrual)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockOrderStorage)(nil).UpdateOrderStatus), ctx, orderNum, from, to)
 }
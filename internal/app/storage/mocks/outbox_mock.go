@@ -0,0 +1,109 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: outbox.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	entities "github.com/xbreathoflife/gophermart/internal/app/entities"
+)
+
+// MockOutboxStorage is a mock of OutboxStorage interface.
+type MockOutboxStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockOutboxStorageMockRecorder
+}
+
+// MockOutboxStorageMockRecorder is the mock recorder for MockOutboxStorage.
+type MockOutboxStorageMockRecorder struct {
+	mock *MockOutboxStorage
+}
+
+// NewMockOutboxStorage creates a new mock instance.
+func NewMockOutboxStorage(ctrl *gomock.Controller) *MockOutboxStorage {
+	mock := &MockOutboxStorage{ctrl: ctrl}
+	mock.recorder = &MockOutboxStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOutboxStorage) EXPECT() *MockOutboxStorageMockRecorder {
+	return m.recorder
+}
+
+// ClaimOutboxEvents mocks base method.
+func (m *MockOutboxStorage) ClaimOutboxEvents(ctx context.Context, now, claimedUntil time.Time, limit int) ([]entities.OutboxEventModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOutboxEvents", ctx, now, claimedUntil, limit)
+	ret0, _ := ret[0].([]entities.OutboxEventModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOutboxEvents indicates an expected call of ClaimOutboxEvents.
+func (mr *MockOutboxStorageMockRecorder) ClaimOutboxEvents(ctx, now, claimedUntil, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOutboxEvents", reflect.TypeOf((*MockOutboxStorage)(nil).ClaimOutboxEvents), ctx, now, claimedUntil, limit)
+}
+
+// DeleteDeliveredOutboxEvents mocks base method.
+func (m *MockOutboxStorage) DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDeliveredOutboxEvents", ctx, deliveredBefore)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteDeliveredOutboxEvents indicates an expected call of DeleteDeliveredOutboxEvents.
+func (mr *MockOutboxStorageMockRecorder) DeleteDeliveredOutboxEvents(ctx, deliveredBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDeliveredOutboxEvents", reflect.TypeOf((*MockOutboxStorage)(nil).DeleteDeliveredOutboxEvents), ctx, deliveredBefore)
+}
+
+// MarkOutboxEventDead mocks base method.
+func (m *MockOutboxStorage) MarkOutboxEventDead(ctx context.Context, id int64, lastError string, deadAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxEventDead", ctx, id, lastError, deadAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxEventDead indicates an expected call of MarkOutboxEventDead.
+func (mr *MockOutboxStorageMockRecorder) MarkOutboxEventDead(ctx, id, lastError, deadAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxEventDead", reflect.TypeOf((*MockOutboxStorage)(nil).MarkOutboxEventDead), ctx, id, lastError, deadAt)
+}
+
+// MarkOutboxEventDelivered mocks base method.
+func (m *MockOutboxStorage) MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxEventDelivered", ctx, id, deliveredAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxEventDelivered indicates an expected call of MarkOutboxEventDelivered.
+func (mr *MockOutboxStorageMockRecorder) MarkOutboxEventDelivered(ctx, id, deliveredAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxEventDelivered", reflect.TypeOf((*MockOutboxStorage)(nil).MarkOutboxEventDelivered), ctx, id, deliveredAt)
+}
+
+// MarkOutboxEventFailed mocks base method.
+func (m *MockOutboxStorage) MarkOutboxEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxEventFailed", ctx, id, lastError, nextAttemptAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxEventFailed indicates an expected call of MarkOutboxEventFailed.
+func (mr *MockOutboxStorageMockRecorder) MarkOutboxEventFailed(ctx, id, lastError, nextAttemptAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxEventFailed", reflect.TypeOf((*MockOutboxStorage)(nil).MarkOutboxEventFailed), ctx, id, lastError, nextAttemptAt)
+}
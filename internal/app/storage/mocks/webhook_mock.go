@@ -0,0 +1,167 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: webhook.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	entities "github.com/xbreathoflife/gophermart/internal/app/entities"
+)
+
+// MockWebhookStorage is a mock of WebhookStorage interface.
+type MockWebhookStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookStorageMockRecorder
+}
+
+// MockWebhookStorageMockRecorder is the mock recorder for MockWebhookStorage.
+type MockWebhookStorageMockRecorder struct {
+	mock *MockWebhookStorage
+}
+
+// NewMockWebhookStorage creates a new mock instance.
+func NewMockWebhookStorage(ctrl *gomock.Controller) *MockWebhookStorage {
+	mock := &MockWebhookStorage{ctrl: ctrl}
+	mock.recorder = &MockWebhookStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhookStorage) EXPECT() *MockWebhookStorageMockRecorder {
+	return m.recorder
+}
+
+// DeleteWebhook mocks base method.
+func (m *MockWebhookStorage) DeleteWebhook(ctx context.Context, id int64, login string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", ctx, id, login)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockWebhookStorageMockRecorder) DeleteWebhook(ctx, id, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockWebhookStorage)(nil).DeleteWebhook), ctx, id, login)
+}
+
+// GetWebhookDeliveries mocks base method.
+func (m *MockWebhookStorage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]entities.WebhookDeliveryModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookDeliveries", ctx, webhookID, limit)
+	ret0, _ := ret[0].([]entities.WebhookDeliveryModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookDeliveries indicates an expected call of GetWebhookDeliveries.
+func (mr *MockWebhookStorageMockRecorder) GetWebhookDeliveries(ctx, webhookID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookDeliveries", reflect.TypeOf((*MockWebhookStorage)(nil).GetWebhookDeliveries), ctx, webhookID, limit)
+}
+
+// GetWebhookIfExists mocks base method.
+func (m *MockWebhookStorage) GetWebhookIfExists(ctx context.Context, id int64) (*entities.WebhookModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookIfExists", ctx, id)
+	ret0, _ := ret[0].(*entities.WebhookModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookIfExists indicates an expected call of GetWebhookIfExists.
+func (mr *MockWebhookStorageMockRecorder) GetWebhookIfExists(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookIfExists", reflect.TypeOf((*MockWebhookStorage)(nil).GetWebhookIfExists), ctx, id)
+}
+
+// GetWebhooksForUser mocks base method.
+func (m *MockWebhookStorage) GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookModel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhooksForUser", ctx, login)
+	ret0, _ := ret[0].([]entities.WebhookModel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooksForUser indicates an expected call of GetWebhooksForUser.
+func (mr *MockWebhookStorageMockRecorder) GetWebhooksForUser(ctx, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooksForUser", reflect.TypeOf((*MockWebhookStorage)(nil).GetWebhooksForUser), ctx, login)
+}
+
+// InsertWebhook mocks base method.
+func (m *MockWebhookStorage) InsertWebhook(ctx context.Context, webhook entities.WebhookModel) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertWebhook", ctx, webhook)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertWebhook indicates an expected call of InsertWebhook.
+func (mr *MockWebhookStorageMockRecorder) InsertWebhook(ctx, webhook interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertWebhook", reflect.TypeOf((*MockWebhookStorage)(nil).InsertWebhook), ctx, webhook)
+}
+
+// InsertWebhookDelivery mocks base method.
+func (m *MockWebhookStorage) InsertWebhookDelivery(ctx context.Context, delivery entities.WebhookDeliveryModel) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertWebhookDelivery", ctx, delivery)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertWebhookDelivery indicates an expected call of InsertWebhookDelivery.
+func (mr *MockWebhookStorageMockRecorder) InsertWebhookDelivery(ctx, delivery interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertWebhookDelivery", reflect.TypeOf((*MockWebhookStorage)(nil).InsertWebhookDelivery), ctx, delivery)
+}
+
+// Mockscanner is a mock of scanner interface.
+type Mockscanner struct {
+	ctrl     *gomock.Controller
+	recorder *MockscannerMockRecorder
+}
+
+// MockscannerMockRecorder is the mock recorder for Mockscanner.
+type MockscannerMockRecorder struct {
+	mock *Mockscanner
+}
+
+// NewMockscanner creates a new mock instance.
+func NewMockscanner(ctrl *gomock.Controller) *Mockscanner {
+	mock := &Mockscanner{ctrl: ctrl}
+	mock.recorder = &MockscannerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockscanner) EXPECT() *MockscannerMockRecorder {
+	return m.recorder
+}
+
+// Scan mocks base method.
+func (m *Mockscanner) Scan(dest ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range dest {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Scan", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockscannerMockRecorder) Scan(dest ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*Mockscanner)(nil).Scan), dest...)
+}
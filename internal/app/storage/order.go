@@ -8,102 +8,145 @@ import (
 
 type OrderStorage interface {
 	InsertNewOrder(ctx context.Context, order entities.OrderModel) error
-	UpdateOrderStatus(ctx context.Context, orderNum string, status string) error
-	UpdateOrderStatusAndAccrual(ctx context.Context, orderNum string, status string, accrual float64) error
-	GetOrdersForUser(ctx context.Context, login string) ([]entities.OrderModel, error)
+	UpdateOrderStatus(ctx context.Context, orderNum string, from string, to string) (bool, error)
+	ProcessOrderAccrual(ctx context.Context, orderNum string, from string, accrual sql.NullFloat64, events []entities.OutboxEventModel) (*entities.BalanceModel, error)
+	GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderModel, error)
+	CountOrdersForUser(ctx context.Context, login string, query entities.ListQuery) (int, error)
+	CountOrdersByStatus(ctx context.Context) (map[string]int, error)
 	GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error)
+	DeleteOrder(ctx context.Context, orderNum string, status string) (bool, error)
+	InsertOrderStatusHistory(ctx context.Context, entry entities.OrderStatusHistoryModel) error
+	GetOrderStatusHistory(ctx context.Context, orderNum string) ([]entities.OrderStatusHistoryModel, error)
 }
 
 type OrderStorageImpl struct {
-	ConnString string
+	DB *sql.DB
 }
 
-func NewOrderStorage(connString string) *OrderStorageImpl {
-	storage := &OrderStorageImpl{ConnString: connString}
+func NewOrderStorage(db *sql.DB) *OrderStorageImpl {
+	storage := &OrderStorageImpl{DB: db}
 	return storage
 }
 
 func (s *OrderStorageImpl) InsertNewOrder(ctx context.Context, order entities.OrderModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`INSERT INTO orders(order_num, login, uploaded_at, status) VALUES ($1, $2, $3, $4)`,
 		order.OrderNum, order.Login, order.UploadedAt, order.Status)
 
 	return err
 }
 
-func (s *OrderStorageImpl) UpdateOrderStatus(ctx context.Context, orderNum string, status string) error {
-	conn, err := connect(ctx, s.ConnString)
+// UpdateOrderStatus moves the order to the new status only if it is still in
+// the expected one and reports whether the row was updated.
+func (s *OrderStorageImpl) UpdateOrderStatus(ctx context.Context, orderNum string, from string, to string) (bool, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE orders SET status = $1 WHERE order_num = $2 AND status = $3`, to, orderNum, from)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
-		`UPDATE orders SET status = $1 WHERE order_num = $2`, status, orderNum)
 
-	return err
+	return rowsAffected(res)
 }
 
-func (s *OrderStorageImpl) UpdateOrderStatusAndAccrual(ctx context.Context, orderNum string, status string, accrual float64) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+// ProcessOrderAccrual marks the order as PROCESSED, credits the accrual to the
+// owner's balance and writes the outbox events in one transaction. It returns
+// the new balance, or nil if the order is no longer in the expected status.
+func (s *OrderStorageImpl) ProcessOrderAccrual(ctx context.Context, orderNum string, from string, accrual sql.NullFloat64, events []entities.OutboxEventModel) (*entities.BalanceModel, error) {
+	var balance *entities.BalanceModel
+	err := withTx(ctx, s.DB, func(tx *sql.Tx) error {
+		var login string
+		row := tx.QueryRowContext(ctx,
+			`UPDATE orders SET status = 'PROCESSED', accrual = $1 WHERE order_num = $2 AND status = $3 RETURNING login`,
+			accrual, orderNum, from)
+		if err := row.Scan(&login); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
 
-	_, err = conn.ExecContext(ctx,
-		`UPDATE orders SET status = $1, accrual = $2 WHERE order_num = $3`,
-		status, accrual, orderNum)
+		b := entities.BalanceModel{Login: login}
+		row = tx.QueryRowContext(ctx,
+			`UPDATE balance SET balance = balance + $1 WHERE login = $2 RETURNING balance, spent`,
+			accrual.Float64, login)
+		if err := row.Scan(&b.Balance, &b.Spent); err != nil {
+			return err
+		}
+		if err := insertOutboxEvents(ctx, tx, events); err != nil {
+			return err
+		}
+		balance = &b
+		return nil
+	})
 
-	return err
+	return balance, err
 }
 
-func (s *OrderStorageImpl) GetOrdersForUser(ctx context.Context, login string) ([]entities.OrderModel, error) {
-	conn, err := connect(ctx, s.ConnString)
+func (s *OrderStorageImpl) GetOrdersForUser(ctx context.Context, login string, query entities.ListQuery) ([]entities.OrderModel, error) {
+	f := newListFilter(login)
+	f.statuses("status", query.Statuses)
+	f.dateRange("uploaded_at", query)
+	f.after("uploaded_at", query)
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`SELECT id, order_num, login, uploaded_at, status, accrual FROM orders
+				WHERE `+f.where()+` `+orderBy("uploaded_at", query)+` `+limit(f, query), f.args...)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Close()
-	rows, err := conn.QueryContext(ctx,
-		`SELECT order_num, login, uploaded_at, status, accrual FROM orders
-				WHERE login = $1 ORDER BY uploaded_at`, login)
-	if err != nil && rows.Err() != nil {
-		return nil, err
-	}
-
 	defer rows.Close()
 
 	var orders []entities.OrderModel
 	for rows.Next() {
 		var o entities.OrderModel
-		if err := rows.Scan(&o.OrderNum, &o.Login, &o.UploadedAt, &o.Status, &o.Accrual); err != nil {
+		if err := rows.Scan(&o.ID, &o.OrderNum, &o.Login, &o.UploadedAt, &o.Status, &o.Accrual); err != nil {
 			return nil, err
 		}
 		orders = append(orders, o)
 	}
 
-	return orders, nil
+	return orders, rows.Err()
 }
 
-func (s *OrderStorageImpl) GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error) {
-	conn, err := connect(ctx, s.ConnString)
+func (s *OrderStorageImpl) CountOrdersForUser(ctx context.Context, login string, query entities.ListQuery) (int, error) {
+	f := newListFilter(login)
+	f.statuses("status", query.Statuses)
+	f.dateRange("uploaded_at", query)
+	var total int
+	row := executor(ctx, s.DB).QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE `+f.where(), f.args...)
+	err := row.Scan(&total)
+
+	return total, err
+}
+
+// CountOrdersByStatus returns the number of orders of all users in every status.
+func (s *OrderStorageImpl) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := executor(ctx, s.DB).QueryContext(ctx, `SELECT status, COUNT(*) FROM orders GROUP BY status`)
 	if err != nil {
 		return nil, err
 	}
 
-	defer conn.Close()
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *OrderStorageImpl) GetOrderIfExists(ctx context.Context, orderNum string) (*entities.OrderModel, error) {
 	var order entities.OrderModel
-	row := conn.QueryRowContext(ctx,
-		`SELECT order_num, login, uploaded_at, status FROM orders
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
+		`SELECT id, order_num, login, uploaded_at, status, accrual FROM orders
 				WHERE order_num = $1`, orderNum)
-	err = row.Scan(&order.OrderNum, &order.Login, &order.UploadedAt, &order.Status)
+	err := row.Scan(&order.ID, &order.OrderNum, &order.Login, &order.UploadedAt, &order.Status, &order.Accrual)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -114,3 +157,49 @@ func (s *OrderStorageImpl) GetOrderIfExists(ctx context.Context, orderNum string
 
 	return &order, nil
 }
+
+// DeleteOrder removes the order (and its history) only if it is still in the given status.
+func (s *OrderStorageImpl) DeleteOrder(ctx context.Context, orderNum string, status string) (bool, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`DELETE FROM orders WHERE order_num = $1 AND status = $2`, orderNum, status)
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected(res)
+}
+
+// InsertOrderStatusHistory appends a transition unless the latest recorded
+// status of the order is already the same, so repeated polls don't add rows.
+func (s *OrderStorageImpl) InsertOrderStatusHistory(ctx context.Context, entry entities.OrderStatusHistoryModel) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`INSERT INTO order_status_history(order_num, status, accrual, changed_at)
+				SELECT $1::text, $2::text, $3::numeric, $4::timestamptz
+				WHERE COALESCE((SELECT status FROM order_status_history WHERE order_num = $1
+					ORDER BY changed_at DESC, id DESC LIMIT 1), '') <> $2`,
+		entry.OrderNum, entry.Status, entry.Accrual, entry.ChangedAt)
+
+	return err
+}
+
+func (s *OrderStorageImpl) GetOrderStatusHistory(ctx context.Context, orderNum string) ([]entities.OrderStatusHistoryModel, error) {
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`SELECT id, order_num, status, accrual, changed_at FROM order_status_history
+				WHERE order_num = $1 ORDER BY changed_at, id`, orderNum)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var history []entities.OrderStatusHistoryModel
+	for rows.Next() {
+		var h entities.OrderStatusHistoryModel
+		if err := rows.Scan(&h.ID, &h.OrderNum, &h.Status, &h.Accrual, &h.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"time"
+)
+
+type OutboxStorage interface {
+	// ClaimOutboxEvents returns up to limit pending events due at now and
+	// hides them from other relays until claimedUntil.
+	ClaimOutboxEvents(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]entities.OutboxEventModel, error)
+	MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	// MarkOutboxEventFailed records a failed attempt, the event is retried at nextAttemptAt.
+	MarkOutboxEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	// MarkOutboxEventDead records the last failed attempt, the event is not retried.
+	MarkOutboxEventDead(ctx context.Context, id int64, lastError string, deadAt time.Time) error
+	// DeleteDeliveredOutboxEvents deletes the events delivered before
+	// deliveredBefore, dead letters are kept for inspection.
+	DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error)
+}
+
+type OutboxStorageImpl struct {
+	DB *sql.DB
+}
+
+func NewOutboxStorage(db *sql.DB) *OutboxStorageImpl {
+	storage := &OutboxStorageImpl{DB: db}
+	return storage
+}
+
+// insertOutboxEvents writes events in the transaction of the change they describe.
+func insertOutboxEvents(ctx context.Context, tx *sql.Tx, events []entities.OutboxEventModel) error {
+	for _, e := range events {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO outbox(event_type, login, payload, created_at) VALUES ($1, $2, $3, $4)`,
+			e.EventType, e.Login, e.Payload, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *OutboxStorageImpl) ClaimOutboxEvents(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]entities.OutboxEventModel, error) {
+	// SKIP LOCKED lets concurrent relays claim different events instead of
+	// waiting for each other and then publishing the same ones
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`WITH claimed AS (
+					UPDATE outbox SET next_attempt_at = $2 WHERE id IN (
+						SELECT id FROM outbox
+						WHERE delivered_at IS NULL AND dead_at IS NULL AND next_attempt_at <= $1
+						ORDER BY next_attempt_at, id LIMIT $3 FOR UPDATE SKIP LOCKED)
+					RETURNING id, event_type, login, payload, created_at, attempts, last_error)
+				SELECT id, event_type, login, payload, created_at, attempts, last_error FROM claimed ORDER BY id`,
+		now, claimedUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var events []entities.OutboxEventModel
+	for rows.Next() {
+		var e entities.OutboxEventModel
+		if err := rows.Scan(&e.ID, &e.EventType, &e.Login, &e.Payload, &e.CreatedAt, &e.Attempts, &e.LastError); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *OutboxStorageImpl) MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE outbox SET delivered_at = $1, attempts = attempts + 1, last_error = '' WHERE id = $2`, deliveredAt, id)
+
+	return err
+}
+
+func (s *OutboxStorageImpl) MarkOutboxEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3`,
+		lastError, nextAttemptAt, id)
+
+	return err
+}
+
+func (s *OutboxStorageImpl) MarkOutboxEventDead(ctx context.Context, id int64, lastError string, deadAt time.Time) error {
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, last_error = $1, dead_at = $2 WHERE id = $3`, lastError, deadAt, id)
+
+	return err
+}
+
+func (s *OutboxStorageImpl) DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`DELETE FROM outbox WHERE delivered_at < $1`, deliveredBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
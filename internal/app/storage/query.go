@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"strings"
+)
+
+// listFilter builds the WHERE part of list queries so that the page and the
+// total count are computed by the same conditions.
+type listFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+func newListFilter(login string) *listFilter {
+	return &listFilter{conds: []string{"login = $1"}, args: []interface{}{login}}
+}
+
+func (f *listFilter) arg(v interface{}) string {
+	f.args = append(f.args, v)
+	return fmt.Sprintf("$%d", len(f.args))
+}
+
+func (f *listFilter) dateRange(column string, query entities.ListQuery) {
+	if query.From != nil {
+		f.conds = append(f.conds, fmt.Sprintf("%s >= %s", column, f.arg(*query.From)))
+	}
+	if query.To != nil {
+		f.conds = append(f.conds, fmt.Sprintf("%s < %s", column, f.arg(*query.To)))
+	}
+}
+
+func (f *listFilter) statuses(column string, statuses []string) {
+	if len(statuses) == 0 {
+		return
+	}
+	placeholders := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		placeholders = append(placeholders, f.arg(s))
+	}
+	f.conds = append(f.conds, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")))
+}
+
+func (f *listFilter) after(column string, query entities.ListQuery) {
+	if query.After == nil {
+		return
+	}
+	op := ">"
+	if query.Desc {
+		op = "<"
+	}
+	f.conds = append(f.conds, fmt.Sprintf("(%s, id) %s (%s, %s)",
+		column, op, f.arg(query.After.At), f.arg(query.After.ID)))
+}
+
+func (f *listFilter) where() string {
+	return strings.Join(f.conds, " AND ")
+}
+
+func orderBy(column string, query entities.ListQuery) string {
+	direction := "ASC"
+	if query.Desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, id %s", column, direction, direction)
+}
+
+func limit(f *listFilter, query entities.ListQuery) string {
+	if query.Limit <= 0 {
+		return ""
+	}
+	return "LIMIT " + f.arg(query.Limit)
+}
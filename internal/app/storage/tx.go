@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TxManager runs a closure atomically across storages. Storage calls made
+// with the context passed to fn take part in the transaction; nested calls
+// join the outer transaction.
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+// querier is the part of *sql.DB and *sql.Tx used by the storages.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type DBTxManager struct {
+	DB *sql.DB
+}
+
+func NewDBTxManager(db *sql.DB) *DBTxManager {
+	manager := &DBTxManager{DB: db}
+	return manager
+}
+
+func (m *DBTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// executor returns the transaction started by WithinTx, or the pool outside of it.
+func executor(ctx context.Context, db *sql.DB) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
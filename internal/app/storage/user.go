@@ -14,22 +14,16 @@ type UserStorage interface {
 }
 
 type UserStorageImpl struct {
-	ConnString string
+	DB *sql.DB
 }
 
-func NewUserStorage(connString string) *UserStorageImpl {
-	storage := &UserStorageImpl{ConnString: connString}
+func NewUserStorage(db *sql.DB) *UserStorageImpl {
+	storage := &UserStorageImpl{DB: db}
 	return storage
 }
 
 func (s *UserStorageImpl) InsertNewUser(ctx context.Context, user entities.UserModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`INSERT INTO users(login, password_hash, session) VALUES ($1, $2, $3)`,
 		user.Login, user.PasswordHash, user.Session)
 
@@ -37,13 +31,7 @@ func (s *UserStorageImpl) InsertNewUser(ctx context.Context, user entities.UserM
 }
 
 func (s *UserStorageImpl) UpdateUserSession(ctx context.Context, userSession entities.UserSessionModel) error {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(ctx,
+	_, err := executor(ctx, s.DB).ExecContext(ctx,
 		`UPDATE users SET session = $1 WHERE login = $2`,
 		userSession.Session, userSession.Login)
 
@@ -51,16 +39,10 @@ func (s *UserStorageImpl) UpdateUserSession(ctx context.Context, userSession ent
 }
 
 func (s *UserStorageImpl) GetUserIfExists(ctx context.Context, login string) (*entities.UserModel, error) {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return nil, err
-	}
-
-	defer conn.Close()
 	var user entities.UserModel
-	row := conn.QueryRowContext(ctx,
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
 		`SELECT login, password_hash FROM users WHERE login = $1`, login)
-	err = row.Scan(&user.Login, &user.PasswordHash)
+	err := row.Scan(&user.Login, &user.PasswordHash)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -73,16 +55,10 @@ func (s *UserStorageImpl) GetUserIfExists(ctx context.Context, login string) (*e
 }
 
 func (s *UserStorageImpl) GetUserBySessionIfExists(ctx context.Context, session string) (*entities.UserSessionModel, error) {
-	conn, err := connect(ctx, s.ConnString)
-	if err != nil {
-		return nil, err
-	}
-
-	defer conn.Close()
 	var userSession entities.UserSessionModel
-	row := conn.QueryRowContext(ctx,
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
 		`SELECT login, session FROM users WHERE session = $1`, session)
-	err = row.Scan(&userSession.Login, &userSession.Session)
+	err := row.Scan(&userSession.Login, &userSession.Session)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xbreathoflife/gophermart/internal/app/entities"
+	"strings"
+)
+
+type WebhookStorage interface {
+	InsertWebhook(ctx context.Context, webhook entities.WebhookModel) (int64, error)
+	DeleteWebhook(ctx context.Context, id int64, login string) (bool, error)
+	GetWebhookIfExists(ctx context.Context, id int64) (*entities.WebhookModel, error)
+	GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookModel, error)
+	InsertWebhookDelivery(ctx context.Context, delivery entities.WebhookDeliveryModel) (int64, error)
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]entities.WebhookDeliveryModel, error)
+}
+
+type WebhookStorageImpl struct {
+	DB *sql.DB
+}
+
+func NewWebhookStorage(db *sql.DB) *WebhookStorageImpl {
+	storage := &WebhookStorageImpl{DB: db}
+	return storage
+}
+
+func (s *WebhookStorageImpl) InsertWebhook(ctx context.Context, webhook entities.WebhookModel) (int64, error) {
+	var id int64
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
+		`INSERT INTO webhooks(login, url, secret, event_types, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		webhook.Login, webhook.URL, webhook.Secret, strings.Join(webhook.EventTypes, ","), webhook.CreatedAt)
+	err := row.Scan(&id)
+
+	return id, err
+}
+
+func (s *WebhookStorageImpl) DeleteWebhook(ctx context.Context, id int64, login string) (bool, error) {
+	res, err := executor(ctx, s.DB).ExecContext(ctx,
+		`DELETE FROM webhooks WHERE id = $1 AND login = $2`, id, login)
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected(res)
+}
+
+func (s *WebhookStorageImpl) GetWebhookIfExists(ctx context.Context, id int64) (*entities.WebhookModel, error) {
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
+		`SELECT id, login, url, secret, event_types, created_at FROM webhooks WHERE id = $1`, id)
+	webhook, err := scanWebhook(row)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *WebhookStorageImpl) GetWebhooksForUser(ctx context.Context, login string) ([]entities.WebhookModel, error) {
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`SELECT id, login, url, secret, event_types, created_at FROM webhooks
+				WHERE login = $1 ORDER BY id`, login)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var webhooks []entities.WebhookModel
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (s *WebhookStorageImpl) InsertWebhookDelivery(ctx context.Context, delivery entities.WebhookDeliveryModel) (int64, error) {
+	var id int64
+	row := executor(ctx, s.DB).QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, attempt, status_code, error, success, delivered_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		delivery.WebhookID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.Attempt,
+		delivery.StatusCode, delivery.Error, delivery.Success, delivery.DeliveredAt)
+	err := row.Scan(&id)
+
+	return id, err
+}
+
+func (s *WebhookStorageImpl) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]entities.WebhookDeliveryModel, error) {
+	rows, err := executor(ctx, s.DB).QueryContext(ctx,
+		`SELECT id, webhook_id, event_id, event_type, payload, attempt, status_code, error, success, delivered_at
+				FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY delivered_at DESC, id DESC LIMIT $2`,
+		webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var deliveries []entities.WebhookDeliveryModel
+	for rows.Next() {
+		var d entities.WebhookDeliveryModel
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Attempt,
+			&d.StatusCode, &d.Error, &d.Success, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row scanner) (*entities.WebhookModel, error) {
+	var webhook entities.WebhookModel
+	var eventTypes string
+	err := row.Scan(&webhook.ID, &webhook.Login, &webhook.URL, &webhook.Secret, &eventTypes, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if eventTypes != "" {
+		webhook.EventTypes = strings.Split(eventTypes, ",")
+	}
+	return &webhook, nil
+}
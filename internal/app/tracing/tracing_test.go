@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func setupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	var storageSpan trace.SpanContext
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, done := ObserveStorage(r.Context(), "OrderStorage.GetOrderIfExists")
+		storageSpan = trace.SpanContextFromContext(ctx)
+		done(errors.New("connection refused"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders/12345678903", nil)
+	req.Header.Set("traceparent", traceparent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	storage, server := spans[0], spans[1]
+
+	assert.Equal(t, "HTTP GET /api/user/orders/{number}", server.Name())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, codes.Error, server.Status().Code)
+	attrs := map[string]string{}
+	for _, kv := range server.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "500", attrs["http.status_code"])
+	assert.NotEmpty(t, attrs[string(RequestIDKey)])
+
+	assert.Equal(t, "OrderStorage.GetOrderIfExists", storage.Name())
+	assert.Equal(t, storageSpan.SpanID(), storage.SpanContext().SpanID())
+	assert.Equal(t, server.SpanContext().SpanID(), storage.Parent().SpanID())
+	assert.Equal(t, codes.Error, storage.Status().Code)
+}
+
+func TestInject(t *testing.T) {
+	setupRecorder(t)
+
+	ctx, span := Start(context.Background(), "accrual", "GET /api/orders/{number}")
+	defer span.End()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://accrual/api/orders/1", nil)
+	require.NoError(t, err)
+	Inject(req)
+
+	assert.Contains(t, req.Header.Get("traceparent"), span.SpanContext().TraceID().String())
+}
+
+func TestInit_UnknownExporter(t *testing.T) {
+	_, err := Init(context.Background(), Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+// Package webhook posts signed event payloads to the URLs users subscribed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Gophermart-Signature"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	EventTypeHeader = "X-Gophermart-Event"
+)
+
+// ErrForbiddenAddress is returned for deliveries to loopback, private,
+// link-local and unspecified addresses, which would let users reach the
+// internal network of the service.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+var forbiddenNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsForbiddenIP reports whether deliveries to ip are not allowed.
+func IsForbiddenIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddress runs before every connection, once the host is resolved, so
+// that DNS names of internal addresses are rejected as well.
+func checkAddress(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsForbiddenIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+type Sender struct {
+	Client *http.Client
+}
+
+// NewSender returns a sender whose deliveries time out after timeout unless it
+// is 0. Redirects are not followed, and unless allowPrivate deliveries to non
+// public addresses fail with ErrForbiddenAddress.
+func NewSender(timeout time.Duration, allowPrivate bool) *Sender {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = checkAddress
+	}
+	transport := &http.Transport{
+		// no Proxy: it would connect to the webhook, bypassing the address check
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &Sender{Client: &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Send makes a single signed POST and returns the response status code, 0 if
+// there was no response. Statuses other than 2xx are errors.
+func (s *Sender) Send(ctx context.Context, url string, secret string, eventType string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, eventType)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+SignPayload(secret, timestamp, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignPayload returns the hex HMAC-SHA256 of "timestamp.body" which receivers
+// use to check that the payload comes from us and is not replayed.
+func SignPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSender_PrivateNetworks(t *testing.T) {
+	delivered := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered++
+	}))
+	defer receiver.Close()
+
+	_, err := NewSender(time.Second, false).Send(context.Background(), receiver.URL, "secret", "test", nil)
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+	assert.Equal(t, 0, delivered)
+
+	statusCode, err := NewSender(time.Second, true).Send(context.Background(), receiver.URL, "secret", "test", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, 1, delivered)
+}
+
+func TestSender_Redirect(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect was followed")
+	}))
+	defer internal.Close()
+	receiver := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	statusCode, err := NewSender(time.Second, true).Send(context.Background(), receiver.URL, "secret", "test", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTemporaryRedirect, statusCode)
+}
+
+func TestIsForbiddenIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "::", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		assert.True(t, IsForbiddenIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "172.32.0.1", "2001:4860:4860::8888"} {
+		assert.False(t, IsForbiddenIP(net.ParseIP(ip)), ip)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS balance_withdrawals;
+
+DROP TABLE IF EXISTS balance;
+
+DROP TABLE IF EXISTS orders;
+
+DROP TABLE IF EXISTS users;
@@ -30,4 +30,4 @@ CREATE TABLE IF NOT EXISTS balance_withdrawals
     order_num    TEXT                     NOT NULL,
     sum          NUMERIC                  NOT NULL,
     processed_at TIMESTAMP WITH TIME ZONE NOT NULL
-);
\ No newline at end of file
+);
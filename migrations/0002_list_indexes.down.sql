@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS balance_withdrawals_login_processed_at_idx;
+
+DROP INDEX IF EXISTS orders_login_uploaded_at_idx;
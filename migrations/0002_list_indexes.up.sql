@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS orders_login_uploaded_at_idx ON orders (login, uploaded_at, id);
+
+CREATE INDEX IF NOT EXISTS balance_withdrawals_login_processed_at_idx ON balance_withdrawals (login, processed_at, id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS order_status_history
+(
+    id         SERIAL PRIMARY KEY,
+    order_num  TEXT                     NOT NULL REFERENCES orders (order_num) ON DELETE CASCADE,
+    status     TEXT                     NOT NULL,
+    accrual    NUMERIC,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_num_idx ON order_status_history (order_num, changed_at, id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    login        TEXT                     NOT NULL REFERENCES users (login),
+    key          TEXT                     NOT NULL,
+    request_hash TEXT                     NOT NULL,
+    status_code  INTEGER                  NOT NULL DEFAULT 0,
+    content_type TEXT                     NOT NULL DEFAULT '',
+    body         BYTEA,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (login, key)
+);
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id          SERIAL PRIMARY KEY,
+    login       TEXT                     NOT NULL REFERENCES users (login),
+    url         TEXT                     NOT NULL,
+    secret      TEXT                     NOT NULL,
+    event_types TEXT                     NOT NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_login_idx ON webhooks (login);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id           SERIAL PRIMARY KEY,
+    webhook_id   INTEGER                  NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id     BIGINT                   NOT NULL,
+    event_type   TEXT                     NOT NULL,
+    payload      TEXT                     NOT NULL,
+    attempt      INTEGER                  NOT NULL,
+    status_code  INTEGER                  NOT NULL,
+    error        TEXT                     NOT NULL,
+    success      BOOLEAN                  NOT NULL,
+    delivered_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, delivered_at);
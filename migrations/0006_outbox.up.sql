@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    event_type   TEXT                     NOT NULL,
+    login        TEXT                     NOT NULL,
+    payload      TEXT                     NOT NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    attempts     INTEGER                  NOT NULL DEFAULT 0,
+    last_error   TEXT                     NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE delivered_at IS NULL;
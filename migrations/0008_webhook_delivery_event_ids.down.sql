@@ -0,0 +1 @@
+ALTER TABLE webhook_deliveries ALTER COLUMN event_id TYPE BIGINT USING COALESCE(NULLIF(split_part(event_id, '-', 2), ''), '0')::BIGINT;
@@ -0,0 +1 @@
+ALTER TABLE webhook_deliveries ALTER COLUMN event_id TYPE TEXT;
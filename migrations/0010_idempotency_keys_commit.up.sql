@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS token TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS committed BOOLEAN NOT NULL DEFAULT FALSE;
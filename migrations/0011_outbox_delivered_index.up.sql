@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS outbox_delivered_idx ON outbox (delivered_at) WHERE delivered_at IS NOT NULL;
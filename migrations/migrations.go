@@ -0,0 +1,9 @@
+// Package migrations embeds the versioned schema migrations. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql; the up scripts use
+// IF NOT EXISTS so that databases created before versioning are adopted.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS